@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// maxBatchSize is the largest number of events accepted in a single batch.
+// This is the most entities Datastore will write in one PutMulti call.
+const maxBatchSize = 500
+
+// Names for each category of event in batch requests and responses.
+const (
+	replCommandsCategory   = "replCommands"
+	editorContentsCategory = "editorContents"
+	errorsCategory         = "errors"
+)
+
+// batchRequest is a collection of events of any kind to be stored at once.
+type batchRequest struct {
+	REPLCommands   []datatypes.REPLCommand   `json:"replCommands"`
+	EditorContents []datatypes.EditorContent `json:"editorContents"`
+	Errors         []datatypes.ErrorInstance `json:"errors"`
+}
+
+// batchResponse reports how many events of each category were stored and
+// which ones could not be.
+type batchResponse struct {
+	Stored map[string]int            `json:"stored"`
+	Failed map[string][]batchFailure `json:"failed,omitempty"`
+}
+
+// batchFailure describes an event in a batch that could not be stored.
+type batchFailure struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// batchEntry is an event in a batch along with where it came from in the
+// request.
+type batchEntry struct {
+	category string
+	index    int
+	kind     string
+	content  event
+}
+
+// newBatchHandler stores every event in a batchRequest in datastore with a
+// single write. Events that fail validation or can't be written are reported
+// by index instead of failing the whole batch.
+func newBatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := newContext(r)
+
+	var batch batchRequest
+	if err := decodeBody(r, &batch); err != nil {
+		logger.Warningf(ctx, "could not decode batch: %v", err)
+		http.Error(w, "Invalid batch: "+err.Error(), bodyErrorStatus(err))
+		return
+	}
+
+	var entries []batchEntry
+	for i := range batch.REPLCommands {
+		entries = append(entries, batchEntry{
+			replCommandsCategory, i, datatypes.REPLCommandKind, &batch.REPLCommands[i]})
+	}
+	for i := range batch.EditorContents {
+		entries = append(entries, batchEntry{
+			editorContentsCategory, i, datatypes.EditorContentKind, &batch.EditorContents[i]})
+	}
+	for i := range batch.Errors {
+		entries = append(entries, batchEntry{
+			errorsCategory, i, datatypes.ErrorInstanceKind, &batch.Errors[i]})
+	}
+
+	storeBatch(ctx, w, entries,
+		[]string{replCommandsCategory, editorContentsCategory, errorsCategory})
+}
+
+// kindCategories are the batch category of each kind of event.
+var kindCategories = map[string]string{
+	datatypes.REPLCommandKind:   replCommandsCategory,
+	datatypes.EditorContentKind: editorContentsCategory,
+	datatypes.ErrorInstanceKind: errorsCategory,
+}
+
+// storeEventArray stores every event in a JSON array of a single kind with a
+// single write, like a batch with only that kind's category. The content is
+// an example event whose type the array's elements are decoded into.
+func storeEventArray(w http.ResponseWriter, r *http.Request, kind, description string, content event) {
+	ctx := newContext(r)
+
+	if r.Header.Get(idempotencyKeyHeader) != "" {
+		http.Error(w, "Idempotency keys can't be used with arrays of events", http.StatusBadRequest)
+		return
+	}
+
+	array := reflect.New(reflect.SliceOf(reflect.TypeOf(content).Elem()))
+	if err := decodeBody(r, array.Interface()); err != nil {
+		logger.Warningf(ctx, "could not decode %v array: %v", description, err)
+		http.Error(w, "Invalid "+description+" array: "+err.Error(), bodyErrorStatus(err))
+		return
+	}
+
+	category := kindCategories[kind]
+	elems := array.Elem()
+	entries := make([]batchEntry, elems.Len())
+	for i := range entries {
+		entries[i] = batchEntry{category, i, kind, elems.Index(i).Addr().Interface().(event)}
+	}
+
+	storeBatch(ctx, w, entries, []string{category})
+}
+
+// batchKind returns the kind of every entry, or batchPutKind if they aren't
+// all the same.
+func batchKind(entries []batchEntry) string {
+	for _, entry := range entries[1:] {
+		if entry.kind != entries[0].kind {
+			return batchPutKind
+		}
+	}
+
+	return entries[0].kind
+}
+
+// putEntries writes the entries to the store with a single putMulti call.
+// If only some of them couldn't be written, the returned slice holds the
+// error of each entry, and is nil if all were written. An error is returned
+// if the write failed as a whole.
+func putEntries(ctx context.Context, entries []batchEntry) ([]error, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	kinds := make([]string, len(entries))
+	contents := make([]event, len(entries))
+	for i, entry := range entries {
+		kinds[i] = entry.kind
+		contents[i] = entry.content
+	}
+
+	start := now()
+	putErrs, err := store.putMulti(ctx, kinds, contents)
+	observePut(batchKind(entries), start)
+
+	return putErrs, err
+}
+
+// storeBatch stores the entries with a single write and responds with which
+// were stored. The categories are the ones included in the response even if
+// none of their entries were stored.
+func storeBatch(ctx context.Context, w http.ResponseWriter, entries []batchEntry, categories []string) {
+	if len(entries) > maxBatchSize {
+		http.Error(w,
+			fmt.Sprintf("Batches may contain at most %v events, got %v", maxBatchSize, len(entries)),
+			http.StatusBadRequest)
+		return
+	}
+
+	resp := batchResponse{
+		Stored: make(map[string]int),
+		Failed: make(map[string][]batchFailure),
+	}
+	for _, category := range categories {
+		resp.Stored[category] = 0
+	}
+
+	// Set aside invalid events so that the rest can still be written
+	var valid []batchEntry
+	for _, entry := range entries {
+		if err := prepareEvent(entry.content); err != nil {
+			resp.Failed[entry.category] = append(resp.Failed[entry.category],
+				batchFailure{entry.index, err.Error()})
+			continue
+		}
+		valid = append(valid, entry)
+	}
+
+	putErrs, err := putEntries(ctx, valid)
+	if err != nil {
+		logger.Errorf(ctx, "could not write batch to datastore: %v", err)
+		http.Error(w, "Could not save batch", 500)
+		return
+	}
+
+	var stored []batchEntry
+	for i, entry := range valid {
+		if putErrs != nil && putErrs[i] != nil {
+			logger.Errorf(ctx, "could not write %v %v to datastore: %v",
+				entry.category, entry.index, putErrs[i])
+			resp.Failed[entry.category] = append(resp.Failed[entry.category],
+				batchFailure{entry.index, "could not save event"})
+			continue
+		}
+		resp.Stored[entry.category]++
+		stored = append(stored, entry)
+	}
+	recordStoredEvents(ctx, stored)
+
+	for _, failures := range resp.Failed {
+		sort.Slice(failures, func(i, j int) bool {
+			return failures[i].Index < failures[j].Index
+		})
+	}
+
+	logger.Infof(ctx, "Saved batch %v", resp.Stored)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// cloudStore is an eventStore backed by the Cloud Datastore client, for when
+// the API isn't running on App Engine. It reads and writes the same entities
+// as appengineStore.
+type cloudStore struct {
+	client *datastore.Client
+}
+
+// loadCloudStore returns a cloudStore for the project named by the
+// DATASTORE_PROJECT_ID environment variable, or GOOGLE_CLOUD_PROJECT if it
+// isn't set. A client that can't be created stops the server from starting.
+func loadCloudStore() cloudStore {
+	projectID := os.Getenv("DATASTORE_PROJECT_ID")
+	if projectID == "" {
+		projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+
+	client, err := datastore.NewClient(context.Background(), projectID)
+	if err != nil {
+		panic("could not create Datastore client: " + err.Error())
+	}
+
+	return cloudStore{client}
+}
+
+func (s cloudStore) put(ctx context.Context, kind string, content event) (storeKey, error) {
+	return s.client.Put(ctx, datastore.IncompleteKey(kind, nil), content)
+}
+
+func (s cloudStore) putIfAbsent(ctx context.Context, kind, name string, content event) (event, storeKey, bool, error) {
+	key := datastore.NameKey(kind, name, nil)
+
+	var stored event
+	var created bool
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		existing := newEventLike(content)
+		err := tx.Get(key, existing)
+		if err == nil {
+			stored, created = existing, false
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		stored, created = content, true
+		_, err = tx.Put(key, content)
+		return err
+	})
+
+	return stored, key, created, err
+}
+
+func (s cloudStore) putMulti(ctx context.Context, kinds []string, contents []event) ([]error, error) {
+	keys := make([]*datastore.Key, len(kinds))
+	src := make([]interface{}, len(contents))
+	for i, kind := range kinds {
+		keys[i] = datastore.IncompleteKey(kind, nil)
+		src[i] = contents[i]
+	}
+
+	_, err := s.client.PutMulti(ctx, keys, src)
+	return splitCloudMultiError(err)
+}
+
+func (s cloudStore) getAll(ctx context.Context, kind, uid string, dst interface{}) error {
+	_, err := s.client.GetAll(ctx, datastore.NewQuery(kind).FilterField("UID", "=", uid), dst)
+	return err
+}
+
+func (s cloudStore) keys(ctx context.Context, kind, uid string) ([]storeKey, error) {
+	query := datastore.NewQuery(kind).
+		FilterField("UID", "=", uid).
+		KeysOnly()
+	keys, err := s.client.GetAll(ctx, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKeys := make([]storeKey, len(keys))
+	for i, key := range keys {
+		storeKeys[i] = key
+	}
+	return storeKeys, nil
+}
+
+func (s cloudStore) deleteMulti(ctx context.Context, keys []storeKey) ([]error, error) {
+	datastoreKeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		datastoreKeys[i] = key.(*datastore.Key)
+	}
+
+	return splitCloudMultiError(s.client.DeleteMulti(ctx, datastoreKeys))
+}
+
+func (s cloudStore) ping(ctx context.Context) error {
+	query := datastore.NewQuery(datatypes.REPLCommandKind).
+		KeysOnly().
+		Limit(1)
+	_, err := s.client.GetAll(ctx, query, nil)
+	return err
+}
+
+// splitCloudMultiError is like splitMultiError for the Cloud Datastore
+// client's errors.
+func splitCloudMultiError(err error) ([]error, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	multiErr, ok := err.(datastore.MultiError)
+	if !ok {
+		return nil, err
+	}
+	return multiErr, nil
+}
+
+// stdLogger writes logs with the standard library's logger, prefixed with
+// their level, for when the API isn't running on App Engine.
+type stdLogger struct {
+	*log.Logger
+}
+
+func (l stdLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.Printf("INFO: %v", fmt.Sprintf(format, args...))
+}
+
+func (l stdLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	l.Printf("WARNING: %v", fmt.Sprintf(format, args...))
+}
+
+func (l stdLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.Printf("ERROR: %v", fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"regexp"
+	"time"
+)
+
+// aggregatorOptions configures a sessionAggregator.
+type aggregatorOptions struct {
+	// location is the time zone that events are bucketed by hour of day in
+	location *time.Location
+	// top is the number of entries to include in rankings
+	top int
+	// paste decides which editor saves count as pastes
+	paste pasteThreshold
+	// editorRun matches REPL commands that run the editor's contents
+	editorRun *regexp.Regexp
+	// safeMinRuns is the fewest times a command must be run to be ranked
+	// as safe
+	safeMinRuns int
+	// errorBounds are the lower bounds of the buckets in the histogram of
+	// errors per session
+	errorBounds []int
+}
+
+// defaultErrorsPerSessionBounds is the default for the
+// -errors-per-session-bounds flag.
+const defaultErrorsPerSessionBounds = "0,1,3,6,11"
+
+// sessionAggregator accumulates the results of each session into a report.
+type sessionAggregator struct {
+	aggregatorOptions
+	report *Report
+
+	durations    []float64
+	commandCnts  []float64
+	successRates []float64
+	timesToError []float64
+	// editorCadences is the mean time between editor saves of each session
+	// that saved at least twice
+	editorCadences []float64
+	// editorRatios is the number of editor saves per REPL command of each
+	// session that ran at least one command
+	editorRatios []float64
+	// errorTypesPerUID is the number of distinct error types each UID hit
+	errorTypesPerUID []float64
+
+	// maxTimestamp is the timestamp of the latest event of any UID
+	maxTimestamp int64
+
+	// variableCnts is the number of distinct variables each session used
+	variableCnts []float64
+	// errorCnts is the number of errors in each session
+	errorCnts []int
+	// editorErrorCnts and nonEditorErrorCnts are the error counts of sessions
+	// that did and didn't use the editor
+	editorErrorCnts    []float64
+	nonEditorErrorCnts []float64
+
+	// days counts the sessions that started on each day, in location
+	days map[string]daySessions
+
+	// firstCommands is the number of sessions that started with each
+	// normalized command
+	firstCommands map[string]int
+	// erroringCommands is the number of times each normalized command was
+	// immediately followed by an error
+	erroringCommands map[string]int
+	// succeedingCommands is the number of times each normalized command
+	// wasn't followed by an error
+	succeedingCommands map[string]int
+	// silentFailures is the number of times each normalized command's result
+	// looked like an error but no error followed it
+	silentFailures map[string]int
+	// deadEndCommands is the number of sessions that ended in an error after
+	// each normalized command
+	deadEndCommands map[string]int
+}
+
+func newSessionAggregator(report *Report, options aggregatorOptions) *sessionAggregator {
+	report.ActivityTimeZone = options.location.String()
+	report.Funnel = newFunnel()
+	return &sessionAggregator{
+		aggregatorOptions:  options,
+		report:             report,
+		firstCommands:      make(map[string]int),
+		days:               make(map[string]daySessions),
+		deadEndCommands:    make(map[string]int),
+		erroringCommands:   make(map[string]int),
+		succeedingCommands: make(map[string]int),
+		silentFailures:     make(map[string]int),
+	}
+}
+
+// addUID includes the results that consider all of a UID's events at once,
+// rather than one session at a time, in the report. The session must hold
+// every event of the UID.
+func (a *sessionAggregator) addUID(sess session) {
+	a.errorTypesPerUID = append(a.errorTypesPerUID, float64(sess.distinctErrorTypes()))
+	if incidents := sess.skewIncidents(); incidents > 0 {
+		a.report.SkewIncidents += incidents
+		if worst := a.report.WorstSkewUID; worst == nil || incidents > worst.Count {
+			a.report.WorstSkewUID = &RankedValue{Value: sess.uid, Count: incidents}
+		}
+	}
+
+	if len(sess.events) > 0 {
+		if last := sess.events[len(sess.events)-1].getTimestamp(); last > a.maxTimestamp {
+			a.maxTimestamp = last
+		}
+	}
+}
+
+// add includes the results of a session in the report. The index is the
+// position of the session among its UID's sessions.
+func (a *sessionAggregator) add(sess session, index int) {
+	report := a.report
+
+	sessionReport := SessionReport{
+		UID:       sess.uid,
+		SessionID: sess.sessionID,
+		Index:     index,
+	}
+
+	sessionReport.Duration = sess.duration()
+	a.durations = append(a.durations, float64(sessionReport.Duration))
+
+	sessionReport.CommandCount = sess.commandCount()
+	if sess.ranNoCommands() {
+		report.NoCommandSessions = append(report.NoCommandSessions, SessionRef{
+			UID:       sess.uid,
+			SessionID: sess.sessionID,
+			Index:     index,
+		})
+	}
+	a.commandCnts = append(a.commandCnts, float64(sessionReport.CommandCount))
+	if cmd, ok := sess.firstCommand(); ok {
+		a.firstCommands[normalizeCommand(cmd)]++
+	}
+	for _, pair := range sess.commandAndErrors() {
+		if pair.noCmd {
+			continue
+		}
+
+		if pair.err != nil {
+			a.erroringCommands[normalizeCommand(pair.cmd.Command)]++
+		} else {
+			a.succeedingCommands[normalizeCommand(pair.cmd.Command)]++
+			if resultLooksLikeError(pair.cmd.Result) {
+				a.silentFailures[normalizeCommand(pair.cmd.Command)]++
+				report.SilentFailureCount++
+			}
+		}
+	}
+
+	if endedInError, cmd, hasCmd := sess.endedInError(); endedInError {
+		if hasCmd {
+			a.deadEndCommands[normalizeCommand(cmd)]++
+		} else {
+			report.ErrorEndingsWithoutCommand++
+		}
+	}
+
+	sessionReport.ErrorCount = sess.errorCount()
+	a.errorCnts = append(a.errorCnts, sessionReport.ErrorCount)
+	sessionReport.UsedEditor = sess.usedEditor()
+	if sessionReport.UsedEditor {
+		a.editorErrorCnts = append(a.editorErrorCnts, float64(sessionReport.ErrorCount))
+	} else {
+		a.nonEditorErrorCnts = append(a.nonEditorErrorCnts, float64(sessionReport.ErrorCount))
+	}
+
+	recovery := sess.errorRecovery()
+	report.ErrorRecovery.Recovered += recovery.recovered
+	report.ErrorRecovery.KeptErroring += recovery.keptErroring
+	report.ErrorRecovery.SessionEnded += recovery.ended
+
+	if rate, ok := sess.successRate(); ok {
+		sessionReport.SuccessRate = &rate
+		a.successRates = append(a.successRates, rate)
+	}
+
+	for _, streak := range sess.retryStreaks() {
+		sessionReport.RetryStreakCount++
+		report.RetryStreakCount++
+
+		longest := report.LongestRetryStreak
+		if longest == nil || streak.length > longest.Length {
+			report.LongestRetryStreak = &RetryStreak{
+				UID:     sess.uid,
+				Command: streak.command,
+				Length:  streak.length,
+			}
+		}
+	}
+
+	if stage := sess.furthestStage(); stage >= 0 {
+		sessionReport.FurthestStage = funnelStages[stage]
+		for i := 0; i <= stage; i++ {
+			report.Funnel[i].Sessions++
+		}
+	}
+
+	if cadence, idleGaps, ok := sess.editorCadence(); ok {
+		sessionReport.EditorCadence = &cadence
+		a.editorCadences = append(a.editorCadences, cadence)
+		sessionReport.EditorIdleGaps = idleGaps
+		report.EditorIdleGaps += idleGaps
+	}
+
+	sessionReport.DistinctVariables = sess.distinctVariables()
+	a.variableCnts = append(a.variableCnts, float64(sessionReport.DistinctVariables))
+
+	sessionReport.EditorSaves = sess.editorSaveCount()
+	if ratio, ok := sess.editorRatio(); ok {
+		sessionReport.EditorRatio = &ratio
+		a.editorRatios = append(a.editorRatios, ratio)
+	} else if sessionReport.EditorSaves > 0 {
+		report.EditorOnlySessions++
+	}
+
+	if !sess.ranEditorCode(a.editorRun) {
+		report.UnusedEditorSessions++
+		if len(report.UnusedEditorExamples) < maxUnusedEditorExamples {
+			report.UnusedEditorExamples = append(report.UnusedEditorExamples, SessionRef{
+				UID:       sess.uid,
+				SessionID: sess.sessionID,
+				Index:     index,
+			})
+		}
+	}
+
+	sessionReport.PasteCount = sess.pasteCount(a.paste)
+	report.PasteCount += sessionReport.PasteCount
+
+	sessionReport.LinesAdded, sessionReport.LinesRemoved = sess.editorChanges()
+	report.LinesAdded += sessionReport.LinesAdded
+	report.LinesRemoved += sessionReport.LinesRemoved
+
+	a.timesToError = append(a.timesToError, sess.timesToError()...)
+
+	for _, e := range sess.events {
+		report.ActivityByHour[eventHour(e, a.location)]++
+	}
+
+	if len(sess.events) > 0 {
+		date := eventDate(sess.events[0].getTimestamp(), a.location)
+		day := a.days[date]
+		day.sessions++
+		if sessionReport.UsedEditor {
+			day.editorSessions++
+		}
+		a.days[date] = day
+	}
+
+	report.Sessions = append(report.Sessions, sessionReport)
+}
+
+// eventHour returns the hour of the day, from 0 to 23, that the event happened
+// in the given time zone.
+func eventHour(e event, location *time.Location) int {
+	return millisToTime(e.getTimestamp()).In(location).Hour()
+}
+
+// millisToTime converts a timestamp in Unix milliseconds to a time.
+func millisToTime(timestamp int64) time.Time {
+	return time.Unix(timestamp/1000, (timestamp%1000)*int64(time.Millisecond))
+}
+
+// finish fills in the parts of the report that summarize every session. It
+// must be called after all sessions have been added.
+func (a *sessionAggregator) finish() {
+	a.report.SessionDuration = newDistribution(a.durations)
+	a.report.CommandsPerSession = newDistribution(a.commandCnts)
+	if avg, ok := mean(a.successRates); ok {
+		a.report.AverageSuccessRate = &avg
+	}
+	a.report.TimeToError = newDistribution(a.timesToError)
+	a.report.ErrorsPerSessionHistogram = newHistogram(a.errorCnts, a.errorBounds)
+	a.report.EditorCadence = newDistribution(a.editorCadences)
+	a.report.EditorRatio = newDistribution(a.editorRatios)
+	a.report.VariablesPerSession = newDistribution(a.variableCnts)
+	a.report.ErrorTypesPerUID = newDistribution(a.errorTypesPerUID)
+	recovery := &a.report.ErrorRecovery
+	if total := recovery.Recovered + recovery.KeptErroring + recovery.SessionEnded; total > 0 {
+		rate := float64(recovery.Recovered) / float64(total)
+		recovery.RecoveryRate = &rate
+	}
+	a.report.FirstCommands = rank(a.firstCommands, a.top)
+	a.report.DeadEndCommands = rank(a.deadEndCommands, a.top)
+	a.report.ErroringCommands = rank(a.erroringCommands, a.top)
+	a.report.SilentFailures = rank(a.silentFailures, a.top)
+	a.report.SafeCommands = rankSafeCommands(a.succeedingCommands, a.erroringCommands, a.safeMinRuns, a.top)
+	a.report.Friction = rankFriction(map[string][]RankedValue{
+		unknownCallableFriction: a.report.UnknownCallables,
+		variableNoValueFriction: a.report.VariablesWithNoValue,
+		erroringCommandFriction: a.report.ErroringCommands,
+	}, a.top)
+
+	rates := &a.report.EditorErrorRates
+	rates.EditorSessions = len(a.editorErrorCnts)
+	rates.NonEditorSessions = len(a.nonEditorErrorCnts)
+	if avg, ok := mean(a.editorErrorCnts); ok {
+		rates.EditorErrorsPerSession = &avg
+	}
+	if avg, ok := mean(a.nonEditorErrorCnts); ok {
+		rates.NonEditorErrorsPerSession = &avg
+	}
+	if rates.EditorErrorsPerSession != nil && rates.NonEditorErrorsPerSession != nil {
+		delta := *rates.EditorErrorsPerSession - *rates.NonEditorErrorsPerSession
+		rates.Delta = &delta
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// loadLocation returns the named time zone, failing the test if it can't be
+// loaded.
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %v: %v", name, err)
+	}
+	return location
+}
+
+// utcMillis returns the Unix milliseconds of a time in UTC.
+func utcMillis(year int, month time.Month, day, hour, min, sec, msec int) int64 {
+	return time.Date(year, month, day, hour, min, sec, msec*int(time.Millisecond), time.UTC).UnixNano() / int64(time.Millisecond)
+}
+
+func TestEventHour(t *testing.T) {
+	newYork := loadLocation(t, "America/New_York")
+	kolkata := loadLocation(t, "Asia/Kolkata")
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		location  *time.Location
+		want      int
+	}{
+		{"utc before the hour", utcMillis(2021, time.July, 1, 13, 59, 59, 999), time.UTC, 13},
+		{"utc on the hour", utcMillis(2021, time.July, 1, 14, 0, 0, 0), time.UTC, 14},
+		// New York is 4 hours behind UTC in the summer...
+		{"new york before the hour", utcMillis(2021, time.July, 1, 13, 59, 59, 999), newYork, 9},
+		{"new york on the hour", utcMillis(2021, time.July, 1, 14, 0, 0, 0), newYork, 10},
+		// ...and 5 in the winter
+		{"new york in winter", utcMillis(2021, time.January, 1, 14, 0, 0, 0), newYork, 9},
+		// The previous day in New York
+		{"new york before midnight", utcMillis(2021, time.July, 1, 3, 59, 59, 999), newYork, 23},
+		{"new york at midnight", utcMillis(2021, time.July, 1, 4, 0, 0, 0), newYork, 0},
+		// Kolkata is 5 and a half hours ahead, so its hours start on the
+		// half hour in UTC
+		{"kolkata before the hour", utcMillis(2021, time.July, 1, 13, 29, 59, 999), kolkata, 18},
+		{"kolkata on the hour", utcMillis(2021, time.July, 1, 13, 30, 0, 0), kolkata, 19},
+		{"kolkata on the utc hour", utcMillis(2021, time.July, 1, 14, 0, 0, 0), kolkata, 19},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := replEvent(datatypes.REPLCommand{Timestamp: test.timestamp})
+			if got := eventHour(e, test.location); got != test.want {
+				t.Errorf("eventHour(%v) = %v, want %v", millisToTime(test.timestamp).UTC(), got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivityByHour(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	aggregator.location = loadLocation(t, "America/New_York")
+
+	// Events of every kind on either side of 10:00 in New York are bucketed
+	// separately, even in the same session
+	sess := session{uid: "player", events: []event{
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: utcMillis(2021, time.July, 1, 13, 59, 0, 0)}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: utcMillis(2021, time.July, 1, 13, 59, 59, 999)}),
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: utcMillis(2021, time.July, 1, 14, 0, 0, 0)}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: utcMillis(2021, time.July, 1, 14, 1, 0, 0)}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: utcMillis(2021, time.July, 1, 14, 59, 59, 999)}),
+	}}
+	aggregator.add(sess, 0)
+	aggregator.finish()
+
+	var want [24]int
+	want[9] = 2
+	want[10] = 3
+	if report.ActivityByHour != want {
+		t.Errorf("ActivityByHour = %v, want %v", report.ActivityByHour, want)
+	}
+}
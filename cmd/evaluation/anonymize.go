@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// anonymizer replaces UIDs with pseudonymous tokens. The same UID always gets
+// the same token from an anonymizer, but tokens can't be traced back to UIDs
+// without the salt.
+type anonymizer struct {
+	salt []byte
+}
+
+// newAnonymizer creates an anonymizer with the given salt. If the salt is
+// empty, a random one is used and the tokens won't match those of any other
+// run.
+func newAnonymizer(salt string) (anonymizer, error) {
+	if salt != "" {
+		return anonymizer{[]byte(salt)}, nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return anonymizer{}, err
+	}
+
+	return anonymizer{random}, nil
+}
+
+// token returns the pseudonymous token for the UID.
+func (a anonymizer) token(uid string) string {
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(uid))
+
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
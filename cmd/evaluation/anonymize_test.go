@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymizerToken(t *testing.T) {
+	first, err := newAnonymizer("first salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newAnonymizer("second salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := first.token("player")
+	if token != first.token("player") {
+		t.Error("the same UID got different tokens from the same anonymizer")
+	}
+	if strings.Contains(token, "player") {
+		t.Errorf("token %q contains the UID", token)
+	}
+	if token == first.token("other") {
+		t.Error("different UIDs got the same token")
+	}
+	if token == second.token("player") {
+		t.Error("the same UID got the same token with different salts")
+	}
+
+	// Regenerating with the same salt gives the same mapping
+	again, err := newAnonymizer("first salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.token("player") != token {
+		t.Error("an anonymizer with the same salt gave a different token")
+	}
+}
+
+func TestAnonymizerRandomSalt(t *testing.T) {
+	first, err := newAnonymizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newAnonymizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.token("player") == second.token("player") {
+		t.Error("anonymizers with random salts gave the same token")
+	}
+}
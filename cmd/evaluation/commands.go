@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+var (
+	whitespacePattern    = regexp.MustCompile(`\s+`)
+	numberPattern        = regexp.MustCompile(`\b\d+(\.\d+)?\b`)
+	stringLiteralPattern = regexp.MustCompile(`"(\\.|[^"\\])*"`)
+)
+
+// normalizeCommand reduces a REPL command to a canonical form so that
+// commands which only differ superficially are counted together. The rules
+// are applied in this order:
+//
+//   - Leading and trailing whitespace is removed
+//   - String literals are replaced with "<str>"
+//   - The command is lowercased
+//   - Runs of whitespace are collapsed into a single space
+//   - Numeric literals are replaced with "<n>"
+func normalizeCommand(command string) string {
+	command = strings.TrimSpace(command)
+	command = stringLiteralPattern.ReplaceAllString(command, "<str>")
+	command = strings.ToLower(command)
+	command = whitespacePattern.ReplaceAllString(command, " ")
+	command = numberPattern.ReplaceAllString(command, "<n>")
+
+	return command
+}
+
+// errorResultPrefixes are how results that report an error start, after being
+// lowercased.
+var errorResultPrefixes = []string{"error", "exception"}
+
+// resultLooksLikeError returns true if a REPL command's result reads like an
+// error message, either because it matches an error pattern or because it
+// starts with one of errorResultPrefixes. An empty result never does.
+func resultLooksLikeError(result string) bool {
+	result = strings.TrimSpace(result)
+	if result == "" {
+		return false
+	}
+
+	lower := strings.ToLower(result)
+	for _, prefix := range errorResultPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+
+	// The patterns are case-sensitive, so they're matched against the
+	// result as it was returned
+	_, ok := classifyError(result)
+	return ok
+}
+
+// commandCategory is a game subsystem that REPL commands can target, and a
+// regular expression that matches normalized commands targeting it.
+type commandCategory struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// otherCategory is the category of commands that match no commandCategory.
+const otherCategory = "other"
+
+// commandCategories are the game subsystems in priority order. A command that
+// mentions more than one subsystem, like powering the propellant with the
+// generator, is assigned to the first one it matches.
+var commandCategories = []commandCategory{
+	{"propellant", regexp.MustCompile(`propellant`)},
+	{"lights", regexp.MustCompile(`light`)},
+	{"thrusters", regexp.MustCompile(`thruster`)},
+	{"switches", regexp.MustCompile(`switch`)},
+	{"generators", regexp.MustCompile(`generator`)},
+}
+
+// categorizeCommand returns the category of the given normalized command.
+func categorizeCommand(normalized string) string {
+	for _, category := range commandCategories {
+		if category.pattern.MatchString(normalized) {
+			return category.name
+		}
+	}
+
+	return otherCategory
+}
+
+// commandLengthBounds are the lower bounds of the buckets in the histogram of
+// command lengths.
+var commandLengthBounds = []int{0, 10, 20, 30, 40, 50, 75, 100, 200}
+
+// commandAnalysis accumulates statistics over REPL commands.
+type commandAnalysis struct {
+	// counts is the number of times each normalized command was run
+	counts map[string]int
+	// categories is the number of commands run in each commandCategory
+	categories map[string]int
+	// callables is the number of times each function was called, counting the
+	// nested calls of each command
+	callables map[string]int
+	// unparsable is the number of commands that couldn't be parsed as
+	// s-expressions, whose calls aren't counted
+	unparsable int
+	// lengths is the number of commands of each length in characters,
+	// ignoring trailing whitespace. Counting by length keeps this as small as
+	// the number of distinct lengths, however many commands there are.
+	lengths map[int]int
+}
+
+func newCommandAnalysis() *commandAnalysis {
+	return &commandAnalysis{
+		counts:     make(map[string]int),
+		categories: make(map[string]int),
+		callables:  make(map[string]int),
+		lengths:    make(map[int]int),
+	}
+}
+
+// add includes the command in the analysis.
+func (a *commandAnalysis) add(cmd datatypes.REPLCommand) {
+	normalized := normalizeCommand(cmd.Command)
+
+	a.counts[normalized]++
+	a.categories[categorizeCommand(normalized)]++
+
+	callables, err := commandCallables(cmd.Command)
+	if err != nil {
+		a.unparsable++
+	}
+	for _, callable := range callables {
+		a.callables[callable]++
+	}
+
+	trimmed := strings.TrimRightFunc(cmd.Command, unicode.IsSpace)
+	a.lengths[utf8.RuneCountInString(trimmed)]++
+}
+
+// analyzeCommands runs a commandAnalysis over every REPL command that matches
+// the filter.
+func analyzeCommands(ctx context.Context, client store, filter queryFilter) (*commandAnalysis, error) {
+	query := filter.query(datatypes.REPLCommandKind)
+
+	analysis := newCommandAnalysis()
+
+	var cmd datatypes.REPLCommand
+	err := runPaged(ctx, client, query, &cmd, func() {
+		if filter.includesUID(cmd.UID) {
+			analysis.add(cmd)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting REPL commands: %v", err)
+	}
+
+	return analysis, nil
+}
+
+// defaultSafeCommandMinRuns is the default for the fewest times a command
+// must be run to be considered safe.
+const defaultSafeCommandMinRuns = 5
+
+// maxSafeFailureRate is the largest fraction of a command's runs that may be
+// followed by an error for it to still be considered safe.
+const maxSafeFailureRate = 0.05
+
+// rankSafeCommands ranks the normalized commands that were run at least
+// minRuns times and rarely if ever followed by an error, by how many times
+// they succeeded. If n is positive, only the first n are returned.
+func rankSafeCommands(successes, failures map[string]int, minRuns, n int) []RankedValue {
+	safe := make(map[string]int)
+	for cmd, succeeded := range successes {
+		runs := succeeded + failures[cmd]
+		if runs < minRuns {
+			continue
+		}
+		if float64(failures[cmd])/float64(runs) > maxSafeFailureRate {
+			continue
+		}
+
+		safe[cmd] = succeeded
+	}
+
+	return rank(safe, n)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestNormalizeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"(fire)", "(fire)"},
+		{"  (fire)\n", "(fire)"},
+		{"(FIRE)", "(fire)"},
+		{"(set-speed   10)", "(set-speed <n>)"},
+		{"(move\tship\n2)", "(move ship <n>)"},
+		{"(set-speed 1.5)", "(set-speed <n>)"},
+		{"(set-speed -3)", "(set-speed -<n>)"},
+		{"(set-speed 10 20)", "(set-speed <n> <n>)"},
+		// Digits that are part of a name aren't numbers
+		{"(thruster2 on)", "(thruster2 on)"},
+		// Strings are replaced before lowercasing and collapsing whitespace,
+		// so neither changes what's inside them
+		{`(print "Hello   World")`, "(print <str>)"},
+		{`(print "say \"hi\" 3")`, "(print <str>)"},
+		{`(print "a" "b")`, "(print <str> <str>)"},
+		{"", ""},
+		{"   ", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			if got := normalizeCommand(test.command); got != test.want {
+				t.Errorf("normalizeCommand(%q) = %q, want %q", test.command, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandLengths(t *testing.T) {
+	analysis := newCommandAnalysis()
+	for _, command := range []string{
+		"(fire)",   // 6
+		"(fire)  ", // 6, since trailing whitespace isn't counted
+		"(fire)\n", // 6
+		"  (help)", // 8, since leading whitespace is
+		"(say \"héllo\")",
+		"(set-speed (+ 1 (* 2 3)))",
+		"(set-thruster 1 on)",
+		"",
+	} {
+		analysis.add(datatypes.REPLCommand{UID: "player", Command: command})
+	}
+
+	// Lengths of 0, 6, 6, 6, 8, 13, 19 and 25, with multi-byte characters
+	// counted once
+	want := &Distribution{Count: 8, Min: 0, Mean: 10.375, Median: 7, P90: 20.8, Max: 25}
+	got := newCountDistribution(analysis.lengths)
+	if got == nil || math.Abs(got.P90-want.P90) > 1e-9 {
+		t.Fatalf("CommandLengths = %+v, want %+v", got, want)
+	}
+	got.P90 = want.P90
+	if *got != *want {
+		t.Errorf("CommandLengths = %+v, want %+v", got, want)
+	}
+
+	// Buckets of 0-9, 10-19, 20-29, ...
+	histogram := newCountHistogram(analysis.lengths, commandLengthBounds)
+	wantCounts := []int{5, 2, 1, 0, 0, 0, 0, 0, 0}
+	for i, bucket := range histogram {
+		if bucket.Count != wantCounts[i] {
+			t.Errorf("bucket %v: count = %v, want %v", bucket, bucket.Count, wantCounts[i])
+		}
+	}
+}
+
+func TestNewCountDistribution(t *testing.T) {
+	// The summary of counted values is the same as of the values themselves
+	tests := [][]int{
+		{7},
+		{1, 2},
+		{5, 5, 5},
+		{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5},
+		{0, 100, 100, 100, 1, 1, 2, 50},
+	}
+
+	for _, values := range tests {
+		t.Run(fmt.Sprint(values), func(t *testing.T) {
+			counts := make(map[int]int)
+			floats := make([]float64, len(values))
+			for i, value := range values {
+				counts[value]++
+				floats[i] = float64(value)
+			}
+
+			got, want := newCountDistribution(counts), newDistribution(floats)
+			if math.Abs(got.Mean-want.Mean) > 1e-9 || math.Abs(got.P90-want.P90) > 1e-9 {
+				t.Fatalf("newCountDistribution() = %+v, want %+v", got, want)
+			}
+			got.Mean, got.P90 = want.Mean, want.P90
+			if *got != *want {
+				t.Errorf("newCountDistribution() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if got := newCountDistribution(map[int]int{}); got != nil {
+		t.Errorf("with no values, newCountDistribution() = %+v, want nil", got)
+	}
+}
+
+func TestRankSafeCommands(t *testing.T) {
+	successes := map[string]int{
+		"(fire)":          40,
+		"(help)":          20,
+		"(set-speed <n>)": 30,
+		"(flip-switch)":   19,
+		"(warp)":          2,
+		"(status)":        5,
+	}
+	failures := map[string]int{
+		// 1 of 20 runs failing is a rate of exactly maxSafeFailureRate
+		"(flip-switch)": 1,
+		// 2 of 32 is more than it
+		"(set-speed <n>)": 2,
+		"(eject)":         10,
+	}
+
+	tests := []struct {
+		name    string
+		minRuns int
+		n       int
+		want    []RankedValue
+	}{
+		{"min runs", 5, 0, []RankedValue{{"(fire)", 40}, {"(help)", 20}, {"(flip-switch)", 19}, {"(status)", 5}}},
+		// One-off commands that always succeed are left out
+		{"more min runs", 6, 0, []RankedValue{{"(fire)", 40}, {"(help)", 20}, {"(flip-switch)", 19}}},
+		// Failures count towards the number of runs
+		{"failures reach min runs", 20, 0, []RankedValue{{"(fire)", 40}, {"(help)", 20}, {"(flip-switch)", 19}}},
+		{"no min runs", 0, 0, []RankedValue{{"(fire)", 40}, {"(help)", 20}, {"(flip-switch)", 19}, {"(status)", 5}, {"(warp)", 2}}},
+		{"top", 5, 2, []RankedValue{{"(fire)", 40}, {"(help)", 20}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := rankSafeCommands(successes, failures, test.minRuns, test.n)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("rankSafeCommands() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSafeCommands(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+
+	// (fire) always succeeds, (help) fails once in 6 runs, and (eject) only
+	// ever fails
+	commands := []string{"(fire)", "(help)", "(eject)"}
+	for i := 0; i < defaultSafeCommandMinRuns+1; i++ {
+		erroring := map[int]bool{2: true}
+		if i == 0 {
+			erroring[1] = true
+		}
+		aggregator.add(erroringSession("player", commands, erroring), i)
+	}
+	aggregator.finish()
+
+	want := []RankedValue{{"(fire)", defaultSafeCommandMinRuns + 1}}
+	if !reflect.DeepEqual(report.SafeCommands, want) {
+		t.Errorf("SafeCommands = %v, want %v", report.SafeCommands, want)
+	}
+}
+
+func TestResultLooksLikeError(t *testing.T) {
+	tests := []struct {
+		result string
+		want   bool
+	}{
+		{"", false},
+		{"   \n", false},
+		{"ok", false},
+		{"42", false},
+		{"(1 2 3)", false},
+		{"Error: speed must be positive", true},
+		{"error", true},
+		{"  ERROR: out of fuel", true},
+		{"Exception in thruster 2", true},
+		// The prefixes have to start the result
+		{"no error", false},
+		{"Terror", false},
+		// Results that match an error pattern, in its case
+		{"Too many arguments", true},
+		{"Variable speed has no value", true},
+		{"  Unknown callable 'fly'\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.result, func(t *testing.T) {
+			if got := resultLooksLikeError(test.result); got != test.want {
+				t.Errorf("resultLooksLikeError(%q) = %v, want %v", test.result, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSilentFailures(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	sess := session{uid: "player", events: []event{
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire 1 2)", Result: "Too many arguments"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire 3 4)", Result: "Error: out of fuel"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 3000, Command: "(fire)", Result: "ok"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 4000, Command: "(help)"}),
+		// A failure with its own error isn't silent
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 5000, Command: "(help 1)", Result: "Too many arguments"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 5000, Description: "Too many arguments"}),
+	}}
+	aggregator.add(sess, 0)
+	aggregator.finish()
+
+	want := []RankedValue{{"(fire <n> <n>)", 2}}
+	if !reflect.DeepEqual(report.SilentFailures, want) {
+		t.Errorf("SilentFailures = %v, want %v", report.SilentFailures, want)
+	}
+	if report.SilentFailureCount != 2 {
+		t.Errorf("SilentFailureCount = %v, want 2", report.SilentFailureCount)
+	}
+}
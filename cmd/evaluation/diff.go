@@ -0,0 +1,90 @@
+package main
+
+import "strings"
+
+// editorChanges returns the total number of lines added to and removed from
+// the editor over the session, by diffing each save against the one before
+// it. The first save is diffed against an empty editor, so all of its lines
+// count as added.
+func (u *session) editorChanges() (added, removed int) {
+	previous := ""
+	for _, e := range u.events {
+		save, ok := e.(editorEvent)
+		if !ok {
+			continue
+		}
+
+		a, r := lineDiff(previous, save.Content)
+		added += a
+		removed += r
+		previous = save.Content
+	}
+
+	return added, removed
+}
+
+// lineDiff returns the number of lines that were added and removed to get
+// from before to after. Lines that appear in the longest common subsequence
+// of the two are unchanged and everything else counts as added or removed.
+func lineDiff(before, after string) (added, removed int) {
+	beforeLines := splitLines(before)
+	afterLines := splitLines(after)
+
+	// Most saves only change a few lines in the middle, so the lines they
+	// start and end with are matched up front. That keeps the quadratic LCS
+	// to the part that changed, even for large editor contents.
+	prefix := 0
+	for prefix < len(beforeLines) && prefix < len(afterLines) && beforeLines[prefix] == afterLines[prefix] {
+		prefix++
+	}
+	beforeLines, afterLines = beforeLines[prefix:], afterLines[prefix:]
+
+	suffix := 0
+	for suffix < len(beforeLines) && suffix < len(afterLines) &&
+		beforeLines[len(beforeLines)-1-suffix] == afterLines[len(afterLines)-1-suffix] {
+		suffix++
+	}
+	beforeLines = beforeLines[:len(beforeLines)-suffix]
+	afterLines = afterLines[:len(afterLines)-suffix]
+
+	common := lcsLength(beforeLines, afterLines)
+
+	return len(afterLines) - common, len(beforeLines) - common
+}
+
+// splitLines splits text into lines. Empty text has no lines.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\n")
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b,
+// using memory proportional to the shorter of the two.
+func lcsLength(a, b []string) int {
+	if len(b) > len(a) {
+		a, b = b, a
+	}
+
+	// Only the previous row of the dynamic programming table is needed to
+	// compute the next one
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				current[j] = previous[j-1] + 1
+			} else if previous[j] >= current[j-1] {
+				current[j] = previous[j]
+			} else {
+				current[j] = current[j-1]
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		before      string
+		after       string
+		wantAdded   int
+		wantRemoved int
+	}{
+		{"first save", "", "(define x 1)\n(fire)", 2, 0},
+		{"cleared", "(define x 1)\n(fire)", "", 0, 2},
+		{"both empty", "", "", 0, 0},
+		{"unchanged", "(define x 1)\n(fire)", "(define x 1)\n(fire)", 0, 0},
+		{"line appended", "(define x 1)", "(define x 1)\n(fire)", 1, 0},
+		{"line prepended", "(fire)", "(define x 1)\n(fire)", 1, 0},
+		{"line inserted", "a\nc", "a\nb\nc", 1, 0},
+		{"line removed", "a\nb\nc", "a\nc", 0, 1},
+		// Changing a line removes the old one and adds the new one
+		{"line changed", "a\nb\nc", "a\nB\nc", 1, 1},
+		{"every line changed", "a\nb", "c\nd", 2, 2},
+		// The LCS of abcd and bdca is bd or bc, of length 2
+		{"reordered", "a\nb\nc\nd", "b\nd\nc\na", 2, 2},
+		{"lines moved", "a\nb\nc", "c\na\nb", 1, 1},
+		{"repeated lines", "a\na\nb", "a\nb\nb", 1, 1},
+		// A trailing newline makes an empty last line
+		{"trailing newline", "a", "a\n", 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed := lineDiff(test.before, test.after)
+			if added != test.wantAdded || removed != test.wantRemoved {
+				t.Errorf("lineDiff(%q, %q) = +%v -%v, want +%v -%v",
+					test.before, test.after, added, removed, test.wantAdded, test.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestLCSLength(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 0},
+		{"", "abc", 0},
+		{"abc", "abc", 3},
+		{"abcbdab", "bdcaba", 4},
+		{"ab", "ba", 1},
+		{"xaybzc", "abc", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+"/"+test.b, func(t *testing.T) {
+			a, b := strings.Split(test.a, ""), strings.Split(test.b, "")
+			if got := lcsLength(a, b); got != test.want {
+				t.Errorf("lcsLength(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+			}
+			if got := lcsLength(b, a); got != test.want {
+				t.Errorf("lcsLength(%q, %q) = %v, want %v", test.b, test.a, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEditorChanges(t *testing.T) {
+	sess := session{uid: "player", events: []event{
+		// The first save has nothing before it, so all its lines are added
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(define x 1)\n(fire)"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire)"}),
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 3000, Content: "(define x 2)\n(fire)\n(help)"}),
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 4000, Content: "(help)"}),
+	}}
+
+	// +2, then +2 -1, then -2
+	added, removed := sess.editorChanges()
+	if added != 4 || removed != 3 {
+		t.Errorf("editorChanges() = +%v -%v, want +4 -3", added, removed)
+	}
+
+	if added, removed := (&session{uid: "player"}).editorChanges(); added != 0 || removed != 0 {
+		t.Errorf("with no saves, editorChanges() = +%v -%v", added, removed)
+	}
+}
+
+func BenchmarkLineDiff(b *testing.B) {
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("(define x%v %v)", i, i))
+	}
+	before := strings.Join(lines, "\n")
+	lines[2500] = "(fire)"
+	after := strings.Join(lines, "\n")
+
+	// A one-line change to a large editor only diffs the changed line
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lineDiff(before, after)
+	}
+}
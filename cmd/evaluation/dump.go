@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Formats supported by the -dump-format flag.
+const (
+	// textDumpFormat is a human-readable listing of each session
+	textDumpFormat = "text"
+	// jsonlDumpFormat is JSON Lines, with one dumpEvent per line
+	jsonlDumpFormat = "jsonl"
+)
+
+// dumpExtensions are the file extensions of each dump format.
+var dumpExtensions = map[string]string{
+	textDumpFormat:  ".txt",
+	jsonlDumpFormat: ".jsonl",
+}
+
+// defaultDumpPath returns the file the session dump is written to when it
+// isn't split by UID.
+func defaultDumpPath(format string) string {
+	return "user-sessions" + dumpExtensions[format]
+}
+
+// sessionDump writes the events of each session, either to a single file or
+// to one file per UID in a directory.
+type sessionDump struct {
+	// format is the format events are written in
+	format string
+	// dir, if not empty, is the directory each UID's file is written to
+	dir string
+
+	// file and w are the single file the dump is written to, if dir is empty
+	file *os.File
+	w    *bufio.Writer
+}
+
+// newSessionDump creates a dump that writes to path in the given format,
+// creating the path's directory if it doesn't exist. If dir is not empty, the
+// dump is instead split into a file per UID in dir, which is also created if
+// it doesn't exist.
+func newSessionDump(format, path, dir string) (*sessionDump, error) {
+	if _, ok := dumpExtensions[format]; !ok {
+		return nil, fmt.Errorf("unknown dump format %q", format)
+	}
+
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err == nil && !info.IsDir() {
+			return nil, fmt.Errorf("%v exists and is not a directory", dir)
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		return &sessionDump{format: format, dir: dir}, nil
+	}
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("%v is a directory", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionDump{format: format, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// writeUID writes all the sessions of a UID to the dump. The returned error
+// names the UID that couldn't be written.
+func (d *sessionDump) writeUID(uid string, sessions []session) error {
+	if err := d.write(uid, sessions); err != nil {
+		return fmt.Errorf("writing UID %v: %v", uid, err)
+	}
+
+	return nil
+}
+
+func (d *sessionDump) write(uid string, sessions []session) error {
+	if d.dir == "" {
+		return d.writeSessions(d.w, uid, sessions)
+	}
+	if len(sessions) == 0 {
+		// Don't leave empty files for UIDs whose sessions were all dropped
+		return nil
+	}
+
+	// UIDs are chosen by clients, so they're escaped to keep them from
+	// referring to other directories
+	file, err := os.Create(filepath.Join(d.dir, url.PathEscape(uid)+dumpExtensions[d.format]))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := d.writeSessions(w, uid, sessions); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// writeSessions writes each of a UID's sessions in order, in the dump's
+// format.
+func (d *sessionDump) writeSessions(w io.Writer, uid string, sessions []session) error {
+	if d.format == jsonlDumpFormat {
+		return writeSessionsJSONL(w, uid, sessions)
+	}
+
+	for i, sess := range sessions {
+		if err := writeSession(w, sess, i, len(sessions)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dumpEvent is a single line of a JSON Lines dump.
+type dumpEvent struct {
+	Type      string `json:"type"`
+	UID       string `json:"uid"`
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// dumpEventType returns the type of the event in a JSON Lines dump.
+func dumpEventType(e event) string {
+	switch e.(type) {
+	case replEvent:
+		return "replCommand"
+	case errorEvent:
+		return "error"
+	case editorEvent:
+		return "editorContent"
+	default:
+		panic(fmt.Sprintf("unknown event type %T", e))
+	}
+}
+
+// writeSessionsJSONL writes every event of the sessions as JSON Lines, in
+// chronological order.
+func writeSessionsJSONL(w io.Writer, uid string, sessions []session) error {
+	// Encode writes a newline after each value, and escapes any newlines
+	// within one, so every line parses on its own
+	encoder := json.NewEncoder(w)
+	for _, sess := range sessions {
+		for _, e := range sess.events {
+			err := encoder.Encode(dumpEvent{
+				Type:      dumpEventType(e),
+				UID:       uid,
+				Timestamp: e.getTimestamp(),
+				Value:     e.value(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// close flushes and closes the dump.
+func (d *sessionDump) close() error {
+	if d.dir != "" {
+		return nil
+	}
+
+	if err := d.w.Flush(); err != nil {
+		d.file.Close()
+		return err
+	}
+
+	return d.file.Close()
+}
+
+// location describes where the dump was written.
+func (d *sessionDump) location() string {
+	if d.dir != "" {
+		return d.dir
+	}
+
+	return d.file.Name()
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// benchmarkSessions returns the sessions of a UID with thousands of events.
+func benchmarkSessions(uid string) []session {
+	sess := session{uid: uid}
+	for i := 0; i < 3000; i++ {
+		timestamp := int64(i * 1000)
+		switch i % 3 {
+		case 0:
+			sess.events = append(sess.events, replEvent(datatypes.REPLCommand{UID: uid, Timestamp: timestamp, Command: "(help 1 2)"}))
+		case 1:
+			sess.events = append(sess.events, errorEvent(datatypes.ErrorInstance{UID: uid, Timestamp: timestamp, Description: "Too many arguments"}))
+		case 2:
+			sess.events = append(sess.events, editorEvent(datatypes.EditorContent{UID: uid, Timestamp: timestamp, Content: "(define x 1)"}))
+		}
+	}
+
+	return []session{sess}
+}
+
+func BenchmarkSessionDump(b *testing.B) {
+	sessions := benchmarkSessions("player")
+	path := filepath.Join(b.TempDir(), "user-sessions.txt")
+
+	// Writing each piece of each event straight to the file, which costs a
+	// system call per write
+	b.Run("unbuffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			file, err := os.Create(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			dump := &sessionDump{format: textDumpFormat}
+			if err := dump.writeSessions(file, "player", sessions); err != nil {
+				b.Fatal(err)
+			}
+			file.Close()
+		}
+	})
+
+	for _, format := range []string{textDumpFormat, jsonlDumpFormat} {
+		b.Run(fmt.Sprintf("buffered %v", format), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dump, err := newSessionDump(format, path, "")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := dump.writeUID("player", sessions); err != nil {
+					b.Fatal(err)
+				}
+				if err := dump.close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSession(t *testing.T) {
+	sess := session{
+		uid:       "player",
+		sessionID: "s1",
+		events: []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(help 1 2)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Too many arguments"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments"}),
+		},
+	}
+
+	var out strings.Builder
+	if err := writeSession(&out, sess, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "=== player (session 1/2, ID s1) ===\n" +
+		sess.events[0].String() + "\n" +
+		sess.events[1].String() + "\n" +
+		"    after: (help 1 2)\n" +
+		sess.events[2].String() + "\n" +
+		"    after: no new command\n"
+	if out.String() != want {
+		t.Errorf("wrote:\n%v\nwant:\n%v", out.String(), want)
+	}
+}
+
+// failingWriter accepts limit bytes and then fails every write.
+type failingWriter struct {
+	limit int
+}
+
+var errWriterFull = errors.New("writer is full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errWriterFull
+	}
+
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteSessionWriteFailure(t *testing.T) {
+	sess := session{
+		uid: "player",
+		events: []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(help 1 2)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Too many arguments"}),
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 2000, Content: "(fire)"}),
+		},
+	}
+
+	var out strings.Builder
+	if err := writeSession(&out, sess, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing after any number of bytes short of the whole session
+	// surfaces the error
+	for limit := 0; limit < out.Len(); limit++ {
+		if err := writeSession(&failingWriter{limit: limit}, sess, 0, 1); err != errWriterFull {
+			t.Errorf("failing after %v of %v bytes: got error %v, want %v", limit, out.Len(), err, errWriterFull)
+		}
+	}
+	if err := writeSession(&failingWriter{limit: out.Len()}, sess, 0, 1); err != nil {
+		t.Errorf("with room for the whole session: got error %v", err)
+	}
+}
+
+func TestSessionDumpWriteFailure(t *testing.T) {
+	sessions := benchmarkSessions("player")
+
+	for _, format := range []string{textDumpFormat, jsonlDumpFormat} {
+		t.Run(format, func(t *testing.T) {
+			dump := &sessionDump{format: format, w: bufio.NewWriterSize(&failingWriter{limit: 100}, 16)}
+
+			err := dump.writeUID("player", sessions)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), "player") || !strings.Contains(err.Error(), errWriterFull.Error()) {
+				t.Errorf("error %q should name the UID and the cause", err)
+			}
+		})
+	}
+}
+
+func TestWriteSessionGolden(t *testing.T) {
+	events := []event{
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(define speed 10)\n(fire)"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(set-speed speed 2)", Result: "Too many arguments"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments", Severity: "error"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 5000, Command: "(set-speed heading)"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 5500, Description: "Variable heading has no value", Severity: "error"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 6000, Description: "Ship overheated", Severity: "warning"}),
+	}
+	sessions := []session{
+		{uid: "player", sessionID: "first", events: events[:3]},
+		{uid: "player", events: events[3:]},
+	}
+
+	var out strings.Builder
+	dump := &sessionDump{format: textDumpFormat}
+	if err := dump.writeSessions(&out, "player", sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "session.txt")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(out.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("wrote:\n%v\nwant the contents of %v:\n%v", out.String(), golden, string(want))
+	}
+}
+
+func TestSessionDumpPath(t *testing.T) {
+	sessions := []session{{uid: "player", events: []event{
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire)"}),
+	}}}
+
+	var want strings.Builder
+	if err := writeSession(&want, sessions[0], 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dump's directory is created if it doesn't exist
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scoped", "run", "player-sessions.txt")
+	dump, err := newSessionDump(textDumpFormat, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dump.writeUID("player", sessions); err != nil {
+		t.Fatal(err)
+	}
+	if err := dump.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Errorf("%v contains:\n%s\nwant:\n%v", path, got, want.String())
+	}
+	if dump.location() != path {
+		t.Errorf("location() = %v, want %v", dump.location(), path)
+	}
+
+	// A directory can't be written over
+	if _, err := newSessionDump(textDumpFormat, dir, ""); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("dumping to a directory: got error %v", err)
+	}
+}
+
+func TestWriteSessionsJSONL(t *testing.T) {
+	sessions := []session{
+		{uid: "player", sessionID: "first", events: []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(define speed 10)\n(fire)"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: `(print "hi")`}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments"}),
+		}},
+		{uid: "player", events: []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 9000, Command: "(fire)\r\n\x1b[31m"}),
+		}},
+	}
+
+	var out strings.Builder
+	dump := &sessionDump{format: jsonlDumpFormat}
+	if err := dump.writeSessions(&out, "player", sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []dumpEvent{
+		{Type: "editorContent", UID: "player", Timestamp: 1000, Value: "(define speed 10)\n(fire)"},
+		{Type: "replCommand", UID: "player", Timestamp: 2000, Value: `(print "hi")`},
+		{Type: "error", UID: "player", Timestamp: 2000, Value: "Too many arguments"},
+		{Type: "replCommand", UID: "player", Timestamp: 9000, Value: "(fire)\r\n\x1b[31m"},
+	}
+
+	// Each line parses on its own, even when the values have newlines
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %v lines, want %v:\n%v", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		var got dumpEvent
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %v: %v", i, err)
+		}
+		if got != want[i] {
+			t.Errorf("line %v = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestSessionDumpDir(t *testing.T) {
+	uids := []string{"player", "other/../../escape", "empty"}
+	sessions := map[string][]session{
+		"player": {{uid: "player", events: []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire)"}),
+		}}},
+		"other/../../escape": {
+			{uid: "other/../../escape", events: []event{
+				errorEvent(datatypes.ErrorInstance{UID: "other/../../escape", Timestamp: 1000, Description: "Too many arguments"}),
+			}},
+			{uid: "other/../../escape", events: []event{
+				editorEvent(datatypes.EditorContent{UID: "other/../../escape", Timestamp: 9000000, Content: "(fire)"}),
+			}},
+		},
+		// Every session of this UID was dropped
+		"empty": nil,
+	}
+
+	for _, format := range []string{textDumpFormat, jsonlDumpFormat} {
+		t.Run(format, func(t *testing.T) {
+			// The directory is created, along with its parents
+			dir := filepath.Join(t.TempDir(), "dumps", "run")
+			dump, err := newSessionDump(format, "", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, uid := range uids {
+				if err := dump.writeUID(uid, sessions[uid]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := dump.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The UID with a slash in it is escaped, so its file is in the
+			// directory too
+			wantFiles := []string{
+				url.PathEscape("other/../../escape") + dumpExtensions[format],
+				"player" + dumpExtensions[format],
+			}
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, info := range infos {
+				files = append(files, info.Name())
+			}
+			if !reflect.DeepEqual(files, wantFiles) {
+				t.Fatalf("wrote %v, want %v", files, wantFiles)
+			}
+
+			for _, uid := range uids[:2] {
+				var want strings.Builder
+				if err := (&sessionDump{format: format}).writeSessions(&want, uid, sessions[uid]); err != nil {
+					t.Fatal(err)
+				}
+
+				path := filepath.Join(dir, url.PathEscape(uid)+dumpExtensions[format])
+				got, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want.String() {
+					t.Errorf("%v contains:\n%s\nwant:\n%v", path, got, want.String())
+				}
+			}
+		})
+	}
+}
+
+func TestSessionDumpDirIsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newSessionDump(textDumpFormat, "", path); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("got error %v, want one saying %v is not a directory", err, path)
+	}
+}
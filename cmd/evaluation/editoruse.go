@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultEditorRunPattern is the default for the -editor-run-pattern flag.
+const defaultEditorRunPattern = `^\s*\((load|run|eval)\b`
+
+// definingForms are the callables whose first argument, or the head of their
+// first argument if it's a form, is the name being defined.
+var definingForms = map[string]bool{
+	"define": true,
+	"defun":  true,
+	"defn":   true,
+}
+
+// maxUnusedEditorExamples is the most sessions listed in the report as having
+// unused editor contents.
+const maxUnusedEditorExamples = 10
+
+// definedNames returns the names the editor contents define with
+// definingForms, lowercased.
+func definedNames(content string) map[string]bool {
+	tokens, _ := tokenizeCommand(content)
+
+	names := make(map[string]bool)
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].kind != openToken || tokens[i+1].kind != atomToken ||
+			!definingForms[strings.ToLower(tokens[i+1].text)] {
+			continue
+		}
+
+		name := tokens[i+2]
+		if name.kind == openToken && i+3 < len(tokens) {
+			// A function definition like (define (name args) ...)
+			name = tokens[i+3]
+		}
+		if name.kind == atomToken {
+			names[strings.ToLower(name.text)] = true
+		}
+	}
+
+	return names
+}
+
+// ranEditorCode guesses whether the session ever ran the code it saved in the
+// editor. A REPL command counts as running the editor's contents if it comes
+// after a save and either matches runPattern, which catches commands that
+// load the editor wholesale, or calls or refers to a name defined in the
+// most recent save before it. Sessions that never saved the editor return
+// true, since there's nothing to run.
+func (u *session) ranEditorCode(runPattern *regexp.Regexp) bool {
+	saved := false
+	var defined map[string]bool
+	for _, e := range u.events {
+		switch e := e.(type) {
+		case editorEvent:
+			saved = true
+			defined = definedNames(e.Content)
+		case replEvent:
+			if !saved {
+				continue
+			}
+			if runPattern.MatchString(e.Command) {
+				return true
+			}
+
+			callables, _ := commandCallables(e.Command)
+			for _, name := range append(callables, commandVariables(e.Command)...) {
+				if defined[strings.ToLower(name)] {
+					return true
+				}
+			}
+		}
+	}
+
+	return !saved
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestEditorUse(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.EditorContentKind,
+		datatypes.EditorContent{UID: "saved", Timestamp: 10},
+		datatypes.EditorContent{UID: "saved", Timestamp: 20},
+		datatypes.EditorContent{UID: "saved-early", Timestamp: 1},
+		datatypes.EditorContent{UID: "saved-late", Timestamp: 15},
+		datatypes.EditorContent{UID: "not-asked-about", Timestamp: 15},
+	)
+	client.add(datatypes.REPLCommandKind,
+		datatypes.REPLCommand{UID: "repl-only", Timestamp: 10},
+	)
+	uids := []string{"repl-only", "saved", "saved-early", "saved-late", "unknown"}
+
+	tests := []struct {
+		name   string
+		filter queryFilter
+		uids   []string
+		want   int
+	}{
+		{"no filter", queryFilter{}, uids, 3},
+		{"time range", queryFilter{from: 5, to: 30}, uids, 2},
+		{"after every save", queryFilter{from: 25}, uids, 0},
+		{"UID given twice", queryFilter{}, []string{"saved", "saved"}, 1},
+		{"single UID", queryFilter{uid: "saved"}, []string{"saved"}, 1},
+		{"single UID without saves", queryFilter{uid: "repl-only"}, []string{"repl-only"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := editorUse(context.Background(), client, test.filter, test.uids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("editorUse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEditorUseQueries(t *testing.T) {
+	client := newFakeStore()
+	var uids []string
+	for i := 0; i < 200; i++ {
+		uid := fmt.Sprintf("uid-%03d", i)
+		uids = append(uids, uid)
+		client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: uid})
+	}
+
+	if _, err := editorUse(context.Background(), client, queryFilter{}, uids); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every UID fits in a single page of the projection
+	if client.runs != 1 {
+		t.Errorf("ran %v queries, want 1 rather than one per UID", client.runs)
+	}
+}
+
+func BenchmarkEditorUse(b *testing.B) {
+	client := newFakeStore()
+	var uids []string
+	for i := 0; i < 200; i++ {
+		uid := fmt.Sprintf("uid-%03d", i)
+		uids = append(uids, uid)
+		if i%2 == 0 {
+			client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: uid})
+		}
+	}
+	client.latency = time.Millisecond
+
+	// Checking each UID with its own query, which is what editorUse did
+	// before
+	b.Run("query per UID", func(b *testing.B) {
+		client.runs = 0
+		for i := 0; i < b.N; i++ {
+			for _, uid := range uids {
+				query := queryFilter{}.forUID(uid).query(datatypes.EditorContentKind)
+				if _, err := anyMatch(context.Background(), client, query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(client.runs)/float64(b.N), "queries/op")
+	})
+
+	b.Run("distinct projection", func(b *testing.B) {
+		client.runs = 0
+		for i := 0; i < b.N; i++ {
+			if _, err := editorUse(context.Background(), client, queryFilter{}, uids); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(client.runs)/float64(b.N), "queries/op")
+	})
+}
+
+func TestDefinedNames(t *testing.T) {
+	tests := []struct {
+		content string
+		want    map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{"(fire)", map[string]bool{}},
+		{"(define speed 10)", map[string]bool{"speed": true}},
+		{"(DEFINE Speed 10)", map[string]bool{"speed": true}},
+		{"(define (launch n) (fire n))\n(defun land () (stop))", map[string]bool{"launch": true, "land": true}},
+		{"(defn boost [x] x)", map[string]bool{"boost": true}},
+		// A definition that's cut off still defines its name
+		{"(define (launch", map[string]bool{"launch": true}},
+		{"(define", map[string]bool{}},
+		{`(print "(define speed 10)")`, map[string]bool{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.content, func(t *testing.T) {
+			if got := definedNames(test.content); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("definedNames(%q) = %v, want %v", test.content, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRanEditorCode(t *testing.T) {
+	save := func(timestamp int64, content string) event {
+		return editorEvent(datatypes.EditorContent{UID: "player", Timestamp: timestamp, Content: content})
+	}
+	command := func(timestamp int64, command string) event {
+		return replEvent(datatypes.REPLCommand{UID: "player", Timestamp: timestamp, Command: command})
+	}
+
+	tests := []struct {
+		name   string
+		events []event
+		want   bool
+	}{
+		{"no saves", []event{command(1000, "(fire)")}, true},
+		{"no events", nil, true},
+		{"loaded", []event{save(1000, "(fire)"), command(2000, "(load)")}, true},
+		{"run", []event{save(1000, "(fire)"), command(2000, "  (run editor)")}, true},
+		{"called a definition", []event{save(1000, "(define (launch n) (fire n))"), command(2000, "(launch 3)")}, true},
+		{"referred to a definition", []event{save(1000, "(define speed 10)"), command(2000, "(set-speed SPEED)")}, true},
+		{"never ran anything", []event{save(1000, "(define (launch n) (fire n))")}, false},
+		{"unrelated commands", []event{save(1000, "(define (launch n) (fire n))"), command(2000, "(fire 3)"), command(3000, "(help)")}, false},
+		// The commands have to come after the save they use
+		{"ran before saving", []event{command(1000, "(load)"), save(2000, "(fire)")}, false},
+		{"called before defining", []event{command(1000, "(launch 3)"), save(2000, "(define (launch n) (fire n))")}, false},
+		// Only the latest save's definitions count
+		{"called a replaced definition", []event{
+			save(1000, "(define (launch n) (fire n))"),
+			save(2000, "(define (land) (stop))"),
+			command(3000, "(launch 3)"),
+		}, false},
+		{"load is only a prefix", []event{save(1000, "(fire)"), command(2000, "(loaded)")}, false},
+	}
+
+	runPattern := regexp.MustCompile(defaultEditorRunPattern)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			if got := sess.ranEditorCode(runPattern); got != test.want {
+				t.Errorf("ranEditorCode() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRanEditorCodeRunPattern(t *testing.T) {
+	sess := session{uid: "player", events: []event{
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(fire)"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(exec-editor)"}),
+	}}
+
+	if sess.ranEditorCode(regexp.MustCompile(defaultEditorRunPattern)) {
+		t.Error("ranEditorCode() with the default pattern = true, want false")
+	}
+	if !sess.ranEditorCode(regexp.MustCompile(`^\(exec-editor\)`)) {
+		t.Error("ranEditorCode() with a pattern matching the command = false, want true")
+	}
+}
+
+func TestUnusedEditorSessions(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	unused := session{uid: "unused", events: []event{
+		editorEvent(datatypes.EditorContent{UID: "unused", Timestamp: 1000, Content: "(define speed 10)"}),
+		replEvent(datatypes.REPLCommand{UID: "unused", Timestamp: 2000, Command: "(fire)"}),
+	}}
+	used := session{uid: "used", events: []event{
+		editorEvent(datatypes.EditorContent{UID: "used", Timestamp: 1000, Content: "(define speed 10)"}),
+		replEvent(datatypes.REPLCommand{UID: "used", Timestamp: 2000, Command: "(load)"}),
+	}}
+
+	aggregator.add(used, 0)
+	for i := 0; i < maxUnusedEditorExamples+2; i++ {
+		aggregator.add(unused, i+1)
+	}
+	aggregator.add(session{uid: "no-editor", events: commandEvents("no-editor", "(fire)")}, 0)
+	aggregator.finish()
+
+	if report.UnusedEditorSessions != maxUnusedEditorExamples+2 {
+		t.Errorf("UnusedEditorSessions = %v, want %v", report.UnusedEditorSessions, maxUnusedEditorExamples+2)
+	}
+	// Only the first few are sampled
+	if len(report.UnusedEditorExamples) != maxUnusedEditorExamples {
+		t.Fatalf("got %v examples, want %v", len(report.UnusedEditorExamples), maxUnusedEditorExamples)
+	}
+	for i, ref := range report.UnusedEditorExamples {
+		if want := (SessionRef{UID: "unused", Index: i + 1}); ref != want {
+			t.Errorf("example %v = %+v, want %+v", i, ref, want)
+		}
+	}
+}
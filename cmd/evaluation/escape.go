@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// escapeDump is true if text in the session dump has its non-printable
+// characters escaped. It's set by the -escape flag.
+var escapeDump bool
+
+// dumpText returns the text as it should appear in the session dump.
+func dumpText(text string) string {
+	if !escapeDump {
+		return text
+	}
+
+	return escapeNonPrintable(text)
+}
+
+// escapeNonPrintable replaces the characters in the text that could corrupt a
+// terminal with escape sequences. Control characters and invalid UTF-8 bytes
+// become \xNN, and other non-printable runes become \uNNNN. Tabs and
+// printable characters, including non-ASCII ones, are left alone.
+func escapeNonPrintable(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, text[i])
+		case r == '\t' || unicode.IsPrint(r):
+			b.WriteRune(r)
+		case r < 0x100:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+		i += size
+	}
+
+	return b.String()
+}
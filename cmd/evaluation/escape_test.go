@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestEscapeNonPrintable(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "(define speed 10)", "(define speed 10)"},
+		{"tab", "(fire)\t; go", "(fire)\t; go"},
+		{"non-ASCII", "(say \"héllo wörld\") 🚀", "(say \"héllo wörld\") 🚀"},
+		{"backslash", `(print "\n")`, `(print "\n")`},
+		{"escape sequence", "\x1b[31mred\x1b[0m", `\x1b[31mred\x1b[0m`},
+		{"newline and carriage return", "a\r\nb", `a\x0d\x0ab`},
+		{"bell and null", "\a\x00", `\x07\x00`},
+		{"delete", "\x7f", `\x7f`},
+		{"C1 control", "\u0085", `\x85`},
+		{"invalid UTF-8", "ok\xffok", `ok\xffok`},
+		{"non-printable runes", "a\u200bb\u2028", `a\u200bb\u2028`},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := escapeNonPrintable(test.text); got != test.want {
+				t.Errorf("escapeNonPrintable(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventStringEscaping(t *testing.T) {
+	events := []event{
+		replEvent(datatypes.REPLCommand{Command: "(fire)\x1b[2J"}),
+		errorEvent(datatypes.ErrorInstance{Description: "Bad\x07 thing"}),
+		editorEvent(datatypes.EditorContent{Content: "(define x 1)\x1b[2J\n(fire)"}),
+	}
+
+	tests := []struct {
+		escape bool
+		want   []string
+	}{
+		// Text is dumped raw unless -escape is set
+		{false, []string{
+			"REPL : (fire)\x1b[2J",
+			"Error: Bad\x07 thing",
+			"Editor:\n    (define x 1)\x1b[2J\n    (fire)\n",
+		}},
+		// Lines of the editor are still split on their newlines
+		{true, []string{
+			`REPL : (fire)\x1b[2J`,
+			`Error: Bad\x07 thing`,
+			"Editor:\n    (define x 1)\\x1b[2J\n    (fire)\n",
+		}},
+	}
+
+	realEscape := escapeDump
+	defer func() { escapeDump = realEscape }()
+
+	for _, test := range tests {
+		escapeDump = test.escape
+		for i, e := range events {
+			if got := e.String(); got != test.want[i] {
+				t.Errorf("with escaping %v, String() = %q, want %q", test.escape, got, test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats supported by the -format flag.
+const (
+	textFormat     = "text"
+	csvFormat      = "csv"
+	jsonFormat     = "json"
+	markdownFormat = "markdown"
+)
+
+type errorTypeCountInfo struct {
+	errorType string
+	count     int
+}
+
+// sortedErrorTypeCounts returns the error type counts from analyzeErrors in
+// descending order of count. Types with the same count are sorted by name so
+// that the order is stable.
+func sortedErrorTypeCounts(matchCnt map[string]int) []errorTypeCountInfo {
+	var sorted []errorTypeCountInfo
+	for errorType, cnt := range matchCnt {
+		sorted = append(sorted, errorTypeCountInfo{
+			errorType: errorType,
+			count:     cnt})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].errorType < sorted[j].errorType
+	})
+
+	return sorted
+}
+
+// writeErrorTypeCountsCSV writes the error type counts as "type,count" CSV
+// rows, preceded by a header row.
+func writeErrorTypeCountsCSV(w io.Writer, matchCnt map[string]int) error {
+	csvWriter := csv.NewWriter(w)
+
+	if err := csvWriter.Write([]string{"type", "count"}); err != nil {
+		return fmt.Errorf("writing header: %v", err)
+	}
+
+	for _, info := range sortedErrorTypeCounts(matchCnt) {
+		row := []string{info.errorType, strconv.Itoa(info.count)}
+		if err := csvWriter.Write(row); err != nil {
+			return fmt.Errorf("writing row: %v", err)
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// logReport logs the report in a human-readable form.
+func logReport(report Report) {
+	log.Println("--- Error Frequency ---")
+	for name, cnt := range report.ErrorTypeCounts {
+		log.Printf("%v: %v", name, cnt)
+	}
+
+	log.Printf("Distinct error types per UID: %v", formatDistribution(report.ErrorTypesPerUID))
+
+	log.Println("--- Error Frequency by game version ---")
+	logGroupedCounts(report.ErrorTypeCountsByVersion)
+
+	log.Println("--- Error Frequency by platform ---")
+	logGroupedCounts(report.ErrorTypeCountsByPlatform)
+
+	log.Println("--- Top unmatched errors ---")
+	for _, unmatched := range report.UnmatchedErrors {
+		log.Printf("%v: %v", unmatched.Value, unmatched.Count)
+	}
+
+	log.Println("--- Example unclassified errors ---")
+	for _, example := range report.UnclassifiedExamples {
+		log.Printf("%q", example)
+	}
+
+	log.Println("--- VariableHasNoValue top variables ---")
+	for _, varWithNoValue := range report.VariablesWithNoValue {
+		log.Printf("%v: %v", varWithNoValue.Value, varWithNoValue.Count)
+	}
+
+	log.Println("--- UnknownCallable top callables ---")
+	for _, callable := range report.UnknownCallables {
+		log.Printf("%v: %v", callable.Value, callable.Count)
+	}
+
+	if report.Captures != nil {
+		log.Printf("--- %v top captured values ---", report.Captures.Pattern)
+		for _, value := range report.Captures.Values {
+			log.Printf("%v: %v", value.Value, value.Count)
+		}
+	}
+
+	log.Println("--- Points of friction ---")
+	for _, point := range report.Friction {
+		log.Printf("%v (%v): %v", point.Label, point.Category, point.Count)
+	}
+
+	log.Printf("--- Silent failures (%v total) ---", report.SilentFailureCount)
+	for _, cmd := range report.SilentFailures {
+		log.Printf("%v: %v", cmd.Value, cmd.Count)
+	}
+
+	log.Println("--- Safe commands ---")
+	for _, cmd := range report.SafeCommands {
+		log.Printf("%v: %v", cmd.Value, cmd.Count)
+	}
+
+	log.Println("--- Top REPL commands ---")
+	for _, cmd := range report.TopCommands {
+		log.Printf("%v: %v", cmd.Value, cmd.Count)
+	}
+
+	log.Printf("--- Top called functions (%v unparsable commands) ---", report.UnparsableCommands)
+	for _, callable := range report.CalledFunctions {
+		log.Printf("%v: %v", callable.Value, callable.Count)
+	}
+
+	log.Println("--- REPL commands by subsystem ---")
+	for name, cnt := range report.CommandCategories {
+		log.Printf("%v: %v", name, cnt)
+	}
+
+	log.Println("REPL command length:", formatDistribution(report.CommandLengths))
+	logHistogram(report.CommandLengthHistogram)
+
+	log.Printf("%v sessions used the editor out of %v", report.EditorUseCount, report.UIDCount)
+
+	rates := report.EditorErrorRates
+	log.Println("Errors per session:")
+	logHistogram(report.ErrorsPerSessionHistogram)
+	log.Printf("Errors per session with the editor: %v (%v sessions), without: %v (%v sessions), delta: %v",
+		formatFloat(rates.EditorErrorsPerSession), rates.EditorSessions,
+		formatFloat(rates.NonEditorErrorsPerSession), rates.NonEditorSessions,
+		formatFloat(rates.Delta))
+
+	if d := report.SessionDuration; d != nil {
+		log.Printf("%v sessions, mean duration %v, median duration %v", d.Count,
+			time.Duration(d.Mean)*time.Millisecond, time.Duration(d.Median)*time.Millisecond)
+	}
+	log.Printf("Commands per session: %v", formatDistribution(report.CommandsPerSession))
+	log.Printf("%v sessions never ran a command", len(report.NoCommandSessions))
+	for _, ref := range report.NoCommandSessions {
+		log.Printf("    %v", ref)
+	}
+	log.Println("--- Top first commands of a session ---")
+	for _, cmd := range report.FirstCommands {
+		log.Printf("%v: %v", cmd.Value, cmd.Count)
+	}
+	log.Println("--- Top last commands of a session that ended in an error ---")
+	for _, cmd := range report.DeadEndCommands {
+		log.Printf("%v: %v", cmd.Value, cmd.Count)
+	}
+	log.Printf("%v sessions ended in an error without running a command", report.ErrorEndingsWithoutCommand)
+	log.Printf("Average command success rate: %v", formatRate(report.AverageSuccessRate))
+	recovery := report.ErrorRecovery
+	log.Printf("Recovery from errors: %v (recovered: %v, kept erroring: %v, session ended: %v)",
+		formatRate(recovery.RecoveryRate), recovery.Recovered, recovery.KeptErroring, recovery.SessionEnded)
+	log.Printf("Time from command to error (ms): %v", formatDistribution(report.TimeToError))
+
+	log.Printf("Editor lines added: %v, removed: %v", report.LinesAdded, report.LinesRemoved)
+	log.Printf("%v sessions saved the editor but never ran its code", report.UnusedEditorSessions)
+	for _, ref := range report.UnusedEditorExamples {
+		log.Printf("    %v", ref)
+	}
+	log.Printf("%v editor saves look like pastes", report.PasteCount)
+	log.Printf("Time between editor saves (ms): %v", formatDistribution(report.EditorCadence))
+	log.Printf("%v idle gaps between editor saves", report.EditorIdleGaps)
+	log.Printf("Distinct variables per session: %v", formatDistribution(report.VariablesPerSession))
+	log.Printf("Editor saves per command: %v", formatDistribution(report.EditorRatio))
+	log.Printf("%v sessions saved the editor without running a command (ratio N/A)", report.EditorOnlySessions)
+
+	log.Printf("%v skew incidents", report.SkewIncidents)
+	if worst := report.WorstSkewUID; worst != nil {
+		log.Printf("    worst UID: %v (%v)", worst.Value, worst.Count)
+	}
+
+	log.Println("--- Sessions reaching each stage ---")
+	for _, stage := range report.Funnel {
+		log.Printf("%v: %v", stage.Stage, stage.Sessions)
+	}
+
+	log.Printf("Events by hour of day (%v):", report.ActivityTimeZone)
+	for hour, cnt := range report.ActivityByHour {
+		log.Printf("    %02d:00: %v", hour, cnt)
+	}
+
+	log.Printf("%v retry streaks", report.RetryStreakCount)
+	if streak := report.LongestRetryStreak; streak != nil {
+		log.Printf("Longest retry streak: %q run %v times by %v",
+			streak.Command, streak.Length, streak.UID)
+	}
+}
+
+// formatDistribution formats a distribution on a single line, or "N/A" if it
+// is nil.
+func formatDistribution(d *Distribution) string {
+	if d == nil {
+		return "N/A"
+	}
+
+	return fmt.Sprintf("min %g, mean %.4g, median %g, p90 %g, max %g (n=%v)",
+		d.Min, d.Mean, d.Median, d.P90, d.Max, d.Count)
+}
+
+// formatRate formats a rate between 0 and 1 as a percentage, or "N/A" if the
+// rate is nil.
+func formatRate(rate *float64) string {
+	if rate == nil {
+		return "N/A"
+	}
+
+	return fmt.Sprintf("%.1f%%", *rate*100)
+}
+
+// formatFloat formats a value, or "N/A" if it is nil.
+func formatFloat(value *float64) string {
+	if value == nil {
+		return "N/A"
+	}
+
+	return fmt.Sprintf("%.4g", *value)
+}
+
+// writeReportJSON writes the report as a single JSON document.
+func writeReportJSON(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(report)
+}
+
+// logGroupedCounts logs counts that are broken down by group, with groups in
+// alphabetical order.
+func logGroupedCounts(groupCnts map[string]map[string]int) {
+	var groups []string
+	for group := range groupCnts {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		log.Printf("%v:", group)
+		for name, cnt := range groupCnts[group] {
+			log.Printf("    %v: %v", name, cnt)
+		}
+	}
+}
+
+// writeReportMarkdown writes the report as a Markdown document with a table
+// for each section.
+func writeReportMarkdown(w io.Writer, report Report) error {
+	var b strings.Builder
+
+	b.WriteString("# Evaluation Report\n")
+
+	b.WriteString("\n## Error Frequency\n\n")
+	errorRows := [][2]string{}
+	errorTotal := 0
+	for _, info := range sortedErrorTypeCounts(report.ErrorTypeCounts) {
+		errorRows = append(errorRows, [2]string{info.errorType, strconv.Itoa(info.count)})
+		errorTotal += info.count
+	}
+	errorRows = append(errorRows, [2]string{"**Total**", "**" + strconv.Itoa(errorTotal) + "**"})
+	writeMarkdownTable(&b, "Error type", "Count", errorRows)
+
+	b.WriteString("\n## Top Unmatched Errors\n\n")
+	unmatchedRows := [][2]string{}
+	for _, unmatched := range report.UnmatchedErrors {
+		unmatchedRows = append(unmatchedRows, [2]string{unmatched.Value, strconv.Itoa(unmatched.Count)})
+	}
+	writeMarkdownTable(&b, "Normalized description", "Count", unmatchedRows)
+
+	if len(report.UnclassifiedExamples) > 0 {
+		b.WriteString("\n### Example Unclassified Errors\n\n")
+		for _, example := range report.UnclassifiedExamples {
+			fmt.Fprintf(&b, "- `%v`\n", strings.ReplaceAll(example, "`", "'"))
+		}
+	}
+
+	b.WriteString("\n## Top VariableHasNoValue Variables\n\n")
+	// The totals count every error, not just those of the ranked entries
+	variableRows := [][2]string{}
+	for _, variable := range report.VariablesWithNoValue {
+		variableRows = append(variableRows, [2]string{variable.Value, strconv.Itoa(variable.Count)})
+	}
+	variableRows = append(variableRows, [2]string{"**Total**", "**" + strconv.Itoa(report.VariablesWithNoValueTotal) + "**"})
+	writeMarkdownTable(&b, "Variable", "Count", variableRows)
+
+	b.WriteString("\n## Top UnknownCallable Callables\n\n")
+	callableRows := [][2]string{}
+	for _, callable := range report.UnknownCallables {
+		callableRows = append(callableRows, [2]string{callable.Value, strconv.Itoa(callable.Count)})
+	}
+	callableRows = append(callableRows, [2]string{"**Total**", "**" + strconv.Itoa(report.UnknownCallablesTotal) + "**"})
+	writeMarkdownTable(&b, "Callable", "Count", callableRows)
+
+	if report.Captures != nil {
+		fmt.Fprintf(&b, "\n## Top %v Captured Values\n\n", report.Captures.Pattern)
+		captureRows := [][2]string{}
+		for _, value := range report.Captures.Values {
+			captureRows = append(captureRows, [2]string{value.Value, strconv.Itoa(value.Count)})
+		}
+		writeMarkdownTable(&b, "Value", "Count", captureRows)
+	}
+
+	b.WriteString("\n## Points of Friction\n\n")
+	frictionRows := [][2]string{}
+	for _, point := range report.Friction {
+		frictionRows = append(frictionRows, [2]string{point.Label + " (" + point.Category + ")", strconv.Itoa(point.Count)})
+	}
+	writeMarkdownTable(&b, "Friction", "Count", frictionRows)
+
+	b.WriteString("\n## Editor Use\n\n")
+	var editorRatio *float64
+	if report.UIDCount > 0 {
+		ratio := float64(report.EditorUseCount) / float64(report.UIDCount)
+		editorRatio = &ratio
+	}
+	writeMarkdownTable(&b, "", "Value", [][2]string{
+		{"UIDs that used the editor", strconv.Itoa(report.EditorUseCount)},
+		{"Total UIDs", strconv.Itoa(report.UIDCount)},
+		{"Ratio", formatRate(editorRatio)},
+	})
+
+	b.WriteString("\n## Errors per Session by Editor Use\n\n")
+	rates := report.EditorErrorRates
+	writeMarkdownTable(&b, "", "Value", [][2]string{
+		{"Sessions that used the editor", strconv.Itoa(rates.EditorSessions)},
+		{"Errors per session with the editor", formatFloat(rates.EditorErrorsPerSession)},
+		{"Sessions that didn't use the editor", strconv.Itoa(rates.NonEditorSessions)},
+		{"Errors per session without the editor", formatFloat(rates.NonEditorErrorsPerSession)},
+		{"Delta", formatFloat(rates.Delta)},
+	})
+
+	b.WriteString("\n## Sessions\n\n")
+	writeMarkdownTable(&b, "", "Value", [][2]string{
+		{"Sessions", strconv.Itoa(len(report.Sessions))},
+		{"Sessions without commands", strconv.Itoa(len(report.NoCommandSessions))},
+		{"Average command success rate", formatRate(report.AverageSuccessRate)},
+	})
+
+	b.WriteString("\n## Funnel\n\n")
+	funnelRows := [][2]string{}
+	for _, stage := range report.Funnel {
+		funnelRows = append(funnelRows, [2]string{stage.Stage, strconv.Itoa(stage.Sessions)})
+	}
+	writeMarkdownTable(&b, "Stage", "Sessions", funnelRows)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMarkdownTable writes a two-column Markdown table with the values in
+// the second column right-aligned.
+func writeMarkdownTable(b *strings.Builder, labelHeader, valueHeader string, rows [][2]string) {
+	fmt.Fprintf(b, "| %v | %v |\n", escapeMarkdownCell(labelHeader), escapeMarkdownCell(valueHeader))
+	b.WriteString("|:---|---:|\n")
+	for _, row := range rows {
+		fmt.Fprintf(b, "| %v | %v |\n", escapeMarkdownCell(row[0]), escapeMarkdownCell(row[1]))
+	}
+}
+
+// escapeMarkdownCell escapes text so that it can be put in a Markdown table
+// cell.
+func escapeMarkdownCell(text string) string {
+	text = strings.Replace(text, "|", "\\|", -1)
+	return strings.Replace(text, "\n", " ", -1)
+}
+
+// logHistogram logs the count of each bucket in the histogram.
+func logHistogram(buckets []HistogramBucket) {
+	for _, bucket := range buckets {
+		log.Printf("    %v: %v", bucket, bucket.Count)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestWriteReportMarkdown(t *testing.T) {
+	successRate := 0.75
+	withEditor, withoutEditor, delta := 0.5, 2.0, -1.5
+
+	report := Report{
+		ErrorTypeCounts: map[string]int{
+			"TooManyArguments":   12,
+			"VariableHasNoValue": 7,
+			"Unclassified":       1,
+		},
+		UnmatchedErrors:      []RankedValue{{"bad | thing", 1}},
+		UnclassifiedExamples: []string{"Bad `thing`"},
+		VariablesWithNoValue: []RankedValue{{"speed", 5}, {"heading", 2}},
+		// More than the ranked entries add up to, from variables that didn't
+		// make the ranking
+		VariablesWithNoValueTotal: 9,
+		UnknownCallables:          []RankedValue{},
+		Captures: &CaptureRanking{
+			Pattern: "VariableHasNoValue",
+			Values:  []RankedValue{{"speed", 5}, {"heading", 2}},
+		},
+		Friction: []FrictionPoint{
+			{Label: "TooManyArguments", Count: 12, Category: "error"},
+			{Label: "(fire)", Count: 3, Category: "dead end"},
+		},
+		EditorUseCount: 3,
+		UIDCount:       4,
+		EditorErrorRates: EditorErrorRates{
+			EditorSessions:            2,
+			NonEditorSessions:         3,
+			EditorErrorsPerSession:    &withEditor,
+			NonEditorErrorsPerSession: &withoutEditor,
+			Delta:                     &delta,
+		},
+		Sessions:           make([]SessionReport, 5),
+		NoCommandSessions:  []SessionRef{{UID: "idle"}},
+		AverageSuccessRate: &successRate,
+		Funnel: []FunnelStage{
+			{Stage: "ranCommand", Sessions: 4},
+			{Stage: "usedEditor", Sessions: 2},
+		},
+	}
+
+	var out strings.Builder
+	if err := writeReportMarkdown(&out, report); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "report.md")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(out.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("wrote:\n%v\nwant the contents of %v:\n%v", out.String(), golden, string(want))
+	}
+}
+
+func TestWriteErrorTypeCountsCSV(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   string
+	}{
+		{
+			"descending by count",
+			map[string]int{"TooManyArguments": 3, "VariableHasNoValue": 12, "Unclassified": 1},
+			"type,count\nVariableHasNoValue,12\nTooManyArguments,3\nUnclassified,1\n",
+		},
+		{
+			"ties by name",
+			map[string]int{"UnknownCallable": 2, "LightGenerator": 2, "ArgsMustBeNumbers": 2},
+			"type,count\nArgsMustBeNumbers,2\nLightGenerator,2\nUnknownCallable,2\n",
+		},
+		{
+			"quoted names",
+			map[string]int{"Custom, with a comma": 1, `Custom "quoted"`: 1},
+			"type,count\n\"Custom \"\"quoted\"\"\",1\n\"Custom, with a comma\",1\n",
+		},
+		{"only the header", map[string]int{}, "type,count\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := writeErrorTypeCountsCSV(&out, test.counts); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("wrote:\n%v\nwant:\n%v", out.String(), test.want)
+			}
+
+			// Every count can be read back
+			rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(test.counts)+1 {
+				t.Fatalf("read %v rows, want a header and %v counts", len(rows), len(test.counts))
+			}
+			for _, row := range rows[1:] {
+				if row[1] != strconv.Itoa(test.counts[row[0]]) {
+					t.Errorf("row %v, want a count of %v", row, test.counts[row[0]])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteReportJSON(t *testing.T) {
+	successRate, delta := 0.75, -1.5
+	max := 10
+	report := Report{
+		ErrorTypeCounts:          map[string]int{"TooManyArguments": 12, "Unclassified": 1},
+		ErrorTypesPerUID:         &Distribution{Count: 4, Min: 0, Mean: 1.5, Median: 1, P90: 3, Max: 3},
+		ErrorTypeCountsByVersion: map[string]map[string]int{"1.0": {"TooManyArguments": 12}},
+		VariablesWithNoValue:     []RankedValue{{"speed", 5}, {"heading", 2}},
+		UnknownCallables:         []RankedValue{},
+		Captures:                 &CaptureRanking{Pattern: "VariableHasNoValue", Values: []RankedValue{{"speed", 5}}},
+		CommandLengthHistogram:   []HistogramBucket{{Min: 0, Max: &max, Count: 3}, {Min: 11, Count: 1}},
+		EditorUseCount:           3,
+		UIDCount:                 4,
+		EditorErrorRates:         EditorErrorRates{EditorSessions: 2, Delta: &delta},
+		Sessions: []SessionReport{
+			{UID: "player", SessionID: "s1", Duration: 5000, CommandCount: 2, SuccessRate: &successRate, UsedEditor: true},
+		},
+		LongestRetryStreak: &RetryStreak{UID: "player", Command: "(fire)", Length: 3},
+		AverageSuccessRate: &successRate,
+		ActivityByHour:     [24]int{3: 2, 23: 1},
+		ActivityTimeZone:   "America/Los_Angeles",
+	}
+
+	var out strings.Builder
+	if err := writeReportJSON(&out, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Report
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("round trip = %+v, want %+v", got, report)
+	}
+
+	// The field names are the schema dashboards read, so a few are checked
+	// by name
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out.String()), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"uidCount":        "4",
+		"editorUseCount":  "3",
+		"errorTypeCounts": `{"TooManyArguments":12,"Unclassified":1}`,
+		"sessionDuration": "null",
+	} {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, fields[name]); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if compacted.String() != want {
+			t.Errorf("%v = %s, want %s", name, compacted.String(), want)
+		}
+	}
+	if _, ok := fields["captures"]; !ok {
+		t.Error("captures is missing")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+// funnelStages are the command categories that make up the game's rough
+// progression, in the order players are expected to reach them. Editing this
+// list changes the stages of the funnel in the report.
+var funnelStages = []string{
+	"switches",
+	"lights",
+	"propellant",
+	"thrusters",
+}
+
+// furthestStage returns the index in funnelStages of the furthest stage the
+// session reached, judged by the categories of the REPL commands it ran, or
+// -1 if it reached none. A stage counts as reached even if earlier ones were
+// skipped.
+func (u *session) furthestStage() int {
+	categories := make(map[string]bool)
+	for _, e := range u.events {
+		if cmd, ok := e.(replEvent); ok {
+			categories[categorizeCommand(normalizeCommand(cmd.Command))] = true
+		}
+	}
+
+	for i := len(funnelStages) - 1; i >= 0; i-- {
+		if categories[funnelStages[i]] {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// newFunnel returns a funnel with every stage and no sessions.
+func newFunnel() []FunnelStage {
+	funnel := make([]FunnelStage, len(funnelStages))
+	for i, stage := range funnelStages {
+		funnel[i].Stage = stage
+	}
+
+	return funnel
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFurthestStage(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     int
+	}{
+		{"no commands", nil, -1},
+		{"no stages", []string{"(help)", "(set-generator on)"}, -1},
+		{"switches", []string{"(flip-switch 1)"}, 0},
+		{"lights", []string{"(flip-switch 1)", "(set-light on)"}, 1},
+		{"propellant", []string{"(flip-switch 1)", "(set-light on)", "(pump-propellant)"}, 2},
+		{"thrusters", []string{"(flip-switch 1)", "(set-light on)", "(pump-propellant)", "(fire-thruster 2)"}, 3},
+		// The furthest stage counts, whatever order the commands were run in
+		// and even if earlier stages were skipped
+		{"out of order", []string{"(fire-thruster 2)", "(flip-switch 1)"}, 3},
+		{"skipped stages", []string{"(set-light on)"}, 1},
+		// The first matching category wins, so powering the propellant with
+		// the generator reaches the propellant stage
+		{"mentions several", []string{"(power-propellant generator)"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: commandEvents("player", test.commands...)}
+			if got := sess.furthestStage(); got != test.want {
+				t.Errorf("furthestStage() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFunnel(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, commands := range [][]string{
+		{"(help)"},
+		{"(flip-switch 1)"},
+		{"(flip-switch 1)", "(set-light on)"},
+		{"(set-light on)", "(flip-switch 1)"},
+		{"(pump-propellant)"},
+		{"(flip-switch 1)", "(set-light on)", "(pump-propellant)", "(fire-thruster 2)"},
+		nil,
+	} {
+		aggregator.add(session{uid: "player", events: commandEvents("player", commands...)}, i)
+	}
+	aggregator.finish()
+
+	// A session that reached a stage is counted as having reached the ones
+	// before it too
+	want := []FunnelStage{
+		{Stage: "switches", Sessions: 5},
+		{Stage: "lights", Sessions: 4},
+		{Stage: "propellant", Sessions: 2},
+		{Stage: "thrusters", Sessions: 1},
+	}
+	if !reflect.DeepEqual(report.Funnel, want) {
+		t.Errorf("Funnel = %+v, want %+v", report.Funnel, want)
+	}
+
+	var furthest []string
+	for _, sess := range report.Sessions {
+		furthest = append(furthest, sess.FurthestStage)
+	}
+	wantFurthest := []string{"", "switches", "lights", "lights", "propellant", "thrusters", ""}
+	if !reflect.DeepEqual(furthest, wantFurthest) {
+		t.Errorf("furthest stages = %q, want %q", furthest, wantFurthest)
+	}
+}
+
+func TestFunnelStagesEdited(t *testing.T) {
+	realStages := funnelStages
+	funnelStages = []string{"generators", "thrusters"}
+	defer func() { funnelStages = realStages }()
+
+	want := []FunnelStage{{Stage: "generators"}, {Stage: "thrusters"}}
+	if got := newFunnel(); !reflect.DeepEqual(got, want) {
+		t.Errorf("newFunnel() = %+v, want %+v", got, want)
+	}
+
+	sess := session{uid: "player", events: commandEvents("player", "(set-generator on)", "(set-light on)")}
+	if got := sess.furthestStage(); got != 0 {
+		t.Errorf("furthestStage() = %v, want 0", got)
+	}
+}
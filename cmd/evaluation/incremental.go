@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// evaluationCursorKind is the kind of the entity that remembers how far
+// incremental runs have gotten.
+const evaluationCursorKind = "EvaluationCursor"
+
+// dailySummariesCursor is the key name of the cursor for the daily summaries.
+const dailySummariesCursor = "dailySummaries"
+
+// defaultLookback is the default for the -lookback flag.
+const defaultLookback = 24 * time.Hour
+
+// evaluationCursor is stored after each incremental run.
+type evaluationCursor struct {
+	// MaxTimestamp is the timestamp of the latest event processed, in Unix
+	// milliseconds
+	MaxTimestamp int64
+	// UpdatedAt is when the cursor was stored, in Unix milliseconds
+	UpdatedAt int64
+}
+
+func cursorKey() *datastore.Key {
+	return datastore.NameKey(evaluationCursorKind, dailySummariesCursor, nil)
+}
+
+// loadCursor returns the cursor stored by the last incremental run, or false
+// if there hasn't been one.
+func loadCursor(ctx context.Context, client store) (evaluationCursor, bool, error) {
+	var cursor evaluationCursor
+	err := withRetry(ctx, func() error {
+		return client.Get(ctx, cursorKey(), &cursor)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return evaluationCursor{}, false, nil
+	} else if err != nil {
+		return evaluationCursor{}, false, err
+	}
+
+	return cursor, true, nil
+}
+
+// saveCursor stores the cursor for the next incremental run.
+func saveCursor(ctx context.Context, client store, cursor evaluationCursor) error {
+	return withRetry(ctx, func() error {
+		_, err := client.Put(ctx, cursorKey(), &cursor)
+		return err
+	})
+}
+
+// incrementalFrom returns the earliest timestamp an incremental run reads
+// events from, given the latest one processed by the last run.
+//
+// Event timestamps come from clients, so events can be stored well after the
+// time they claim to have happened. Rather than only reading events newer
+// than the cursor, which would miss those, the run goes back by lookback and
+// then to the start of that day in the given time zone. Every day from there
+// on is recomputed from all of its events, and its summary overwrites the
+// stored one, so nothing is counted twice. Days before it keep their stored
+// summaries, so events arriving more than lookback late are missed, and a
+// session that runs past midnight into the first recomputed day is counted as
+// starting on that day.
+func incrementalFrom(maxTimestamp int64, lookback time.Duration, location *time.Location) int64 {
+	t := millisToTime(maxTimestamp).Add(-lookback).In(location)
+	dayStart := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+
+	return dayStart.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// millisAt returns the Unix millisecond timestamp of an hour on a day of
+// January 2020, in UTC.
+func millisAt(day, hour int) int64 {
+	return time.Date(2020, 1, day, hour, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+}
+
+func TestIncrementalFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int64
+		lookback time.Duration
+		want     int64
+	}{
+		{"lookback within the day", millisAt(5, 10), 2 * time.Hour, millisAt(5, 0)},
+		{"lookback into the day before", millisAt(5, 1), 2 * time.Hour, millisAt(4, 0)},
+		{"default lookback", millisAt(5, 10), defaultLookback, millisAt(4, 0)},
+		{"at midnight", millisAt(5, 0), 0, millisAt(5, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := incrementalFrom(test.max, test.lookback, time.UTC); got != test.want {
+				t.Errorf("incrementalFrom() = %v, want %v", millisToTime(got), millisToTime(test.want))
+			}
+		})
+	}
+}
+
+// runSummaries does what a -write-summaries run does with the errors
+// matching the filter.
+func runSummaries(t *testing.T, client store, filter queryFilter, updatedAt int64) {
+	analysis, err := analyzeErrors(context.Background(), client, filter, errorAnalysisOptions{dayLocation: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries := newDailySummaries(analysis.byDay.counts, nil, updatedAt)
+	if err := writeDailySummaries(context.Background(), client, summaries); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalBoundaryMerge(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeStore()
+	tooMany := "Too many arguments"
+
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Timestamp: millisAt(1, 12), Description: tooMany},
+		datatypes.ErrorInstance{UID: "a", Timestamp: millisAt(2, 12), Description: tooMany},
+		datatypes.ErrorInstance{UID: "a", Timestamp: millisAt(3, 12), Description: tooMany},
+	)
+	runSummaries(t, client, queryFilter{}, 1)
+	if err := saveCursor(ctx, client, evaluationCursor{MaxTimestamp: millisAt(3, 12)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An event from before the cursor arrives late, along with a new one
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "b", Timestamp: millisAt(2, 20), Description: tooMany},
+		datatypes.ErrorInstance{UID: "a", Timestamp: millisAt(4, 1), Description: tooMany},
+	)
+
+	cursor, found, err := loadCursor(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || cursor.MaxTimestamp != millisAt(3, 12) {
+		t.Fatalf("loadCursor() = %+v, %v, want the saved cursor", cursor, found)
+	}
+	runSummaries(t, client, queryFilter{from: incrementalFrom(cursor.MaxTimestamp, defaultLookback, time.UTC)}, 2)
+
+	want := map[string]struct {
+		count     int
+		updatedAt int64
+	}{
+		// Before the lookback, so the stored summary is kept
+		"2020-01-01": {1, 1},
+		// Recomputed from all of its events, counting the late one once
+		"2020-01-02": {2, 2},
+		"2020-01-03": {1, 2},
+		"2020-01-04": {1, 2},
+	}
+	for date, want := range want {
+		var summary datatypes.DailySummary
+		if err := client.Get(ctx, datastore.NameKey(datatypes.DailySummaryKind, date, nil), &summary); err != nil {
+			t.Fatalf("getting summary of %v: %v", date, err)
+		}
+		count := 0
+		for _, errorCount := range summary.ErrorCounts {
+			count += errorCount.Count
+		}
+		if count != want.count || summary.UpdatedAt != want.updatedAt {
+			t.Errorf("summary of %v has %v errors and was updated at %v, want %v and %v",
+				date, count, summary.UpdatedAt, want.count, want.updatedAt)
+		}
+	}
+}
@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/velovix/lambda-starship-user-stats/datatypes"
+	"golang.org/x/sync/errgroup"
 )
 
 // event represents an event of some kind in the game.
 type event interface {
 	fmt.Stringer
 	getTimestamp() int64
+	getSessionID() string
+	// getReceivedAt returns when the server received the event, or zero if
+	// that wasn't recorded
+	getReceivedAt() int64
 	value() string
 }
 
@@ -27,12 +38,20 @@ func (e errorEvent) getTimestamp() int64 {
 	return e.Timestamp
 }
 
+func (e errorEvent) getSessionID() string {
+	return e.SessionID
+}
+
+func (e errorEvent) getReceivedAt() int64 {
+	return e.ReceivedAt
+}
+
 func (e errorEvent) value() string {
 	return e.Description
 }
 
 func (e errorEvent) String() string {
-	return "Error: " + e.Description
+	return "Error: " + dumpText(e.Description)
 }
 
 // replEvent is an event representing a run command in the REPL.
@@ -42,12 +61,20 @@ func (r replEvent) getTimestamp() int64 {
 	return r.Timestamp
 }
 
+func (r replEvent) getSessionID() string {
+	return r.SessionID
+}
+
+func (r replEvent) getReceivedAt() int64 {
+	return r.ReceivedAt
+}
+
 func (r replEvent) value() string {
 	return r.Command
 }
 
 func (r replEvent) String() string {
-	return "REPL : " + r.Command
+	return "REPL : " + dumpText(r.Command)
 }
 
 type editorEvent datatypes.EditorContent
@@ -56,6 +83,14 @@ func (e editorEvent) getTimestamp() int64 {
 	return e.Timestamp
 }
 
+func (e editorEvent) getSessionID() string {
+	return e.SessionID
+}
+
+func (e editorEvent) getReceivedAt() int64 {
+	return e.ReceivedAt
+}
+
 func (e editorEvent) value() string {
 	return e.Content
 }
@@ -64,70 +99,219 @@ func (e editorEvent) String() string {
 	out := "Editor:\n"
 
 	for _, line := range strings.Split(e.Content, "\n") {
-		out += "    " + line + "\n"
+		out += "    " + dumpText(line) + "\n"
 	}
 
 	return out
 }
 
 type session struct {
-	uid    string
-	events []event
+	uid string
+	// sessionID is the ID the client gave the session, or empty if the
+	// session's boundaries were inferred
+	sessionID string
+	events    []event
 }
 
 // newSession creates a new session from the given UID containing all its
-// events.
-func newSession(ctx context.Context, client *datastore.Client, uid string) (session, error) {
+// events. The events of each kind are queried concurrently.
+func newSession(ctx context.Context, client store, filter queryFilter, uid string) (session, error) {
 	sess := session{uid: uid}
+	filter = filter.forUID(uid)
 
-	// Get all errors
-	query := datastore.NewQuery(datatypes.ErrorInstanceKind).
-		Filter("UID =", uid)
-	var errorInstances []datatypes.ErrorInstance
-	if _, err := client.GetAll(ctx, query, &errorInstances); err != nil {
-		return session{}, err
+	var mutex sync.Mutex
+	addEvents := func(events ...event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sess.events = append(sess.events, events...)
 	}
 
-	for _, instance := range errorInstances {
-		sess.events = append(sess.events, errorEvent(instance))
-	}
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	// Get all errors
+	group.Go(func() error {
+		query := filter.query(datatypes.ErrorInstanceKind)
+		var errorInstances []datatypes.ErrorInstance
+		err := withRetry(groupCtx, func() error {
+			errorInstances = nil
+			_, err := client.GetAll(groupCtx, query, &errorInstances)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		var events []event
+		for _, instance := range errorInstances {
+			if filter.includesError(instance) {
+				events = append(events, errorEvent(instance))
+			}
+		}
+		addEvents(events...)
+		return nil
+	})
 
 	// Get all REPL commands
-	query = datastore.NewQuery(datatypes.REPLCommandKind).
-		Filter("UID =", uid)
-	var replCommands []datatypes.REPLCommand
-	if _, err := client.GetAll(ctx, query, &replCommands); err != nil {
+	group.Go(func() error {
+		query := filter.query(datatypes.REPLCommandKind)
+		var replCommands []datatypes.REPLCommand
+		err := withRetry(groupCtx, func() error {
+			replCommands = nil
+			_, err := client.GetAll(groupCtx, query, &replCommands)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		events := make([]event, len(replCommands))
+		for i, cmd := range replCommands {
+			events[i] = replEvent(cmd)
+		}
+		addEvents(events...)
+		return nil
+	})
+
+	// Get all editor saves
+	group.Go(func() error {
+		query := filter.query(datatypes.EditorContentKind)
+		var editorContents []datatypes.EditorContent
+		err := withRetry(groupCtx, func() error {
+			editorContents = nil
+			_, err := client.GetAll(groupCtx, query, &editorContents)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		events := make([]event, len(editorContents))
+		for i, editorContent := range editorContents {
+			events[i] = editorEvent(editorContent)
+		}
+		addEvents(events...)
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
 		return session{}, err
 	}
 
-	for _, cmd := range replCommands {
-		sess.events = append(sess.events, replEvent(cmd))
+	sortEvents(sess.events)
+
+	return sess, nil
+}
+
+// sortEvents sorts events chronologically. Events with the same timestamp are
+// ordered by type, with REPL commands first, then errors, then editor saves,
+// so that a command comes before the error it caused. Events that tie on both
+// keep their order, so the result is the same from run to run as long as
+// each kind's query returns its events in the same order.
+func sortEvents(events []event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].getTimestamp() != events[j].getTimestamp() {
+			return events[i].getTimestamp() < events[j].getTimestamp()
+		}
+		return eventTypeRank(events[i]) < eventTypeRank(events[j])
+	})
+}
+
+// eventTypeRank returns where events of the given type go among events with
+// the same timestamp.
+func eventTypeRank(e event) int {
+	switch e.(type) {
+	case replEvent:
+		return 0
+	case errorEvent:
+		return 1
+	case editorEvent:
+		return 2
+	default:
+		panic(fmt.Sprintf("unknown event type %T", e))
 	}
+}
 
-	// Get all editor saves
-	query = datastore.NewQuery(datatypes.EditorContentKind).
-		Filter("UID =", uid)
-	var editorContents []datatypes.EditorContent
-	if _, err := client.GetAll(ctx, query, &editorContents); err != nil {
-		return session{}, err
+// defaultSessionGap is the default longest pause between two events that
+// still counts as the same session.
+const defaultSessionGap = 30 * time.Minute
+
+// splitSessions splits a session into sub-sessions wherever the time between
+// two consecutive events is greater than gap. The session's events must be
+// sorted by timestamp. Each sub-session keeps the UID of the original.
+func splitSessions(sess session, gap time.Duration) []session {
+	gapMillis := int64(gap / time.Millisecond)
+
+	var output []session
+	for i, e := range sess.events {
+		if i == 0 || e.getTimestamp()-sess.events[i-1].getTimestamp() > gapMillis {
+			output = append(output, session{uid: sess.uid})
+		}
+
+		current := &output[len(output)-1]
+		current.events = append(current.events, e)
 	}
 
-	for _, editorContent := range editorContents {
-		sess.events = append(sess.events, editorEvent(editorContent))
+	return output
+}
+
+// groupSessions splits a UID's events into sessions. Events are grouped by
+// the SessionID the client gave them. Events without one, which were recorded
+// before clients sent session IDs, are split by splitSessions instead. The
+// returned sessions are ordered by their first event.
+func groupSessions(sess session, gap time.Duration) []session {
+	var output []session
+	indices := make(map[string]int)
+	legacy := session{uid: sess.uid}
+
+	for _, e := range sess.events {
+		sessionID := e.getSessionID()
+		if sessionID == "" {
+			legacy.events = append(legacy.events, e)
+			continue
+		}
+
+		i, ok := indices[sessionID]
+		if !ok {
+			i = len(output)
+			indices[sessionID] = i
+			output = append(output, session{uid: sess.uid, sessionID: sessionID})
+		}
+		output[i].events = append(output[i].events, e)
 	}
 
-	sort.Slice(sess.events, func(i, j int) bool {
-		return sess.events[i].getTimestamp() < sess.events[j].getTimestamp()
+	output = append(output, splitSessions(legacy, gap)...)
+
+	sort.SliceStable(output, func(i, j int) bool {
+		return output[i].events[0].getTimestamp() < output[j].events[0].getTimestamp()
 	})
 
-	return sess, nil
+	return output
 }
 
+// dropShortSessions returns the sessions that have at least minEvents events.
+func dropShortSessions(sessions []session, minEvents int) []session {
+	var kept []session
+	for _, sess := range sessions {
+		if len(sess.events) >= minEvents {
+			kept = append(kept, sess)
+		}
+	}
+
+	return kept
+}
+
+// commandAndError pairs a REPL command with the error it caused, if any. An
+// error that wasn't preceded by a command is paired with a zero-value command
+// that has an empty Command field, and noCmd set.
 type commandAndError struct {
-	cmd datatypes.REPLCommand
-	err *datatypes.ErrorInstance
+	cmd   datatypes.REPLCommand
+	err   *datatypes.ErrorInstance
+	noCmd bool
 }
 
+// commandAndErrors pairs each REPL command in the session with the error that
+// immediately followed it. Commands that weren't followed by an error,
+// including the last command of the session, are paired with a nil error.
 func (u *session) commandAndErrors() []commandAndError {
 	var output []commandAndError
 
@@ -138,202 +322,973 @@ func (u *session) commandAndErrors() []commandAndError {
 			if lastCmd != nil {
 				output = append(output, commandAndError{
 					*lastCmd,
-					nil})
+					nil,
+					false})
 			}
 
 			replCommand := datatypes.REPLCommand(cmd)
 			lastCmd = &replCommand
 		} else if err, ok := event.(errorEvent); ok {
 			errorInstance := datatypes.ErrorInstance(err)
+
+			// The error may have come before any command was run, or after a
+			// command that was already paired with an error
+			var cmd datatypes.REPLCommand
+			if lastCmd != nil {
+				cmd = *lastCmd
+			} else {
+				cmd.UID = errorInstance.UID
+			}
+
 			output = append(output, commandAndError{
-				*lastCmd,
-				&errorInstance})
+				cmd,
+				&errorInstance,
+				lastCmd == nil})
 			lastCmd = nil
 		}
 	}
 
+	if lastCmd != nil {
+		output = append(output, commandAndError{
+			*lastCmd,
+			nil,
+			false})
+	}
+
 	return output
 }
 
-// errPatterns is a map whose keys are small descriptions of an error type and
-// values are regular expressions that match on errors of that type.
-var errPatterns = map[string]*regexp.Regexp{
-	"UnknownCallable":      regexp.MustCompile("Unknown callable '(.*)'"),
-	"VariableHasNoValue":   regexp.MustCompile("Variable ([^\\s]+) has no value"),
-	"InvalidNumberOfArgs":  regexp.MustCompile("Invalid number of args"),
-	"CallableMustBeSymbol": regexp.MustCompile("Callable name must be a symbol"),
-	"NoSwitchWithID":       regexp.MustCompile("No such switch with ID ([^\\s]+) exists"),
-	"PropellantGenerator":  regexp.MustCompile("Propellant cannot be powered with backup generator"),
-	"LightGenerator":       regexp.MustCompile("Light cannot be powered with backup generator"),
-	"NoThrusterWithID":     regexp.MustCompile("No thruster with ID ([^\\s]+) exists"),
-	"ArugmentMustBeOfType": regexp.MustCompile("Argument ([^\\s]+) must be of type ([^\\s]+), got ([^\\s]+)"),
-	"TooManyArguments":     regexp.MustCompile("Too many arguments"),
-	"ArgsMustBeNumbers":    regexp.MustCompile("All arguments to (.) must be numbers"),
+// errPattern is a small description of an error type and a regular
+// expression that matches on errors of that type.
+type errPattern struct {
+	name    string
+	pattern *regexp.Regexp
 }
 
-// errorTypeCount returns the count of all errors in the database, segregated
-// by their "type", as mandated by errPatterns.
-func errorTypeCount(ctx context.Context, client *datastore.Client) (map[string]int, error) {
-	query := datastore.NewQuery(datatypes.ErrorInstanceKind)
+// errPatterns are the known error types in priority order. An error that
+// matches more than one pattern is classified as the first one it matches.
+// These are the built-in patterns, which are replaced if a patterns file is
+// given with -patterns.
+var errPatterns = []errPattern{
+	{"UnknownCallable", regexp.MustCompile("Unknown callable '(.*)'")},
+	{"VariableHasNoValue", regexp.MustCompile("Variable ([^\\s]+) has no value")},
+	{"InvalidNumberOfArgs", regexp.MustCompile("Invalid number of args")},
+	{"CallableMustBeSymbol", regexp.MustCompile("Callable name must be a symbol")},
+	{"NoSwitchWithID", regexp.MustCompile("No such switch with ID ([^\\s]+) exists")},
+	{"PropellantGenerator", regexp.MustCompile("Propellant cannot be powered with backup generator")},
+	{"LightGenerator", regexp.MustCompile("Light cannot be powered with backup generator")},
+	{"NoThrusterWithID", regexp.MustCompile("No thruster with ID ([^\\s]+) exists")},
+	{"ArgumentMustBeOfType", regexp.MustCompile("Argument ([^\\s]+) must be of type ([^\\s]+), got ([^\\s]+)")},
+	{"TooManyArguments", regexp.MustCompile("Too many arguments")},
+	{"ArgsMustBeNumbers", regexp.MustCompile("All arguments to (.) must be numbers")},
+}
 
-	var errorInstances []datatypes.ErrorInstance
-	if _, err := client.GetAll(ctx, query, &errorInstances); err != nil {
-		return nil, fmt.Errorf("getting instances: %v", err)
+// classifyError returns the name of the first error pattern that matches the
+// description, or false if none match.
+func classifyError(description string) (string, bool) {
+	for _, p := range errPatterns {
+		if p.pattern.MatchString(description) {
+			return p.name, true
+		}
 	}
 
-	log.Println("Got", len(errorInstances), "error instances")
-
-	matchCnt := make(map[string]int)
+	return "", false
+}
 
-	for _, errorInstance := range errorInstances {
-		for name, pattern := range errPatterns {
-			if pattern.MatchString(errorInstance.Description) {
-				matchCnt[name]++
-				break
-			}
+// findErrPattern returns the regular expression of the error pattern with the
+// given name, or nil if there is no such pattern. Names that have been
+// renamed resolve to the pattern's current name.
+func findErrPattern(name string) *regexp.Regexp {
+	name = canonicalErrPatternName(name)
+	for _, p := range errPatterns {
+		if p.name == name {
+			return p.pattern
 		}
 	}
 
-	return matchCnt, nil
+	return nil
 }
 
-type variableHasNoValueInfo struct {
-	variable string
-	count    int
+// queryFilter restricts which events are considered by an evaluation run.
+type queryFilter struct {
+	// uid, if not empty, is the only UID whose events are considered
+	uid string
+	// from and to, if not zero, are the inclusive bounds in Unix milliseconds
+	// of the timestamps of events that are considered
+	from, to int64
+	// minSeverity is the rank of the least severe errors that are considered
+	minSeverity int
+	// excludedUIDs are the UIDs whose events are never considered. Datastore
+	// can't efficiently exclude values, so events are checked with
+	// includesUID after they're read
+	excludedUIDs map[string]bool
 }
 
-// variableHasNoValueCount finds how many instances of each variable name
-// resulted in a "VariableHasNoValue" error.
-func variableHasNoValueCount(ctx context.Context, client *datastore.Client) ([]variableHasNoValueInfo, error) {
-	query := datastore.NewQuery(datatypes.ErrorInstanceKind)
+// includesUID returns true if the events of the UID are considered.
+func (f queryFilter) includesUID(uid string) bool {
+	return !f.excludedUIDs[uid]
+}
 
-	var errorInstances []datatypes.ErrorInstance
-	if _, err := client.GetAll(ctx, query, &errorInstances); err != nil {
-		return nil, fmt.Errorf("getting instances: %v", err)
+// includesError returns true if the error is severe enough to be considered
+// and its UID isn't excluded. Errors stored before severities existed have
+// the default severity.
+func (f queryFilter) includesError(instance datatypes.ErrorInstance) bool {
+	if !f.includesUID(instance.UID) {
+		return false
 	}
 
-	instanceCnt := make(map[string]int)
+	rank, ok := datatypes.SeverityRank(instance.EffectiveSeverity())
+	return !ok || rank >= f.minSeverity
+}
 
-	for _, errorInstance := range errorInstances {
-		variable := errPatterns["VariableHasNoValue"].FindString(errorInstance.Description)
-		if variable != "" {
-			instanceCnt[variable]++
+// parseExcludedUIDs parses the value of the -exclude-uids flag. If the value
+// is the path of a file, the UIDs are read from it, one per line or separated
+// by commas. Otherwise, the value itself is a comma-separated list of UIDs.
+func parseExcludedUIDs(value string) (map[string]bool, error) {
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		contents, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
 		}
+		value = strings.ReplaceAll(string(contents), "\n", ",")
 	}
 
-	var sorted []variableHasNoValueInfo
-	for variable, cnt := range instanceCnt {
-		sorted = append(sorted, variableHasNoValueInfo{
-			variable: variable,
-			count:    cnt})
+	excluded := make(map[string]bool)
+	for _, uid := range strings.Split(value, ",") {
+		uid = strings.TrimSpace(uid)
+		if uid != "" {
+			excluded[uid] = true
+		}
 	}
 
-	sort.Slice(sorted, func(i, j int) bool {
-		// Reverse the sort
-		return sorted[i].count > sorted[j].count
+	return excluded, nil
+}
+
+// parseBounds parses a comma-separated list of histogram bucket bounds, which
+// must be increasing.
+func parseBounds(value string) ([]int, error) {
+	var bounds []int
+	for _, field := range strings.Split(value, ",") {
+		bound, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("bounds must be increasing, %v is not after %v", bound, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, bound)
+	}
+
+	return bounds, nil
+}
+
+// setFlag returns the name of one of the given flags that was set on the
+// command line, or an empty string if none of them were.
+func setFlag(flags *flag.FlagSet, names ...string) string {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	set := ""
+	flags.Visit(func(f *flag.Flag) {
+		if set == "" && wanted[f.Name] {
+			set = f.Name
+		}
 	})
 
-	return sorted, nil
+	return set
 }
 
-// editorUse returns the quantity of sessions that used the editor.
-func editorUse(ctx context.Context, client *datastore.Client, uids []string) (int, error) {
-	usedEditorCount := 0
+// forUID returns a copy of the filter that only matches events from the
+// given UID.
+func (f queryFilter) forUID(uid string) queryFilter {
+	f.uid = uid
+	return f
+}
 
-	for _, uid := range uids {
-		query := datastore.NewQuery(datatypes.EditorContentKind).
-			Filter("UID =", uid)
+// query returns a query for entities of the given kind that match the
+// filter.
+func (f queryFilter) query(kind string) *datastore.Query {
+	query := datastore.NewQuery(kind)
+	if f.uid != "" {
+		query = query.Filter("UID =", f.uid)
+	}
+	if f.from != 0 {
+		query = query.Filter("Timestamp >=", f.from)
+	}
+	if f.to != 0 {
+		query = query.Filter("Timestamp <=", f.to)
+	}
 
-		count, err := client.Count(ctx, query)
-		if err != nil {
+	return query
+}
+
+// defaultProjectID is the ID of the Google Cloud project the production
+// Datastore belongs to.
+const defaultProjectID = "lambda-starship-user-stats"
+
+// resolveProjectID returns the ID of the project to read from. The -project
+// flag takes precedence over the DATASTORE_PROJECT_ID environment variable,
+// which takes precedence over defaultProjectID. When the Datastore emulator
+// is used, falling back to the production project would be surprising, so one
+// of the first two must be set.
+func resolveProjectID(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if envValue := os.Getenv("DATASTORE_PROJECT_ID"); envValue != "" {
+		return envValue, nil
+	}
+	if os.Getenv("DATASTORE_EMULATOR_HOST") != "" {
+		return "", errors.New("a project ID is required with the Datastore emulator, set -project or DATASTORE_PROJECT_ID")
+	}
+
+	return defaultProjectID, nil
+}
+
+// parseTimestamp parses a time given either as an RFC3339 string or as a
+// number of milliseconds since the Unix epoch, and returns it in Unix
+// milliseconds.
+func parseTimestamp(value string) (int64, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither an RFC3339 time nor Unix milliseconds", value)
+	}
+
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// quotedIdentPattern matches an identifier quoted in an error description.
+var quotedIdentPattern = regexp.MustCompile("'[^']*'|\"[^\"]*\"|`[^`]*`")
+
+// normalizeErrorDescription reduces an error description to a canonical form
+// so that errors which only differ by the identifiers or values they mention
+// are counted together. Quoted identifiers are replaced with "<id>", then
+// numbers are replaced with "<n>".
+func normalizeErrorDescription(description string) string {
+	description = strings.TrimSpace(description)
+	description = quotedIdentPattern.ReplaceAllString(description, "<id>")
+	description = numberPattern.ReplaceAllString(description, "<n>")
+
+	return description
+}
+
+// unclassifiedErrorType is the error type of errors that match no pattern.
+const unclassifiedErrorType = "Unclassified"
+
+// maxUnclassifiedExamples is the most example descriptions of unclassified
+// errors that are kept.
+const maxUnclassifiedExamples = 5
+
+// errorTypeCounts counts errors by their "type", as mandated by errPatterns.
+// Errors that match no pattern are counted as unclassifiedErrorType, and by
+// their normalized description.
+type errorTypeCounts struct {
+	// byType is the number of errors of each type, including
+	// unclassifiedErrorType
+	byType map[string]int
+	// unmatched is the number of unclassified errors with each normalized
+	// description
+	unmatched map[string]int
+	// unclassifiedExamples are the distinct descriptions of the first few
+	// unclassified errors
+	unclassifiedExamples []string
+
+	seenExamples map[string]bool
+}
+
+func newErrorTypeCounts() errorTypeCounts {
+	return errorTypeCounts{
+		byType:       make(map[string]int),
+		unmatched:    make(map[string]int),
+		seenExamples: make(map[string]bool),
+	}
+}
+
+// add counts an error of the given type, which is empty if the error is
+// unclassified.
+func (c *errorTypeCounts) add(instance datatypes.ErrorInstance, name string) {
+	if name != "" {
+		c.byType[name]++
+		return
+	}
+
+	c.byType[unclassifiedErrorType]++
+	c.unmatched[normalizeErrorDescription(instance.Description)]++
+	if len(c.unclassifiedExamples) < maxUnclassifiedExamples && !c.seenExamples[instance.Description] {
+		c.seenExamples[instance.Description] = true
+		c.unclassifiedExamples = append(c.unclassifiedExamples, instance.Description)
+	}
+}
+
+// groupedErrorCounts counts errors by type like errorTypeCounts, but
+// separately for each group of errors. Unclassified errors are counted as
+// unclassifiedErrorType, so the counts of each group add up to the errors in
+// it.
+type groupedErrorCounts struct {
+	// groupOf returns the name of the group an error belongs to
+	groupOf func(datatypes.ErrorInstance) string
+	counts  map[string]map[string]int
+}
+
+func newGroupedErrorCounts(groupOf func(datatypes.ErrorInstance) string) groupedErrorCounts {
+	return groupedErrorCounts{
+		groupOf: groupOf,
+		counts:  make(map[string]map[string]int),
+	}
+}
+
+// add counts an error of the given type, which is empty if the error is
+// unclassified.
+func (c groupedErrorCounts) add(instance datatypes.ErrorInstance, name string) {
+	if name == "" {
+		name = unclassifiedErrorType
+	}
+
+	group := c.groupOf(instance)
+	if c.counts[group] == nil {
+		c.counts[group] = make(map[string]int)
+	}
+	c.counts[group][name]++
+}
+
+// unknownVersion is the game version errors without one are grouped under.
+const unknownVersion = "unknown"
+
+// errorVersion groups errors by the game version that reported them.
+func errorVersion(instance datatypes.ErrorInstance) string {
+	if instance.GameVersion == "" {
+		return unknownVersion
+	}
+	return instance.GameVersion
+}
+
+// unspecifiedPlatform is the platform errors without one are grouped under.
+const unspecifiedPlatform = "unspecified"
+
+// errorPlatform groups errors by the platform that reported them.
+func errorPlatform(instance datatypes.ErrorInstance) string {
+	if instance.Platform == "" {
+		return unspecifiedPlatform
+	}
+	return instance.Platform
+}
+
+// captureCount counts the value the pattern captures from the description,
+// if it matches. Patterns with more than one capture group have their groups
+// joined with captureSeparator. A nil pattern captures nothing.
+func captureCount(counts map[string]int, pattern *regexp.Regexp, description string) {
+	if pattern == nil {
+		return
+	}
+
+	match := pattern.FindStringSubmatch(description)
+	if len(match) > 1 {
+		counts[strings.Join(match[1:], captureSeparator)]++
+	}
+}
+
+// captureSeparator separates the groups of a value captured by captureCount.
+const captureSeparator = ", "
+
+// errorAnalysisOptions selects the optional parts of an errorAnalysis.
+type errorAnalysisOptions struct {
+	// captures is the pattern whose captured values are counted, or nil
+	captures *regexp.Regexp
+	// dayLocation is the time zone errors are counted by day in, or nil if
+	// they aren't
+	dayLocation *time.Location
+}
+
+// errorAnalysis is everything the evaluation computes from the errors across
+// the whole dataset.
+type errorAnalysis struct {
+	counts     errorTypeCounts
+	byVersion  groupedErrorCounts
+	byPlatform groupedErrorCounts
+	// byDay is only filled in if errorAnalysisOptions.dayLocation is set
+	byDay groupedErrorCounts
+	// variablesWithNoValue is how many instances of each variable name
+	// resulted in a "VariableHasNoValue" error
+	variablesWithNoValue map[string]int
+	// unknownCallables is how many instances of each callable name resulted
+	// in an "UnknownCallable" error
+	unknownCallables map[string]int
+	// captures is how many errors errorAnalysisOptions.captures captured
+	// each value from
+	captures map[string]int
+}
+
+// analyzeErrors computes every breakdown of the errors matching the filter
+// in a single pass over them, since the Error kind is by far the largest.
+// The variable and callable counts are nil if the patterns file doesn't
+// define the patterns they come from.
+func analyzeErrors(ctx context.Context, client store, filter queryFilter, options errorAnalysisOptions) (errorAnalysis, error) {
+	analysis := errorAnalysis{
+		counts:     newErrorTypeCounts(),
+		byVersion:  newGroupedErrorCounts(errorVersion),
+		byPlatform: newGroupedErrorCounts(errorPlatform),
+		captures:   make(map[string]int),
+	}
+	if options.dayLocation != nil {
+		analysis.byDay = newGroupedErrorCounts(func(instance datatypes.ErrorInstance) string {
+			return eventDate(instance.Timestamp, options.dayLocation)
+		})
+	}
+
+	variablePattern := findErrPattern("VariableHasNoValue")
+	if variablePattern != nil {
+		analysis.variablesWithNoValue = make(map[string]int)
+	}
+	callablePattern := findErrPattern("UnknownCallable")
+	if callablePattern != nil {
+		analysis.unknownCallables = make(map[string]int)
+	}
+
+	instanceCnt := 0
+	var errorInstance datatypes.ErrorInstance
+	err := runPaged(ctx, client, filter.query(datatypes.ErrorInstanceKind), &errorInstance, func() {
+		if !filter.includesError(errorInstance) {
+			return
+		}
+
+		instanceCnt++
+		name, _ := classifyError(errorInstance.Description)
+		analysis.counts.add(errorInstance, name)
+		analysis.byVersion.add(errorInstance, name)
+		analysis.byPlatform.add(errorInstance, name)
+		if analysis.byDay.groupOf != nil {
+			analysis.byDay.add(errorInstance, name)
+		}
+
+		captureCount(analysis.variablesWithNoValue, variablePattern, errorInstance.Description)
+		captureCount(analysis.unknownCallables, callablePattern, errorInstance.Description)
+		captureCount(analysis.captures, options.captures, errorInstance.Description)
+	})
+	if err != nil {
+		return errorAnalysis{}, fmt.Errorf("getting instances: %v", err)
+	}
+
+	log.Println("Got", instanceCnt, "error instances")
+
+	return analysis, nil
+}
+
+// editorUse returns the quantity of the UIDs that used the editor. Rather than
+// querying each UID, the UIDs with editor saves are collected with a distinct
+// projection on UID, like getUIDs does, which takes a round trip per page of
+// UIDs instead of one per UID. The projection can't be narrowed to the given
+// UIDs with an "in" filter, since Datastore doesn't allow projecting on a
+// property that's filtered by equality, so UIDs that weren't given are
+// dropped afterwards.
+func editorUse(ctx context.Context, client store, filter queryFilter, uids []string) (int, error) {
+	if filter.uid != "" {
+		// The query already filters on the UID by equality, so it's checked
+		// on its own
+		used, err := anyMatch(ctx, client, filter.query(datatypes.EditorContentKind))
+		if err != nil || !used {
 			return 0, err
 		}
+		return 1, nil
+	}
 
-		if count > 0 {
+	wanted := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		wanted[uid] = true
+	}
+
+	usedEditorCount := 0
+	err := runDistinctProjection(ctx, client, filter.query(datatypes.EditorContentKind), "UID", func(uid string) {
+		if wanted[uid] {
 			usedEditorCount++
+			// Each UID is only projected once, but a UID given twice
+			// shouldn't be counted twice either
+			delete(wanted, uid)
 		}
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return usedEditorCount, nil
 }
 
-// getUIDs returns all unique UIDs in the database.
-func getUIDs(ctx context.Context, client *datastore.Client) ([]string, error) {
-	query := datastore.NewQuery(datatypes.REPLCommandKind)
-
-	var replCommands []datatypes.REPLCommand
-	if _, err := client.GetAll(ctx, query, &replCommands); err != nil {
-		return nil, fmt.Errorf("getting REPL instances: %v", err)
-	}
+// uidKinds are the kinds of entities that record a UID.
+var uidKinds = []string{
+	datatypes.REPLCommandKind,
+	datatypes.EditorContentKind,
+	datatypes.ErrorInstanceKind,
+}
 
+// getUIDs returns all unique UIDs with events matching the filter, across
+// every kind of entity.
+func getUIDs(ctx context.Context, client store, filter queryFilter) ([]string, error) {
 	// Use map keys as a ramshackle "set" type
 	set := make(map[string]struct{})
-	for _, cmd := range replCommands {
-		set[cmd.UID] = struct{}{}
+
+	for _, kind := range uidKinds {
+		// Project on the UID so we don't pull full entities just to collect
+		// their UIDs
+		err := runDistinctProjection(ctx, client, filter.query(kind), "UID", func(uid string) {
+			if datatypes.ValidateUID(uid) != nil {
+				// Records from before UIDs were validated may be blank
+				return
+			}
+			if !filter.includesUID(uid) {
+				return
+			}
+			set[uid] = struct{}{}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting %v UIDs: %v", kind, err)
+		}
 	}
 
 	var output []string
 	for val := range set {
 		output = append(output, val)
 	}
+	sort.Strings(output)
 
 	return output, nil
 }
 
+// limitUIDs returns the first limit UIDs, or all of them if limit isn't
+// positive.
+func limitUIDs(uids []string, limit int) []string {
+	if limit > 0 && len(uids) > limit {
+		return uids[:limit]
+	}
+
+	return uids
+}
+
+// writeSession writes the events of a session to the session dump in
+// chronological order. Each error is annotated with the command that
+// immediately preceded it, as paired by commandAndErrors. The index and count
+// are the session's position among its UID's sessions.
+func writeSession(w io.Writer, sess session, index, count int) error {
+	header := fmt.Sprintf("=== %v (session %v/%v", dumpText(sess.uid), index+1, count)
+	if sess.sessionID != "" {
+		header += ", ID " + dumpText(sess.sessionID)
+	}
+	if _, err := io.WriteString(w, header+") ===\n"); err != nil {
+		return err
+	}
+
+	// commandAndErrors lists errors in the same order they appear in the
+	// session, so the nth error event is paired in the nth erroring pair
+	var causes []commandAndError
+	for _, pair := range sess.commandAndErrors() {
+		if pair.err != nil {
+			causes = append(causes, pair)
+		}
+	}
+
+	// Each piece of a line is written separately rather than concatenated,
+	// since the dump is buffered and that saves an allocation per event
+	for _, e := range sess.events {
+		pieces := [...]string{e.String(), "\n", "", "", ""}
+		if _, ok := e.(errorEvent); ok && len(causes) > 0 {
+			if causes[0].noCmd {
+				pieces[2] = "    after: no new command\n"
+			} else {
+				pieces[2], pieces[3], pieces[4] = "    after: ", dumpText(causes[0].cmd.Command), "\n"
+			}
+			causes = causes[1:]
+		}
+
+		for _, piece := range pieces {
+			if _, err := io.WriteString(w, piece); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func main() {
+	sessionGap := flag.Duration("session-gap", defaultSessionGap,
+		"the longest pause between two events in the same session, for events without a session ID")
+	format := flag.String("format", textFormat,
+		"the format to output results in, one of \"text\", \"csv\", \"json\" or \"markdown\"")
+	reportPath := flag.String("out", "",
+		"the file to write JSON or Markdown results to, or stdout if empty")
+	dumpPath := flag.String("dump-out", "",
+		"the file to write the session dump to, creating its directory if needed; defaults to "+
+			"user-sessions.txt, or user-sessions.jsonl with -dump-format jsonl")
+	dumpFormat := flag.String("dump-format", textDumpFormat,
+		"the format of the session dump, either \"text\" or \"jsonl\"")
+	outDir := flag.String("out-dir", "",
+		"if set, the session dump is split into a file per UID in this directory instead of written to -dump-out")
+	uid := flag.String("uid", "",
+		"if set, only the events of this UID are evaluated")
+	from := flag.String("from", "",
+		"if set, events before this time are ignored, as RFC3339 or Unix milliseconds")
+	to := flag.String("to", "",
+		"if set, events after this time are ignored, as RFC3339 or Unix milliseconds")
+	top := flag.Int("top", 20,
+		"the number of entries to include in rankings")
+	patternsPath := flag.String("patterns", "",
+		"a JSON file of error patterns to use instead of the built-in ones")
+	minSeverity := flag.String("min-severity", datatypes.SeverityInfo,
+		"the least severe errors to include in error analyses, one of \"info\", \"warning\" or \"fatal\"")
+	anonymize := flag.Bool("anonymize", false,
+		"replace UIDs in the output with pseudonymous tokens")
+	salt := flag.String("salt", "",
+		"the salt used to generate tokens with -anonymize, or random if empty")
+	excludeUIDs := flag.String("exclude-uids", "",
+		"a comma-separated list of UIDs, or a file of them, whose events are ignored")
+	limit := flag.Int("limit", 0,
+		"if positive, only the first N UIDs are included in the session dump and per-session "+
+			"stats; dataset-wide aggregates like error frequency always cover every UID")
+	tz := flag.String("tz", "UTC",
+		"the IANA time zone that activity is broken down by hour of day in")
+	project := flag.String("project", "",
+		"the ID of the project to read from, overriding DATASTORE_PROJECT_ID and the default of "+defaultProjectID)
+	verbose := flag.Bool("verbose", false,
+		"print progress to stderr as UIDs are processed")
+	var paste pasteThreshold
+	flag.IntVar(&paste.chars, "paste-chars", defaultPasteChars,
+		"the number of characters the editor must grow by between saves to count as a paste")
+	flag.Float64Var(&paste.ratio, "paste-ratio", defaultPasteRatio,
+		"if positive, saves where the editor grew to more than this multiple of its previous length also count as pastes")
+	writeSummaries := flag.Bool("write-summaries", false,
+		"store a DailySummary entity for each day in -tz, overwriting any from earlier runs")
+	captures := flag.String("captures", "",
+		"if set, the name of an error pattern whose captured values are ranked, like NoSwitchWithID")
+	sinceLastRun := flag.Bool("since-last-run", false,
+		"only read events from the days since the last run with this flag, and merge their daily "+
+			"summaries into the stored ones; implies -write-summaries")
+	full := flag.Bool("full", false,
+		"with -since-last-run, ignore the stored cursor and recompute every day's summary")
+	lookback := flag.Duration("lookback", defaultLookback,
+		"with -since-last-run, how late events may arrive and still be counted")
+	safeMinRuns := flag.Int("safe-min-runs", defaultSafeCommandMinRuns,
+		"the fewest times a command must be run to be ranked as safe")
+	minEvents := flag.Int("min-events", 0,
+		"sessions with fewer events are left out of the session dump and per-session stats, like "+
+			"session duration and the funnel; dataset-wide aggregates and per-UID stats still count them")
+	editorRunPattern := flag.String("editor-run-pattern", defaultEditorRunPattern,
+		"a regular expression matching REPL commands that run the editor's contents, beyond ones "+
+			"that call or refer to a name the editor defines")
+	flag.BoolVar(&escapeDump, "escape", false,
+		"escape control characters and other non-printable text in the text session dump, so it's safe to cat")
+	concurrency := flag.Int("concurrency", defaultConcurrency,
+		fmt.Sprintf("the number of UIDs whose sessions are built at once, at most %v", maxConcurrency))
+	flag.IntVar(&maxReadAttempts, "max-attempts", defaultMaxReadAttempts,
+		"the most times to try a Datastore read that fails with a transient error")
+	errorBoundsList := flag.String("errors-per-session-bounds", defaultErrorsPerSessionBounds,
+		"the comma-separated lower bounds of the buckets in the histogram of errors per session")
+	flag.Parse()
+
+	if *patternsPath != "" {
+		patterns, err := loadErrPatterns(*patternsPath)
+		if err != nil {
+			log.Fatalf("loading error patterns: %v", err)
+		}
+		errPatterns = patterns
+	}
+
+	var capturePattern *regexp.Regexp
+	if *captures != "" {
+		*captures = canonicalErrPatternName(*captures)
+		if capturePattern = findErrPattern(*captures); capturePattern == nil {
+			log.Fatalf("-captures: unknown error pattern %q", *captures)
+		}
+		if capturePattern.NumSubexp() == 0 {
+			log.Fatalf("-captures: error pattern %q has no capture groups", *captures)
+		}
+	}
+
+	switch *format {
+	case textFormat, csvFormat, jsonFormat, markdownFormat:
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+	if _, ok := dumpExtensions[*dumpFormat]; !ok {
+		log.Fatalf("unknown dump format %q", *dumpFormat)
+	}
+	if *dumpPath != "" && *outDir != "" {
+		log.Fatalf("-dump-out and -out-dir can't both be set")
+	}
+	if *dumpPath == "" {
+		*dumpPath = defaultDumpPath(*dumpFormat)
+	}
+
 	ctx := context.Background()
 
-	client, err := datastore.NewClient(ctx, "lambda-starship-user-stats")
+	projectID, err := resolveProjectID(*project)
+	if err != nil {
+		log.Fatalf("resolving project ID: %v", err)
+	}
+
+	datastoreClient, err := datastore.NewClient(ctx, projectID)
 	if err != nil {
 		log.Fatalf("creating Datastore client: %v", err)
 	}
+	client := clientStore{datastoreClient}
+
+	filter := queryFilter{uid: *uid}
+	var ok bool
+	if filter.minSeverity, ok = datatypes.SeverityRank(*minSeverity); !ok {
+		log.Fatalf("unknown severity %q", *minSeverity)
+	}
+	if *excludeUIDs != "" {
+		if filter.excludedUIDs, err = parseExcludedUIDs(*excludeUIDs); err != nil {
+			log.Fatalf("parsing -exclude-uids: %v", err)
+		}
+	}
+	if *from != "" {
+		if filter.from, err = parseTimestamp(*from); err != nil {
+			log.Fatalf("parsing -from: %v", err)
+		}
+	}
+	if *to != "" {
+		if filter.to, err = parseTimestamp(*to); err != nil {
+			log.Fatalf("parsing -to: %v", err)
+		}
+	}
+	if filter.from != 0 && filter.to != 0 && filter.from > filter.to {
+		log.Fatalf("-from must not be after -to")
+	}
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatalf("loading -tz: %v", err)
+	}
+	editorRun, err := regexp.Compile(*editorRunPattern)
+	if err != nil {
+		log.Fatalf("parsing -editor-run-pattern: %v", err)
+	}
+	errorBounds, err := parseBounds(*errorBoundsList)
+	if err != nil {
+		log.Fatalf("parsing -errors-per-session-bounds: %v", err)
+	}
+	if *concurrency < 1 {
+		log.Fatalf("-concurrency must be positive")
+	}
+	if *concurrency > maxConcurrency {
+		log.Printf("Capping -concurrency at %v", maxConcurrency)
+		*concurrency = maxConcurrency
+	}
+	if *limit < 0 {
+		log.Fatalf("-limit must not be negative")
+	}
+	if *full && !*sinceLastRun {
+		log.Fatalf("-full requires -since-last-run")
+	}
+	// Summaries overwrite the stored ones for each day, so they have to be
+	// computed from every event of the day
+	summaryFilters := []string{"limit", "uid", "exclude-uids", "min-events", "min-severity"}
+	if *sinceLastRun {
+		// The cursor moves past every event up to the latest one read, so
+		// events skipped by a filter would never be read by a later run
+		if name := setFlag(flag.CommandLine, append(summaryFilters, "from", "to")...); name != "" {
+			log.Fatalf("-since-last-run can't be used with -%v", name)
+		}
+		*writeSummaries = true
+	}
+	if *writeSummaries {
+		if name := setFlag(flag.CommandLine, summaryFilters...); name != "" {
+			log.Fatalf("-write-summaries can't be used with -%v", name)
+		}
+	}
+
+	var cursor evaluationCursor
+	if *sinceLastRun && !*full {
+		var found bool
+		cursor, found, err = loadCursor(ctx, client)
+		if err != nil {
+			log.Fatalf("loading cursor: %v", err)
+		}
+		if found {
+			filter.from = incrementalFrom(cursor.MaxTimestamp, *lookback, location)
+			log.Printf("Reading events since %v", millisToTime(filter.from).In(location).Format(time.RFC3339))
+		} else {
+			log.Printf("No cursor found, recomputing every day")
+		}
+	}
+
+	var uids []string
+	if filter.uid != "" {
+		if !filter.includesUID(filter.uid) {
+			log.Fatalf("-uid %v is excluded by -exclude-uids", filter.uid)
+		}
+
+		sess, err := newSession(ctx, client, filter, filter.uid)
+		if err != nil {
+			panic(err)
+		}
+		if len(sess.events) == 0 {
+			log.Printf("No events found for UID %v", filter.uid)
+			return
+		}
+
+		uids = []string{filter.uid}
+	} else {
+		uids, err = getUIDs(ctx, client, filter)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	matchCnt, err := errorTypeCount(ctx, client)
+	errorOptions := errorAnalysisOptions{captures: capturePattern}
+	if *writeSummaries {
+		errorOptions.dayLocation = location
+	}
+	errorStats, err := analyzeErrors(ctx, client, filter, errorOptions)
 	if err != nil {
 		panic(err)
 	}
-	log.Println("--- Error Frequency ---")
-	for name, cnt := range matchCnt {
-		log.Printf("%v: %v", name, cnt)
+
+	dump, err := newSessionDump(*dumpFormat, *dumpPath, *outDir)
+	if err != nil {
+		log.Fatalf("creating session dump: %v", err)
 	}
 
-	file, err := os.Create("user-sessions.txt")
+	editorUseCount, err := editorUse(ctx, client, filter, uids)
 	if err != nil {
 		panic(err)
 	}
-	defer file.Close()
 
-	// Get the variable frequency of VariableHasNoValue errors
-	varsWithNoValue, err := variableHasNoValueCount(ctx, client)
+	commands, err := analyzeCommands(ctx, client, filter)
 	if err != nil {
 		panic(err)
 	}
-	log.Println("--- VariableHasNoValue top variables ---")
-	for _, varWithNoValue := range varsWithNoValue {
-		log.Printf("%v: %v", varWithNoValue.variable, varWithNoValue.count)
+
+	report := Report{
+		ErrorTypeCounts:           errorStats.counts.byType,
+		ErrorTypeCountsByVersion:  errorStats.byVersion.counts,
+		ErrorTypeCountsByPlatform: errorStats.byPlatform.counts,
+		UnmatchedErrors:           rank(errorStats.counts.unmatched, *top),
+		UnclassifiedExamples:      errorStats.counts.unclassifiedExamples,
+		UnknownCallables:          rank(errorStats.unknownCallables, *top),
+		UnknownCallablesTotal:     sumCounts(errorStats.unknownCallables),
+		VariablesWithNoValue:      rank(errorStats.variablesWithNoValue, 0),
+		VariablesWithNoValueTotal: sumCounts(errorStats.variablesWithNoValue),
+		TopCommands:               rank(commands.counts, *top),
+		CommandCategories:         commands.categories,
+		CalledFunctions:           rank(commands.callables, *top),
+		UnparsableCommands:        commands.unparsable,
+		CommandLengths:            newCountDistribution(commands.lengths),
+		CommandLengthHistogram:    newCountHistogram(commands.lengths, commandLengthBounds),
+		EditorUseCount:            editorUseCount,
+		UIDCount:                  len(uids),
+	}
+	if *captures != "" {
+		report.Captures = &CaptureRanking{
+			Pattern: *captures,
+			Values:  rank(errorStats.captures, *top),
+		}
 	}
 
-	uids, err := getUIDs(ctx, client)
-	if err != nil {
-		panic(err)
+	var anon anonymizer
+	if *anonymize {
+		if anon, err = newAnonymizer(*salt); err != nil {
+			log.Fatalf("creating anonymizer: %v", err)
+		}
 	}
 
-	editorUseCount, err := editorUse(ctx, client, uids)
+	// Only a sample of UIDs is needed when iterating on the evaluation. The
+	// dataset-wide aggregates above are computed with queries over every UID,
+	// so they aren't affected
+	sessionUIDs := limitUIDs(uids, *limit)
+	if len(sessionUIDs) < len(uids) {
+		log.Printf("Limiting sessions to the first %v of %v UIDs", len(sessionUIDs), len(uids))
+	}
+
+	// Get the errors, commands, and editor saves from each user session. Only
+	// the events of the UIDs being worked on are held in memory at a time, and
+	// sessions are added in UID order so that the dump is deterministic.
+	aggregator := newSessionAggregator(&report, aggregatorOptions{
+		location:    location,
+		top:         *top,
+		paste:       paste,
+		safeMinRuns: *safeMinRuns,
+		editorRun:   editorRun,
+		errorBounds: errorBounds,
+	})
+	var progress *progressReporter
+	if *verbose {
+		progress = newProgressReporter(os.Stderr, len(sessionUIDs))
+	}
+	err = forEachSession(ctx, client, filter, sessionUIDs, *concurrency, func(uidIndex int, sess session) error {
+		if *anonymize {
+			sess.uid = anon.token(sess.uid)
+		}
+
+		aggregator.addUID(sess)
+
+		subSessions := dropShortSessions(groupSessions(sess, *sessionGap), *minEvents)
+		if err := dump.writeUID(sess.uid, subSessions); err != nil {
+			return fmt.Errorf("writing session info: %v", err)
+		}
+		for i, subSession := range subSessions {
+			aggregator.add(subSession, i)
+		}
+
+		progress.update(uidIndex + 1)
+		return nil
+	})
 	if err != nil {
-		panic(err)
+		dump.close()
+		log.Fatalf("processing sessions: %v", err)
+	}
+	if err := dump.close(); err != nil {
+		log.Fatalf("writing session info: %v", err)
 	}
-	log.Printf("%v sessions used the editor out of %v", editorUseCount, len(uids))
+	log.Printf("Wrote session info to %v", dump.location())
 
-	// Get the errors, commands, and editor saves from each user session
-	for _, uid := range uids {
-		sess, err := newSession(ctx, client, uid)
-		if err != nil {
-			panic(err)
+	aggregator.finish()
+
+	if *writeSummaries {
+		summaries := newDailySummaries(errorStats.byDay.counts, aggregator.days, time.Now().UnixNano()/int64(time.Millisecond))
+		if err := writeDailySummaries(ctx, client, summaries); err != nil {
+			log.Fatalf("writing daily summaries: %v", err)
+		}
+		log.Printf("Wrote %v daily summaries", len(summaries))
+
+		if *sinceLastRun {
+			if aggregator.maxTimestamp > cursor.MaxTimestamp {
+				cursor.MaxTimestamp = aggregator.maxTimestamp
+			}
+			cursor.UpdatedAt = time.Now().UnixNano() / int64(time.Millisecond)
+			if err := saveCursor(ctx, client, cursor); err != nil {
+				log.Fatalf("saving cursor: %v", err)
+			}
 		}
+	}
 
-		for _, e := range sess.events {
-			file.WriteString(e.String() + "\n")
+	switch *format {
+	case textFormat:
+		logReport(report)
+	case csvFormat:
+		if err := writeErrorTypeCountsCSV(os.Stdout, errorStats.counts.byType); err != nil {
+			log.Fatalf("writing error frequency CSV: %v", err)
+		}
+	case jsonFormat, markdownFormat:
+		out := os.Stdout
+		if *reportPath != "" {
+			out, err = os.Create(*reportPath)
+			if err != nil {
+				log.Fatalf("creating output file: %v", err)
+			}
+			defer out.Close()
+		}
+
+		if *format == jsonFormat {
+			err = writeReportJSON(out, report)
+		} else {
+			err = writeReportMarkdown(out, report)
+		}
+		if err != nil {
+			log.Fatalf("writing report: %v", err)
 		}
 	}
-	log.Printf("Wrote session info to user-sessions.txt")
 }
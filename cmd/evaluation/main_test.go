@@ -0,0 +1,980 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestParseBounds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{"0,1,3,6,11", []int{0, 1, 3, 6, 11}, false},
+		{" 0, 5 ", []int{0, 5}, false},
+		{"5", []int{5}, false},
+		{"0,3,3", nil, true},
+		{"3,1", nil, true},
+		{"0,,1", nil, true},
+		{"one", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseBounds(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseBounds() error = %v, want error: %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseBounds() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	flags := flag.NewFlagSet("evaluation", flag.ContinueOnError)
+	flags.Int("limit", 0, "")
+	flags.String("uid", "", "")
+	flags.String("tz", "UTC", "")
+	if err := flags.Parse([]string{"-tz", "UTC", "-limit", "0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flag counts as set even when it's given its default value
+	if got := setFlag(flags, "uid", "limit"); got != "limit" {
+		t.Errorf("setFlag() = %q, want %q", got, "limit")
+	}
+	if got := setFlag(flags, "uid"); got != "" {
+		t.Errorf("setFlag() = %q, want none", got)
+	}
+}
+
+func TestResolveProjectID(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     string
+		env      string
+		emulator string
+		want     string
+		wantErr  bool
+	}{
+		{"default", "", "", "", defaultProjectID, false},
+		{"env", "", "staging", "", "staging", false},
+		{"flag", "local", "", "", "local", false},
+		{"flag over env", "local", "staging", "", "local", false},
+		// The emulator needs a project to be chosen, not the production one
+		{"emulator without a project", "", "", "localhost:8081", "", true},
+		{"emulator with env", "", "staging", "localhost:8081", "staging", false},
+		{"emulator with flag", "local", "", "localhost:8081", "local", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("DATASTORE_PROJECT_ID", test.env)
+			t.Setenv("DATASTORE_EMULATOR_HOST", test.emulator)
+
+			got, err := resolveProjectID(test.flag)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want an error: %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("resolveProjectID(%q) = %q, want %q", test.flag, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Timestamp: 1, Description: "Variable x has no value"},
+		datatypes.ErrorInstance{UID: "a", Timestamp: 2, Description: "Variable y has no value"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 3, Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 4, Description: "Something 'odd' happened 3 times"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 5, Description: "Something 'else' happened 4 times"},
+		datatypes.ErrorInstance{UID: "c", Timestamp: 6, Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 7, Description: "Too many arguments", Severity: datatypes.SeverityInfo},
+	)
+
+	filter := queryFilter{
+		excludedUIDs: map[string]bool{"c": true},
+		minSeverity:  1,
+	}
+	analysis, err := analyzeErrors(context.Background(), client, filter, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantByType := map[string]int{
+		"VariableHasNoValue":  2,
+		"TooManyArguments":    1,
+		unclassifiedErrorType: 2,
+	}
+	if !reflect.DeepEqual(analysis.counts.byType, wantByType) {
+		t.Errorf("byType = %v, want %v", analysis.counts.byType, wantByType)
+	}
+
+	wantUnmatched := map[string]int{"Something <id> happened <n> times": 2}
+	if !reflect.DeepEqual(analysis.counts.unmatched, wantUnmatched) {
+		t.Errorf("unmatched = %v, want %v", analysis.counts.unmatched, wantUnmatched)
+	}
+	if len(analysis.counts.unclassifiedExamples) != 2 {
+		t.Errorf("got %v unclassified examples, want 2", len(analysis.counts.unclassifiedExamples))
+	}
+
+	wantVariables := map[string]int{"x": 1, "y": 1}
+	if !reflect.DeepEqual(analysis.variablesWithNoValue, wantVariables) {
+		t.Errorf("variablesWithNoValue = %v, want %v", analysis.variablesWithNoValue, wantVariables)
+	}
+	if client.runs != 1 {
+		t.Errorf("ran %v queries, want a single pass over the errors", client.runs)
+	}
+}
+
+func TestAnalyzeErrorsByVersion(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", GameVersion: "1.0", Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "a", GameVersion: "1.0", Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "b", GameVersion: "1.1", Description: "Variable x has no value"},
+		datatypes.ErrorInstance{UID: "b", GameVersion: "1.1", Description: "Not a known error"},
+		datatypes.ErrorInstance{UID: "c", Description: "Too many arguments"},
+	)
+
+	analysis, err := analyzeErrors(context.Background(), client, queryFilter{}, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]int{
+		"1.0":          {"TooManyArguments": 2},
+		"1.1":          {"VariableHasNoValue": 1, unclassifiedErrorType: 1},
+		unknownVersion: {"TooManyArguments": 1},
+	}
+	if !reflect.DeepEqual(analysis.byVersion.counts, want) {
+		t.Errorf("byVersion = %v, want %v", analysis.byVersion.counts, want)
+	}
+}
+
+func TestAnalyzeErrorsByPlatform(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Platform: "windows", Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "a", Platform: "windows", Description: "Light cannot be powered with backup generator"},
+		datatypes.ErrorInstance{UID: "b", Platform: "linux", Description: "Light cannot be powered with backup generator"},
+		datatypes.ErrorInstance{UID: "b", Platform: "linux", Description: "Light cannot be powered with backup generator"},
+		datatypes.ErrorInstance{UID: "c", Description: "Too many arguments"},
+	)
+
+	analysis, err := analyzeErrors(context.Background(), client, queryFilter{}, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]int{
+		"windows":           {"TooManyArguments": 1, "LightGenerator": 1},
+		"linux":             {"LightGenerator": 2},
+		unspecifiedPlatform: {"TooManyArguments": 1},
+	}
+	if !reflect.DeepEqual(analysis.byPlatform.counts, want) {
+		t.Errorf("byPlatform = %v, want %v", analysis.byPlatform.counts, want)
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "player", Timestamp: 20, Description: "Too many arguments"},
+		datatypes.ErrorInstance{UID: "other", Timestamp: 5})
+	client.add(datatypes.REPLCommandKind,
+		datatypes.REPLCommand{UID: "player", Timestamp: 30, Command: "(fire)"},
+		datatypes.REPLCommand{UID: "player", Timestamp: 20, Command: "(help 1 2)"})
+	client.add(datatypes.EditorContentKind,
+		datatypes.EditorContent{UID: "player", Timestamp: 10})
+
+	sess, err := newSession(context.Background(), client, queryFilter{}, "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The command comes before the error it caused, which has the same
+	// timestamp
+	want := []event{
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 10}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 20, Command: "(help 1 2)"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 20, Description: "Too many arguments"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 30, Command: "(fire)"}),
+	}
+	if !reflect.DeepEqual(sess.events, want) {
+		t.Errorf("events = %v, want %v", sess.events, want)
+	}
+}
+
+func BenchmarkNewSession(b *testing.B) {
+	client := newFakeStore()
+	for i := 0; i < 3000; i++ {
+		timestamp := int64(i)
+		switch i % 3 {
+		case 0:
+			client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: "player", Timestamp: timestamp})
+		case 1:
+			client.add(datatypes.ErrorInstanceKind, datatypes.ErrorInstance{UID: "player", Timestamp: timestamp})
+		case 2:
+			client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: "player", Timestamp: timestamp})
+		}
+	}
+
+	// With a round trip per query, running the three queries one after the
+	// other takes three times the latency, and concurrently takes one
+	for _, latency := range []time.Duration{0, 5 * time.Millisecond} {
+		b.Run(fmt.Sprintf("latency %v", latency), func(b *testing.B) {
+			client.latency = latency
+			for i := 0; i < b.N; i++ {
+				if _, err := newSession(context.Background(), client, queryFilter{}, "player"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandAndErrors(t *testing.T) {
+	help := datatypes.REPLCommand{UID: "player", Timestamp: 20, Command: "(help 1 2)"}
+	fire := datatypes.REPLCommand{UID: "player", Timestamp: 30, Command: "(fire)"}
+	tooMany := datatypes.ErrorInstance{UID: "player", Timestamp: 20, Description: "Too many arguments"}
+	overheated := datatypes.ErrorInstance{UID: "player", Timestamp: 25, Description: "Ship overheated"}
+	noCmd := datatypes.REPLCommand{UID: "player"}
+
+	tests := []struct {
+		name   string
+		events []event
+		want   []commandAndError
+	}{
+		{
+			"error first",
+			[]event{errorEvent(tooMany), replEvent(fire)},
+			[]commandAndError{{noCmd, &tooMany, true}, {fire, nil, false}},
+		},
+		{
+			"only an error",
+			[]event{errorEvent(tooMany)},
+			[]commandAndError{{noCmd, &tooMany, true}},
+		},
+		{
+			"error after an editor save",
+			[]event{editorEvent(datatypes.EditorContent{UID: "player"}), errorEvent(tooMany)},
+			[]commandAndError{{noCmd, &tooMany, true}},
+		},
+		{
+			"second error after a command",
+			[]event{replEvent(help), errorEvent(tooMany), errorEvent(overheated), replEvent(fire)},
+			[]commandAndError{{help, &tooMany, false}, {noCmd, &overheated, true}, {fire, nil, false}},
+		},
+		{
+			"commands without errors",
+			[]event{replEvent(help), replEvent(fire)},
+			[]commandAndError{{help, nil, false}, {fire, nil, false}},
+		},
+		{"empty", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			if got := sess.commandAndErrors(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("commandAndErrors() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		description string
+		want        string
+		wantOK      bool
+	}{
+		{"Too many arguments", "TooManyArguments", true},
+		{"Variable speed has no value", "VariableHasNoValue", true},
+		// Both of these match two patterns, and are classified as the one
+		// that comes first in errPatterns
+		{"Unknown callable 'fire': Too many arguments", "UnknownCallable", true},
+		{"Too many arguments, and Variable speed has no value", "VariableHasNoValue", true},
+		{"Ship overheated", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// Classification used to depend on map iteration order, so it's
+			// checked over many runs
+			for i := 0; i < 100; i++ {
+				got, ok := classifyError(test.description)
+				if got != test.want || ok != test.wantOK {
+					t.Fatalf("run %v: classifyError() = %q, %v, want %q, %v", i, got, ok, test.want, test.wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyErrorPriority(t *testing.T) {
+	realPatterns := errPatterns
+	defer func() { errPatterns = realPatterns }()
+
+	// The same description is classified by whichever pattern comes first
+	description := "Unknown callable 'fire': Too many arguments"
+	tooMany := errPattern{"TooManyArguments", regexp.MustCompile("Too many arguments")}
+	unknown := errPattern{"UnknownCallable", regexp.MustCompile("Unknown callable '(.*)'")}
+
+	errPatterns = []errPattern{tooMany, unknown}
+	if got, _ := classifyError(description); got != "TooManyArguments" {
+		t.Errorf("with TooManyArguments first, classifyError() = %q", got)
+	}
+	errPatterns = []errPattern{unknown, tooMany}
+	if got, _ := classifyError(description); got != "UnknownCallable" {
+		t.Errorf("with UnknownCallable first, classifyError() = %q", got)
+	}
+}
+
+func TestNormalizeErrorDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		want        string
+	}{
+		{"Too many arguments", "Too many arguments"},
+		{"  Too many arguments\n", "Too many arguments"},
+		{"Variable 'speed' has no value", "Variable <id> has no value"},
+		{`Variable "speed" has no value`, "Variable <id> has no value"},
+		{"Variable `speed` has no value", "Variable <id> has no value"},
+		{"Expected 2 arguments but got 10", "Expected <n> arguments but got <n>"},
+		{"Value 1.5 is out of range", "Value <n> is out of range"},
+		// Numbers inside a quoted identifier are replaced along with it
+		{"Unknown callable 'thruster2' at line 3", "Unknown callable <id> at line <n>"},
+		{"Unknown callable '' at line 3", "Unknown callable <id> at line <n>"},
+		// Digits that are part of a name aren't numbers
+		{"Module v2 failed", "Module v2 failed"},
+		// An unterminated quote isn't masked
+		{"Expected ' but got 4", "Expected ' but got <n>"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := normalizeErrorDescription(test.description); got != test.want {
+				t.Errorf("normalizeErrorDescription(%q) = %q, want %q", test.description, got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorTypeCountsUnmatched(t *testing.T) {
+	counts := newErrorTypeCounts()
+	for _, description := range []string{
+		"Variable 'speed' has no value",
+		"Undefined label 'fly' at line 3",
+		"Undefined label 'jump' at line 12",
+		"Undefined label 'fly' at line 3",
+		"Stack depth 100 exceeded",
+		"Stack depth 250 exceeded",
+		"Undefined label 'warp' at line 1",
+		"Undefined label 'boost' at line 1",
+		"Undefined label 'dash' at line 1",
+	} {
+		name, _ := classifyError(description)
+		counts.add(datatypes.ErrorInstance{Description: description}, name)
+	}
+
+	wantUnmatched := map[string]int{
+		"Undefined label <id> at line <n>": 6,
+		"Stack depth <n> exceeded":         2,
+	}
+	if !reflect.DeepEqual(counts.unmatched, wantUnmatched) {
+		t.Errorf("unmatched = %v, want %v", counts.unmatched, wantUnmatched)
+	}
+	if counts.byType[unclassifiedErrorType] != 8 {
+		t.Errorf("counted %v unclassified errors, want 8", counts.byType[unclassifiedErrorType])
+	}
+
+	// Examples are the first distinct descriptions, before normalizing
+	wantExamples := []string{
+		"Undefined label 'fly' at line 3",
+		"Undefined label 'jump' at line 12",
+		"Stack depth 100 exceeded",
+		"Stack depth 250 exceeded",
+		"Undefined label 'warp' at line 1",
+	}
+	if !reflect.DeepEqual(counts.unclassifiedExamples, wantExamples) {
+		t.Errorf("unclassifiedExamples = %q, want %q", counts.unclassifiedExamples, wantExamples)
+	}
+}
+
+func TestErrorTypeCountsUnclassified(t *testing.T) {
+	classified := []string{
+		"Too many arguments",
+		"Variable speed has no value",
+		"Too many arguments",
+	}
+
+	tests := []struct {
+		name         string
+		descriptions []string
+		want         map[string]int
+	}{
+		{"all classified", classified, map[string]int{"TooManyArguments": 2, "VariableHasNoValue": 1}},
+		// Adding errors that match nothing doesn't change the other counts
+		{"one unclassified", append([]string{"The ship exploded"}, classified...), map[string]int{
+			"TooManyArguments":    2,
+			"VariableHasNoValue":  1,
+			unclassifiedErrorType: 1,
+		}},
+		{"only unclassified", []string{"The ship exploded", "", "The ship exploded"}, map[string]int{
+			unclassifiedErrorType: 3,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := newErrorTypeCounts()
+			for _, description := range test.descriptions {
+				name, _ := classifyError(description)
+				counts.add(datatypes.ErrorInstance{Description: description}, name)
+			}
+
+			if !reflect.DeepEqual(counts.byType, test.want) {
+				t.Errorf("byType = %v, want %v", counts.byType, test.want)
+			}
+			if (len(counts.unclassifiedExamples) > 0) != (test.want[unclassifiedErrorType] > 0) {
+				t.Errorf("unclassifiedExamples = %q with %v unclassified errors",
+					counts.unclassifiedExamples, test.want[unclassifiedErrorType])
+			}
+		})
+	}
+}
+
+func TestAnalyzeErrorsVariableCounts(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Description: "Variable speed has no value"},
+		datatypes.ErrorInstance{UID: "a", Description: "Variable speed has no value"},
+		datatypes.ErrorInstance{UID: "b", Description: "Error: Variable speed has no value"},
+		datatypes.ErrorInstance{UID: "b", Description: "Variable speed has no value (line 3)"},
+		datatypes.ErrorInstance{UID: "c", Description: "Variable heading has no value"},
+	)
+
+	analysis, err := analyzeErrors(context.Background(), client, queryFilter{}, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Differently worded descriptions of the same variable are counted
+	// together, by the variable's name rather than the whole description
+	want := map[string]int{"speed": 4, "heading": 1}
+	if !reflect.DeepEqual(analysis.variablesWithNoValue, want) {
+		t.Errorf("variablesWithNoValue = %v, want %v", analysis.variablesWithNoValue, want)
+	}
+}
+
+func TestCaptureCount(t *testing.T) {
+	pattern := regexp.MustCompile("Argument ([^\\s]+) must be of type ([^\\s]+)")
+
+	tests := []struct {
+		name         string
+		pattern      *regexp.Regexp
+		descriptions []string
+		want         map[string]int
+	}{
+		{
+			"one group",
+			findErrPattern("VariableHasNoValue"),
+			[]string{"Variable x has no value", "Variable x has no value", "Variable y has no value", "Too many arguments"},
+			map[string]int{"x": 2, "y": 1},
+		},
+		{
+			"two groups",
+			pattern,
+			[]string{"Argument 1 must be of type number", "Argument 1 must be of type number"},
+			map[string]int{"1, number": 2},
+		},
+		{
+			"no groups",
+			regexp.MustCompile("Too many arguments"),
+			[]string{"Too many arguments"},
+			map[string]int{},
+		},
+		{"nil pattern", nil, []string{"Variable x has no value"}, map[string]int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := make(map[string]int)
+			for _, description := range test.descriptions {
+				captureCount(counts, test.pattern, description)
+			}
+			if !reflect.DeepEqual(counts, test.want) {
+				t.Errorf("counts = %v, want %v", counts, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeErrorsCaptures(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Timestamp: 1, Description: "No such switch with ID main exists"},
+		datatypes.ErrorInstance{UID: "a", Timestamp: 2, Description: "No such switch with ID main exists"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 3, Description: "No such switch with ID aux exists"},
+		datatypes.ErrorInstance{UID: "a", Timestamp: 4, Description: "Argument 1 must be of type number, got string"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 5, Description: "Argument 1 must be of type number, got string"},
+		datatypes.ErrorInstance{UID: "b", Timestamp: 6, Description: "Argument 2 must be of type symbol, got number"},
+		datatypes.ErrorInstance{UID: "c", Timestamp: 7, Description: "Too many arguments"},
+	)
+
+	tests := []struct {
+		pattern string
+		want    []RankedValue
+	}{
+		{"NoSwitchWithID", []RankedValue{{"main", 2}, {"aux", 1}}},
+		// Every group is captured, so the same argument of different types
+		// is counted separately
+		{"ArgumentMustBeOfType", []RankedValue{{"1, number, string", 2}, {"2, symbol, number", 1}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			options := errorAnalysisOptions{captures: findErrPattern(test.pattern)}
+			analysis, err := analyzeErrors(context.Background(), client, queryFilter{}, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rank(analysis.captures, 0); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("captures = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetUIDs(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.REPLCommandKind,
+		datatypes.REPLCommand{UID: "commander", Timestamp: 10},
+		datatypes.REPLCommand{UID: "both", Timestamp: 20})
+	client.add(datatypes.EditorContentKind,
+		datatypes.EditorContent{UID: "editor", Timestamp: 10},
+		datatypes.EditorContent{UID: "both", Timestamp: 30})
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "erroring", Timestamp: 10},
+		datatypes.ErrorInstance{UID: "erroring", Timestamp: 40},
+		datatypes.ErrorInstance{UID: "excluded", Timestamp: 10},
+		datatypes.ErrorInstance{UID: "", Timestamp: 10})
+
+	tests := []struct {
+		name   string
+		filter queryFilter
+		want   []string
+	}{
+		// A UID is found from any kind of event, including one that only
+		// ever recorded errors or editor saves
+		{"every kind", queryFilter{excludedUIDs: map[string]bool{"excluded": true}}, []string{"both", "commander", "editor", "erroring"}},
+		{"only errors in range", queryFilter{from: 40, to: 40}, []string{"erroring"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := getUIDs(context.Background(), client, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getUIDs() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLimitUIDs(t *testing.T) {
+	uids := []string{"a", "b", "c"}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{0, []string{"a", "b", "c"}},
+		{-1, []string{"a", "b", "c"}},
+		{1, []string{"a"}},
+		{2, []string{"a", "b"}},
+		{3, []string{"a", "b", "c"}},
+		{4, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.limit), func(t *testing.T) {
+			if got := limitUIDs(uids, test.limit); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("limitUIDs(%v, %v) = %v, want %v", uids, test.limit, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLimitSessions(t *testing.T) {
+	client := newFakeStore()
+	for i := 0; i < 5; i++ {
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: fmt.Sprintf("uid-%v", i), Timestamp: int64(i)})
+	}
+
+	uids, err := getUIDs(context.Background(), client, queryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{1, 3, 5} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			var report Report
+			aggregator := newTestAggregator(&report)
+			var emitted []string
+			err := forEachSession(context.Background(), client, queryFilter{}, limitUIDs(uids, limit), 2, func(_ int, sess session) error {
+				emitted = append(emitted, sess.uid)
+				for i, subSession := range groupSessions(sess, defaultSessionGap) {
+					aggregator.add(subSession, i)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			aggregator.finish()
+
+			if want := uids[:limit]; !reflect.DeepEqual(emitted, want) {
+				t.Errorf("emitted the sessions of %v, want %v", emitted, want)
+			}
+			if len(report.Sessions) != limit {
+				t.Errorf("reported %v sessions, want %v", len(report.Sessions), limit)
+			}
+		})
+	}
+}
+
+func TestQueryFilterBounds(t *testing.T) {
+	const from, to = 1000, 2000
+
+	// An event of each kind just outside and exactly on each bound
+	client := newFakeStore()
+	for _, timestamp := range []int64{from - 1, from, to, to + 1} {
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: "player", Timestamp: timestamp, Command: "(fire)"})
+		client.add(datatypes.ErrorInstanceKind, datatypes.ErrorInstance{UID: "player", Timestamp: timestamp, Description: "Too many arguments"})
+		client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: "player", Timestamp: timestamp})
+	}
+	client.add(datatypes.ErrorInstanceKind, datatypes.ErrorInstance{UID: "early", Timestamp: from - 1})
+	client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: "late", Timestamp: to + 1})
+
+	// Both bounds are inclusive
+	filter := queryFilter{from: from, to: to}
+
+	// The session dump and per-session stats are built from newSession
+	sess, err := newSession(context.Background(), client, filter, "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.events) != 6 {
+		t.Errorf("session has %v events, want the 6 on the bounds", len(sess.events))
+	}
+	for _, e := range sess.events {
+		if timestamp := e.getTimestamp(); timestamp < from || timestamp > to {
+			t.Errorf("session has an event at %v, outside [%v, %v]", timestamp, from, to)
+		}
+	}
+
+	var dump strings.Builder
+	if err := writeSession(&dump, sess, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if commands := strings.Count(dump.String(), "REPL : "); commands != 2 {
+		t.Errorf("dump has %v commands, want the 2 on the bounds:\n%v", commands, dump.String())
+	}
+
+	// The dataset-wide report
+	analysis, err := analyzeErrors(context.Background(), client, filter, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := analysis.counts.byType["TooManyArguments"]; count != 2 {
+		t.Errorf("counted %v errors, want the 2 on the bounds", count)
+	}
+
+	uids, err := getUIDs(context.Background(), client, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(uids, []string{"player"}) {
+		t.Errorf("getUIDs() = %v, want only the UID with events in range", uids)
+	}
+
+	editorUseCount, err := editorUse(context.Background(), client, filter, []string{"player", "late"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editorUseCount != 1 {
+		t.Errorf("editorUse() = %v, want 1", editorUseCount)
+	}
+}
+
+func TestSortEvents(t *testing.T) {
+	editor := editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 10, Content: "(fire)"})
+	fire := replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 10, Command: "(fire)"})
+	help := replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 10, Command: "(help)"})
+	overheated := errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 10, Description: "Ship overheated"})
+	tooMany := errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 10, Description: "Too many arguments"})
+	earlier := editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 5})
+	later := errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 20})
+
+	tests := []struct {
+		name   string
+		events []event
+		want   []event
+	}{
+		{
+			"commands before errors before editor saves",
+			[]event{editor, tooMany, fire},
+			[]event{fire, tooMany, editor},
+		},
+		{
+			// Events of the same type keep the order they were queried in
+			"ties of the same type",
+			[]event{overheated, editor, help, tooMany, fire},
+			[]event{help, fire, overheated, tooMany, editor},
+		},
+		{
+			"timestamps first",
+			[]event{later, editor, earlier, fire},
+			[]event{earlier, fire, editor, later},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The order is the same every time, however the sort works
+			for i := 0; i < 100; i++ {
+				events := append([]event(nil), test.events...)
+				sortEvents(events)
+				if !reflect.DeepEqual(events, test.want) {
+					t.Fatalf("run %v: sortEvents() = %v, want %v", i, events, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDropShortSessions(t *testing.T) {
+	withEvents := func(n int) session {
+		sess := session{uid: fmt.Sprintf("%v events", n)}
+		for i := 0; i < n; i++ {
+			sess.events = append(sess.events, replEvent(datatypes.REPLCommand{Timestamp: int64(i)}))
+		}
+		return sess
+	}
+	sessions := []session{withEvents(2), withEvents(3), withEvents(4), withEvents(1)}
+
+	tests := []struct {
+		minEvents int
+		want      []string
+	}{
+		{0, []string{"2 events", "3 events", "4 events", "1 events"}},
+		{1, []string{"2 events", "3 events", "4 events", "1 events"}},
+		// A session with exactly minEvents is kept, and one with one fewer
+		// is dropped
+		{3, []string{"3 events", "4 events"}},
+		{4, []string{"4 events"}},
+		{5, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.minEvents), func(t *testing.T) {
+			var got []string
+			for _, sess := range dropShortSessions(sessions, test.minEvents) {
+				got = append(got, sess.uid)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("dropShortSessions() kept %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeErrorsUnknownCallables(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstance{UID: "a", Description: "Unknown callable 'fly'"},
+		datatypes.ErrorInstance{UID: "a", Description: "Unknown callable 'fly'"},
+		datatypes.ErrorInstance{UID: "b", Description: "Error: Unknown callable 'fly'"},
+		datatypes.ErrorInstance{UID: "b", Description: "Unknown callable 'launch'"},
+		datatypes.ErrorInstance{UID: "c", Description: "Unknown callable 'warp'"},
+		datatypes.ErrorInstance{UID: "c", Description: "Too many arguments"},
+	)
+
+	analysis, err := analyzeErrors(context.Background(), client, queryFilter{}, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every description of the same callable adds to one count
+	want := map[string]int{"fly": 3, "launch": 1, "warp": 1}
+	if !reflect.DeepEqual(analysis.unknownCallables, want) {
+		t.Errorf("unknownCallables = %v, want %v", analysis.unknownCallables, want)
+	}
+
+	// The total covers the callables left out of a short ranking
+	ranked := rank(analysis.unknownCallables, 1)
+	if !reflect.DeepEqual(ranked, []RankedValue{{"fly", 3}}) {
+		t.Errorf("top callable = %v, want fly", ranked)
+	}
+	if total := sumCounts(analysis.unknownCallables); total != 5 {
+		t.Errorf("total = %v, want 5", total)
+	}
+}
+
+func TestParseExcludedUIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qa.txt")
+	if err := os.WriteFile(path, []byte("qa-1\nqa-2, qa-3\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]bool
+	}{
+		{"empty", "", map[string]bool{}},
+		{"one", "qa-1", map[string]bool{"qa-1": true}},
+		{"list", "qa-1, qa-2,,qa-3 ", map[string]bool{"qa-1": true, "qa-2": true, "qa-3": true}},
+		{"file", path, map[string]bool{"qa-1": true, "qa-2": true, "qa-3": true}},
+		// A missing file is taken to be a UID
+		{"missing file", "missing.txt", map[string]bool{"missing.txt": true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseExcludedUIDs(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseExcludedUIDs(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExcludedUIDs(t *testing.T) {
+	client := newFakeStore()
+	for _, uid := range []string{"player", "qa", "qa-2"} {
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: uid, Timestamp: 1000, Command: "(fire " + uid + ")"})
+		client.add(datatypes.ErrorInstanceKind, datatypes.ErrorInstance{UID: uid, Timestamp: 1000, Description: "Too many arguments"})
+		client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: uid, Timestamp: 2000, Content: "(fire)"})
+	}
+
+	excluded, err := parseExcludedUIDs("qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := queryFilter{excludedUIDs: excluded}
+	ctx := context.Background()
+
+	// Matching is exact, so qa-2 isn't excluded along with qa
+	uids, err := getUIDs(ctx, client, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"player", "qa-2"}; !reflect.DeepEqual(uids, want) {
+		t.Fatalf("getUIDs() = %v, want %v", uids, want)
+	}
+
+	errorStats, err := analyzeErrors(ctx, client, filter, errorAnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := errorStats.counts.byType["TooManyArguments"]; got != 2 {
+		t.Errorf("counted %v errors, want 2", got)
+	}
+
+	commands, err := analyzeCommands(ctx, client, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := commands.counts["(fire qa)"]; ok || len(commands.counts) != 2 {
+		t.Errorf("command counts = %v, want only those of player and qa-2", commands.counts)
+	}
+
+	editorUseCount, err := editorUse(ctx, client, filter, uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editorUseCount != 2 {
+		t.Errorf("editorUse() = %v, want 2", editorUseCount)
+	}
+
+	var out strings.Builder
+	dump := &sessionDump{format: jsonlDumpFormat}
+	err = forEachSession(ctx, client, filter, uids, 2, func(_ int, sess session) error {
+		return dump.writeSessions(&out, sess.uid, groupSessions(sess, defaultSessionGap))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), `"qa"`) {
+		t.Errorf("the dump has events of an excluded UID:\n%v", out.String())
+	}
+	if got := strings.Count(out.String(), "\n"); got != 6 {
+		t.Errorf("the dump has %v events, want 6:\n%v", got, out.String())
+	}
+}
+
+func TestIncludesErrorSeverity(t *testing.T) {
+	rank := func(severity string) int {
+		r, ok := datatypes.SeverityRank(severity)
+		if !ok {
+			t.Fatalf("invalid severity %q", severity)
+		}
+		return r
+	}
+
+	tests := []struct {
+		minSeverity string
+		severity    string
+		want        bool
+	}{
+		// Each minimum includes errors of its own severity and above
+		{datatypes.SeverityInfo, datatypes.SeverityInfo, true},
+		{datatypes.SeverityInfo, datatypes.SeverityFatal, true},
+		{datatypes.SeverityWarning, datatypes.SeverityInfo, false},
+		{datatypes.SeverityWarning, datatypes.SeverityWarning, true},
+		{datatypes.SeverityWarning, datatypes.SeverityFatal, true},
+		{datatypes.SeverityFatal, datatypes.SeverityWarning, false},
+		{datatypes.SeverityFatal, datatypes.SeverityFatal, true},
+		// Errors without a severity are warnings
+		{datatypes.SeverityWarning, "", true},
+		{datatypes.SeverityFatal, "", false},
+		// Unknown severities were stored before validation and are kept
+		{datatypes.SeverityFatal, "crash", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.minSeverity+"/"+test.severity, func(t *testing.T) {
+			filter := queryFilter{minSeverity: rank(test.minSeverity)}
+			instance := datatypes.ErrorInstance{UID: "player", Severity: test.severity}
+			if got := filter.includesError(instance); got != test.want {
+				t.Errorf("includesError() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "unicode/utf8"
+
+// Defaults for pasteThreshold.
+const (
+	defaultPasteChars = 200
+	defaultPasteRatio = 0
+)
+
+// pasteThreshold decides how much the editor's contents must grow between
+// two saves for the later one to count as a paste rather than typing.
+type pasteThreshold struct {
+	// chars is how many characters the contents must grow by
+	chars int
+	// ratio, if positive, is an alternative threshold: the contents also
+	// count as pasted if they grew to more than this multiple of their
+	// previous length
+	ratio float64
+}
+
+// isPaste returns true if growing from before to after characters crosses
+// the threshold.
+func (t pasteThreshold) isPaste(before, after int) bool {
+	if after-before > t.chars {
+		return true
+	}
+
+	return t.ratio > 0 && before > 0 && float64(after) > float64(before)*t.ratio
+}
+
+// pasteCount returns the number of editor saves in the session that look like
+// code was pasted in. The first save has nothing to compare to, so it's never
+// counted.
+func (u *session) pasteCount(threshold pasteThreshold) int {
+	count := 0
+	previous := -1
+	for _, e := range u.events {
+		save, ok := e.(editorEvent)
+		if !ok {
+			continue
+		}
+
+		length := utf8.RuneCountInString(save.Content)
+		if previous >= 0 && threshold.isPaste(previous, length) {
+			count++
+		}
+		previous = length
+	}
+
+	return count
+}
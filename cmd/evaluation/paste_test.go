@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestIsPaste(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold pasteThreshold
+		before    int
+		after     int
+		want      bool
+	}{
+		// Growing by exactly chars isn't enough
+		{"at the chars threshold", pasteThreshold{chars: 200}, 50, 250, false},
+		{"over the chars threshold", pasteThreshold{chars: 200}, 50, 251, true},
+		{"from empty", pasteThreshold{chars: 200}, 0, 201, true},
+		{"shrinking", pasteThreshold{chars: 200}, 500, 0, false},
+		// Growing to exactly ratio times the length isn't enough either
+		{"at the ratio threshold", pasteThreshold{chars: 200, ratio: 3}, 20, 60, false},
+		{"over the ratio threshold", pasteThreshold{chars: 200, ratio: 3}, 20, 61, true},
+		{"ratio from empty", pasteThreshold{chars: 200, ratio: 3}, 0, 5, false},
+		{"ratio disabled", pasteThreshold{chars: 200}, 20, 100, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.threshold.isPaste(test.before, test.after); got != test.want {
+				t.Errorf("isPaste(%v, %v) = %v, want %v", test.before, test.after, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPasteCount(t *testing.T) {
+	threshold := pasteThreshold{chars: 10}
+	saves := func(contents ...string) session {
+		sess := session{uid: "player"}
+		for i, content := range contents {
+			sess.events = append(sess.events,
+				editorEvent(datatypes.EditorContent{UID: "player", Timestamp: int64(i * 1000), Content: content}))
+		}
+		return sess
+	}
+
+	tests := []struct {
+		name string
+		sess session
+		want int
+	}{
+		// The first save has nothing before it, however large it is
+		{"first save", saves(strings.Repeat("x", 1000)), 0},
+		{"first save then typing", saves(strings.Repeat("x", 1000), strings.Repeat("x", 1005)), 0},
+		{"at the threshold", saves("", strings.Repeat("x", 10)), 0},
+		{"over the threshold", saves("", strings.Repeat("x", 11)), 1},
+		// Lengths are counted in characters rather than bytes
+		{"multi-byte characters", saves("", strings.Repeat("é", 10)), 0},
+		{"each paste", saves("", strings.Repeat("x", 20), "x", strings.Repeat("x", 40)), 2},
+		{"no saves", session{uid: "player", events: commandEvents("player", "(fire)")}, 0},
+		{
+			"other events in between",
+			session{uid: "player", events: []event{
+				editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 0}),
+				replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: strings.Repeat("x", 100)}),
+				editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 2000, Content: strings.Repeat("x", 50)}),
+			}},
+			1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.sess.pasteCount(threshold); got != test.want {
+				t.Errorf("pasteCount() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
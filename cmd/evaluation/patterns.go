@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// errPatternConfig is an entry in an error patterns file.
+type errPatternConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// canonicalErrPatternName returns the current name of an error pattern,
+// logging a deprecation warning if the given name is an old one.
+func canonicalErrPatternName(name string) string {
+	canonical := datatypes.CanonicalErrorType(name)
+	if canonical != name {
+		log.Printf("Error pattern name %q is deprecated, use %q instead", name, canonical)
+	}
+
+	return canonical
+}
+
+// loadErrPatterns reads error patterns from a JSON file containing an array of
+// objects with "name" and "pattern" fields. Like errPatterns, the patterns are
+// in priority order. Every pattern is compiled up front so that a bad entry
+// is reported before any analysis is done.
+func loadErrPatterns(path string) ([]errPattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var configs []errPatternConfig
+	if err := json.NewDecoder(file).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("decoding %v: %v", path, err)
+	}
+
+	patterns := make([]errPattern, len(configs))
+	seen := make(map[string]bool)
+	for i, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("pattern %v has no name", i)
+		}
+		config.Name = canonicalErrPatternName(config.Name)
+		if config.Name == unclassifiedErrorType {
+			return nil, fmt.Errorf("pattern name %q is reserved", unclassifiedErrorType)
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("pattern %q is defined more than once", config.Name)
+		}
+		seen[config.Name] = true
+
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", config.Name, err)
+		}
+
+		patterns[i] = errPattern{config.Name, pattern}
+	}
+
+	return patterns, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the standard logger for the duration of a test, and
+// returns what was logged.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+func TestCanonicalErrPatternName(t *testing.T) {
+	tests := []struct {
+		name           string
+		want           string
+		wantDeprecated bool
+	}{
+		{"ArgumentMustBeOfType", "ArgumentMustBeOfType", false},
+		{"ArugmentMustBeOfType", "ArgumentMustBeOfType", true},
+		{"TooManyArguments", "TooManyArguments", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			if got := canonicalErrPatternName(test.name); got != test.want {
+				t.Errorf("canonicalErrPatternName(%q) = %q, want %q", test.name, got, test.want)
+			}
+			if deprecated := strings.Contains(logged.String(), "deprecated"); deprecated != test.wantDeprecated {
+				t.Errorf("logged %q, want a deprecation warning: %v", logged.String(), test.wantDeprecated)
+			}
+		})
+	}
+}
+
+func TestErrPatternSpellings(t *testing.T) {
+	captureLog(t)
+
+	// Both spellings find the same pattern, which classifies errors under
+	// the new one
+	oldPattern, newPattern := findErrPattern("ArugmentMustBeOfType"), findErrPattern("ArgumentMustBeOfType")
+	if oldPattern == nil || oldPattern != newPattern {
+		t.Fatalf("findErrPattern() = %v for the old spelling and %v for the new one, want the same pattern", oldPattern, newPattern)
+	}
+	if name, _ := classifyError("Argument 1 must be of type number, got string"); name != "ArgumentMustBeOfType" {
+		t.Errorf("classifyError() = %q, want ArgumentMustBeOfType", name)
+	}
+}
+
+func TestLoadErrPatternsOldName(t *testing.T) {
+	logged := captureLog(t)
+
+	path := filepath.Join(t.TempDir(), "patterns.json")
+	config := `[{"name": "ArugmentMustBeOfType", "pattern": "Argument (\\S+) must be of type"}]`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patterns, err := loadErrPatterns(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 1 || patterns[0].name != "ArgumentMustBeOfType" {
+		t.Errorf("loadErrPatterns() = %+v, want the pattern under its new name", patterns)
+	}
+	if !strings.Contains(logged.String(), "deprecated") {
+		t.Errorf("logged %q, want a deprecation warning", logged.String())
+	}
+
+	// The old and new names are the same pattern, so both can't be defined
+	config = `[{"name": "ArugmentMustBeOfType", "pattern": "a"}, {"name": "ArgumentMustBeOfType", "pattern": "b"}]`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadErrPatterns(path); err == nil {
+		t.Error("loading both spellings succeeded, want an error")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "context"
+
+// The default and limit of the -concurrency flag.
+const (
+	defaultConcurrency = 4
+	// maxConcurrency is the most UIDs whose sessions are built at once. Any
+	// more and Datastore starts throttling the queries
+	maxConcurrency = 16
+)
+
+// sessionResult is the outcome of building a UID's session.
+type sessionResult struct {
+	sess session
+	err  error
+}
+
+// forEachSession builds the session of each UID with up to concurrency
+// workers, and calls fn with each one in the order of uids. At most
+// concurrency sessions are held in memory at a time, including ones that are
+// done but waiting for an earlier UID. The first error from building a
+// session or from fn stops the rest and is returned.
+func forEachSession(ctx context.Context, client store, filter queryFilter, uids []string, concurrency int, fn func(index int, sess session) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each result channel is buffered so that workers never block, even if
+	// fn fails and nothing is left to receive from them
+	results := make([]chan sessionResult, len(uids))
+	for i := range results {
+		results[i] = make(chan sessionResult, 1)
+	}
+
+	slots := make(chan struct{}, concurrency)
+	go func() {
+		for i, uid := range uids {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(i int, uid string) {
+				sess, err := newSession(ctx, client, filter, uid)
+				results[i] <- sessionResult{sess, err}
+			}(i, uid)
+		}
+	}()
+
+	for i := range uids {
+		result := <-results[i]
+		<-slots
+		if result.err != nil {
+			return result.err
+		}
+		if err := fn(i, result.sess); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// slowingStore is a fakeStore whose queries get faster the more are run, so
+// that sessions built earlier finish later.
+type slowingStore struct {
+	*fakeStore
+
+	mutex sync.Mutex
+	// remaining is how many milliseconds the next query takes
+	remaining int
+}
+
+func (s *slowingStore) GetAll(ctx context.Context, query *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	s.mutex.Lock()
+	delay := time.Duration(s.remaining) * time.Millisecond
+	if s.remaining > 0 {
+		s.remaining--
+	}
+	s.mutex.Unlock()
+
+	time.Sleep(delay)
+	return s.fakeStore.GetAll(ctx, query, dst)
+}
+
+func TestForEachSessionOrder(t *testing.T) {
+	var uids []string
+	client := &slowingStore{fakeStore: newFakeStore(), remaining: 30}
+	for i := 0; i < 10; i++ {
+		uid := fmt.Sprintf("uid-%v", i)
+		uids = append(uids, uid)
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: uid, Timestamp: int64(i)})
+	}
+
+	var got []string
+	err := forEachSession(context.Background(), client, queryFilter{}, uids, 4, func(index int, sess session) error {
+		if sess.uid != uids[index] {
+			t.Errorf("session %v is of %v, want %v", index, sess.uid, uids[index])
+		}
+		if len(sess.events) != 1 {
+			t.Errorf("session of %v has %v events, want 1", sess.uid, len(sess.events))
+		}
+		got = append(got, sess.uid)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(got, ",") != strings.Join(uids, ",") {
+		t.Errorf("sessions were in the order %v, want %v", got, uids)
+	}
+}
+
+func TestForEachSessionError(t *testing.T) {
+	client := newFakeStore()
+	client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: "a"}, datatypes.REPLCommand{UID: "b"})
+	errFailed := errors.New("failed")
+
+	calls := 0
+	err := forEachSession(context.Background(), client, queryFilter{}, []string{"a", "b", "c"}, 2, func(index int, sess session) error {
+		calls++
+		return errFailed
+	})
+
+	if err != errFailed {
+		t.Errorf("forEachSession() = %v, want %v", err, errFailed)
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %v times, want it to stop after the error", calls)
+	}
+}
+
+func BenchmarkForEachSession(b *testing.B) {
+	client := newFakeStore()
+	var uids []string
+	for i := 0; i < 100; i++ {
+		uid := fmt.Sprintf("uid-%03d", i)
+		uids = append(uids, uid)
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: uid})
+	}
+	client.latency = time.Millisecond
+
+	for _, concurrency := range []int{1, defaultConcurrency, maxConcurrency} {
+		b.Run(fmt.Sprintf("concurrency %v", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := forEachSession(context.Background(), client, queryFilter{}, uids, concurrency, func(int, session) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is the least time between two progress updates.
+const progressInterval = 2 * time.Second
+
+// progressReporter writes updates on how many UIDs have been processed. A nil
+// progressReporter reports nothing.
+type progressReporter struct {
+	w     io.Writer
+	total int
+	// last is when the last update was written
+	last time.Time
+}
+
+func newProgressReporter(w io.Writer, total int) *progressReporter {
+	return &progressReporter{w: w, total: total}
+}
+
+// update reports that done UIDs have been processed. Updates are throttled
+// to one per progressInterval, except for the final one.
+func (p *progressReporter) update(done int) {
+	if p == nil {
+		return
+	}
+
+	current := time.Now()
+	if done < p.total && current.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = current
+
+	fmt.Fprintln(p.w, formatProgress(done, p.total))
+}
+
+// formatProgress describes how many of the total UIDs have been processed.
+func formatProgress(done, total int) string {
+	percent := 100.0
+	if total > 0 {
+		percent = float64(done) / float64(total) * 100
+	}
+
+	return fmt.Sprintf("processed %v/%v UIDs (%.1f%%)", done, total, percent)
+}
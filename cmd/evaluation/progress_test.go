@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		done  int
+		total int
+		want  string
+	}{
+		{0, 3000, "processed 0/3000 UIDs (0.0%)"},
+		{120, 3000, "processed 120/3000 UIDs (4.0%)"},
+		{1, 3, "processed 1/3 UIDs (33.3%)"},
+		{2, 3, "processed 2/3 UIDs (66.7%)"},
+		{3000, 3000, "processed 3000/3000 UIDs (100.0%)"},
+		// There's nothing left to do without any UIDs
+		{0, 0, "processed 0/0 UIDs (100.0%)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := formatProgress(test.done, test.total); got != test.want {
+				t.Errorf("formatProgress(%v, %v) = %q, want %q", test.done, test.total, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProgressReporterThrottle(t *testing.T) {
+	var out strings.Builder
+	progress := newProgressReporter(&out, 10)
+
+	// The first update is written, and the ones right after it aren't
+	progress.update(1)
+	progress.update(2)
+	progress.update(3)
+	// Once the interval has passed, the next one is
+	progress.last = progress.last.Add(-progressInterval)
+	progress.update(4)
+	progress.update(5)
+	// The final update is always written
+	progress.update(10)
+
+	want := "processed 1/10 UIDs (10.0%)\n" +
+		"processed 4/10 UIDs (40.0%)\n" +
+		"processed 10/10 UIDs (100.0%)\n"
+	if out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestProgressReporterNil(t *testing.T) {
+	// Without -verbose there's no reporter, and updating it does nothing
+	var progress *progressReporter
+	progress.update(1)
+}
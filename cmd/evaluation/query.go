@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// queryPageSize is the number of entities fetched from Datastore per page
+// when iterating over a query.
+const queryPageSize = 1000
+
+// runPaged runs the query one page at a time, resuming each page from the
+// cursor where the last one ended, so that results never have to be held
+// in memory all at once. Each entity is loaded into dst, which must be a
+// pointer to a struct, and then fn is called. dst is reset to its zero value
+// before each entity is loaded. Pages that fail with a transient error are
+// retried, skipping the entities fn has already been called with.
+func runPaged(ctx context.Context, client store, query *datastore.Query, dst interface{}, fn func()) error {
+	dstValue := reflect.ValueOf(dst).Elem()
+	zero := reflect.Zero(dstValue.Type())
+
+	page := query.Limit(queryPageSize)
+	for {
+		count := 0
+		var cursor datastore.Cursor
+
+		err := withRetry(ctx, func() error {
+			it := client.Run(ctx, page)
+
+			for seen := 0; ; seen++ {
+				dstValue.Set(zero)
+				if _, err := it.Next(dst); err == iterator.Done {
+					break
+				} else if err != nil {
+					return err
+				}
+
+				if seen < count {
+					// Already handled before this attempt was retried
+					continue
+				}
+				fn()
+				count++
+			}
+
+			var err error
+			cursor, err = it.Cursor()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if count < queryPageSize {
+			return nil
+		}
+
+		page = query.Limit(queryPageSize).Start(cursor)
+	}
+}
+
+// anyMatch returns true if at least one entity matches the query. Only a
+// single key is read, where counting the matches would read the key of every
+// one of them.
+func anyMatch(ctx context.Context, client store, query *datastore.Query) (bool, error) {
+	var keys []*datastore.Key
+	err := withRetry(ctx, func() error {
+		var err error
+		keys, err = client.GetAll(ctx, query.KeysOnly().Limit(1), nil)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(keys) > 0, nil
+}
+
+// runDistinctProjection runs a distinct projection of the query on a single
+// string property and calls fn with each value. Projections are served from
+// an index, so full entities are never read.
+func runDistinctProjection(ctx context.Context, client store, query *datastore.Query, property string, fn func(value string)) error {
+	query = query.Project(property).Distinct()
+
+	var props datastore.PropertyList
+	return runPaged(ctx, client, query, &props, func() {
+		for _, prop := range props {
+			if value, ok := prop.Value.(string); ok && prop.Name == property {
+				fn(value)
+			}
+		}
+	})
+}
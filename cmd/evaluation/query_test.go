@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestRunPaged(t *testing.T) {
+	client := newFakeStore()
+	total := 2*queryPageSize + queryPageSize/2
+	for i := 0; i < total; i++ {
+		client.add(datatypes.REPLCommandKind, datatypes.REPLCommand{UID: "a", Timestamp: int64(i)})
+	}
+
+	var command datatypes.REPLCommand
+	var timestamps []int64
+	err := runPaged(context.Background(), client, datastore.NewQuery(datatypes.REPLCommandKind), &command, func() {
+		timestamps = append(timestamps, command.Timestamp)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(timestamps) != total {
+		t.Fatalf("got %v entities, want %v", len(timestamps), total)
+	}
+	for i, timestamp := range timestamps {
+		if timestamp != int64(i) {
+			t.Fatalf("entity %v has timestamp %v, pages overlap or skip entities", i, timestamp)
+		}
+	}
+	if client.runs != 3 {
+		t.Errorf("ran %v queries, want one per page", client.runs)
+	}
+}
+
+func TestRunDistinctProjection(t *testing.T) {
+	client := newFakeStore()
+	for _, uid := range []string{"b", "a", "b", "c", "a"} {
+		client.add(datatypes.EditorContentKind, datatypes.EditorContent{UID: uid})
+	}
+
+	var uids []string
+	err := runDistinctProjection(context.Background(), client, datastore.NewQuery(datatypes.EditorContentKind), "UID", func(uid string) {
+		uids = append(uids, uid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(uids), 3; got != want {
+		t.Errorf("got UIDs %v, want %v distinct ones", uids, want)
+	}
+}
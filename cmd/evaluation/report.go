@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Report holds the dataset-wide results of an evaluation run. Its fields make
+// up the schema of the JSON output format.
+type Report struct {
+	// ErrorTypeCounts is the number of errors of each type in errPatterns,
+	// with errors that matched none counted as "Unclassified".
+	ErrorTypeCounts map[string]int `json:"errorTypeCounts"`
+	// ErrorTypesPerUID is the distribution of the number of distinct error
+	// types each UID hit, including UIDs that hit none, or nil if there were
+	// no UIDs.
+	ErrorTypesPerUID *Distribution `json:"errorTypesPerUid"`
+	// ErrorTypeCountsByVersion breaks down ErrorTypeCounts by the game
+	// version that reported each error.
+	ErrorTypeCountsByVersion map[string]map[string]int `json:"errorTypeCountsByVersion"`
+	// ErrorTypeCountsByPlatform breaks down ErrorTypeCounts by the platform
+	// that reported each error.
+	ErrorTypeCountsByPlatform map[string]map[string]int `json:"errorTypeCountsByPlatform"`
+	// UnmatchedErrors ranks the normalized descriptions of errors that matched
+	// no error pattern, as candidates for new patterns.
+	UnmatchedErrors []RankedValue `json:"unmatchedErrors"`
+	// UnclassifiedExamples are the descriptions of a few errors that matched
+	// no error pattern, to guide adding new ones.
+	UnclassifiedExamples []string `json:"unclassifiedExamples"`
+	// VariablesWithNoValue ranks the variables that caused VariableHasNoValue
+	// errors by how often they did.
+	VariablesWithNoValue []RankedValue `json:"variablesWithNoValue"`
+	// VariablesWithNoValueTotal is the number of VariableHasNoValue errors
+	// across every variable, including those left out of the ranking.
+	VariablesWithNoValueTotal int `json:"variablesWithNoValueTotal"`
+	// UnknownCallables ranks the callables that caused UnknownCallable errors
+	// by how often they did.
+	UnknownCallables []RankedValue `json:"unknownCallables"`
+	// UnknownCallablesTotal is the number of UnknownCallable errors across
+	// every callable, including those left out of the ranking.
+	UnknownCallablesTotal int `json:"unknownCallablesTotal"`
+	// Captures ranks the values captured by the error pattern chosen with
+	// -captures, or is nil if none was.
+	Captures *CaptureRanking `json:"captures,omitempty"`
+	// TopCommands ranks the most frequently run REPL commands, after
+	// normalization.
+	TopCommands []RankedValue `json:"topCommands"`
+	// CalledFunctions ranks the functions called by REPL commands, including
+	// nested calls, by how often they were.
+	CalledFunctions []RankedValue `json:"calledFunctions"`
+	// UnparsableCommands is the number of REPL commands that couldn't be
+	// parsed, and so aren't counted in CalledFunctions.
+	UnparsableCommands int `json:"unparsableCommands"`
+	// CommandCategories is the number of REPL commands that targeted each
+	// game subsystem.
+	CommandCategories map[string]int `json:"commandCategories"`
+	// CommandLengths is the distribution of the length in characters of REPL
+	// commands, or nil if there were none.
+	CommandLengths *Distribution `json:"commandLengths"`
+	// CommandLengthHistogram counts REPL commands by their length.
+	CommandLengthHistogram []HistogramBucket `json:"commandLengthHistogram"`
+	// EditorUseCount is the number of UIDs that used the editor.
+	EditorUseCount int `json:"editorUseCount"`
+	// UIDCount is the total number of UIDs in the dataset.
+	UIDCount int `json:"uidCount"`
+	// EditorErrorRates compares the errors hit by sessions that used the
+	// editor against those that didn't.
+	EditorErrorRates EditorErrorRates `json:"editorErrorRates"`
+	// SessionDuration is the distribution of session durations in
+	// milliseconds, or nil if there were no sessions.
+	SessionDuration *Distribution `json:"sessionDuration"`
+	// CommandsPerSession is the distribution of the number of REPL commands
+	// run in each session, including sessions that ran none, or nil if there
+	// were no sessions.
+	CommandsPerSession *Distribution `json:"commandsPerSession"`
+	// ErrorsPerSessionHistogram counts sessions by how many errors they had.
+	ErrorsPerSessionHistogram []HistogramBucket `json:"errorsPerSessionHistogram"`
+	// NoCommandSessions are the sessions that had events but never ran a
+	// REPL command.
+	NoCommandSessions []SessionRef `json:"noCommandSessions"`
+	// FirstCommands ranks the normalized first REPL command of each session
+	// that ran one.
+	FirstCommands []RankedValue `json:"firstCommands"`
+	// DeadEndCommands ranks the normalized last REPL command of each session
+	// that ended in an error.
+	DeadEndCommands []RankedValue `json:"deadEndCommands"`
+	// ErrorEndingsWithoutCommand is the number of sessions that ended in an
+	// error without having run a REPL command first.
+	ErrorEndingsWithoutCommand int `json:"errorEndingsWithoutCommand"`
+	// ErroringCommands ranks the normalized REPL commands most often
+	// immediately followed by an error.
+	ErroringCommands []RankedValue `json:"erroringCommands"`
+	// SilentFailures ranks the normalized REPL commands whose result looked
+	// like an error even though no error followed them. Only clients that
+	// send results can have these.
+	SilentFailures []RankedValue `json:"silentFailures"`
+	// SilentFailureCount is the total number of such commands.
+	SilentFailureCount int `json:"silentFailureCount"`
+	// SafeCommands ranks the normalized REPL commands that are run often and
+	// rarely followed by an error, by how many times they succeeded.
+	SafeCommands []RankedValue `json:"safeCommands"`
+	// Friction combines the top unknown callables, variables with no value
+	// and erroring commands into a single ranking.
+	Friction []FrictionPoint `json:"friction"`
+	// ErrorRecovery is whether players recovered after each command that
+	// caused an error.
+	ErrorRecovery ErrorRecovery `json:"errorRecovery"`
+	// AverageSuccessRate is the mean of each session's success rate, or nil
+	// if no session ran a command.
+	AverageSuccessRate *float64 `json:"averageSuccessRate"`
+	// TimeToError is the distribution of milliseconds between a REPL command
+	// and the error it caused, or nil if there were no such errors.
+	TimeToError *Distribution `json:"timeToError"`
+	// RetryStreakCount is the total number of runs of identical consecutive
+	// REPL commands across all sessions.
+	RetryStreakCount int `json:"retryStreakCount"`
+	// LongestRetryStreak is the longest run of identical consecutive REPL
+	// commands, or nil if there were none. Ties go to the streak found first.
+	LongestRetryStreak *RetryStreak `json:"longestRetryStreak"`
+	// Funnel is the number of sessions that reached each stage of the game's
+	// progression, in order. A session that reached a stage is counted in
+	// every stage before it too.
+	Funnel []FunnelStage `json:"funnel"`
+	// EditorCadence is the distribution of the mean milliseconds between
+	// editor saves of each session that saved at least twice, or nil if none
+	// did.
+	EditorCadence *Distribution `json:"editorCadence"`
+	// EditorIdleGaps is the total number of gaps between editor saves that
+	// were long enough to count as the player being idle.
+	EditorIdleGaps int `json:"editorIdleGaps"`
+	// EditorRatio is the distribution of editor saves per REPL command of each
+	// session that ran at least one command, or nil if none did.
+	EditorRatio *Distribution `json:"editorRatio"`
+	// VariablesPerSession is the distribution of the number of distinct
+	// variables each session used, or nil if there were no sessions.
+	VariablesPerSession *Distribution `json:"variablesPerSession"`
+	// EditorOnlySessions is the number of sessions that saved the editor but
+	// ran no commands, whose ratio would be infinite. They're left out of
+	// EditorRatio.
+	EditorOnlySessions int `json:"editorOnlySessions"`
+	// UnusedEditorSessions is the number of sessions that saved the editor
+	// but never seemed to run what they saved. See session.ranEditorCode for
+	// how that's judged.
+	UnusedEditorSessions int `json:"unusedEditorSessions"`
+	// UnusedEditorExamples are some of those sessions.
+	UnusedEditorExamples []SessionRef `json:"unusedEditorExamples"`
+	// PasteCount is the total number of editor saves across all sessions
+	// where the contents grew enough that code was likely pasted in.
+	PasteCount int `json:"pasteCount"`
+	// ActivityByHour is the number of events that happened in each hour of the
+	// day, in ActivityTimeZone.
+	ActivityByHour [24]int `json:"activityByHour"`
+	// ActivityTimeZone is the name of the time zone ActivityByHour uses.
+	ActivityTimeZone string `json:"activityTimeZone"`
+	// LinesAdded and LinesRemoved are the total number of lines changed in
+	// the editor between consecutive saves, across all sessions.
+	LinesAdded   int `json:"linesAdded"`
+	LinesRemoved int `json:"linesRemoved"`
+
+	// SkewIncidents is the number of events whose timestamp was well before
+	// that of an event received earlier from the same UID, as a gauge of how
+	// much clock skew and offline buffering affect the data.
+	SkewIncidents int `json:"skewIncidents"`
+	// WorstSkewUID is the UID with the most skew incidents and how many it
+	// had, or nil if there were none. Ties go to the UID found first.
+	WorstSkewUID *RankedValue `json:"worstSkewUid"`
+
+	// Sessions holds the results for each individual session.
+	Sessions []SessionReport `json:"sessions"`
+}
+
+// SessionReport holds the results for a single session.
+type SessionReport struct {
+	// UID is the UID the session belongs to.
+	UID string `json:"uid"`
+	// SessionID is the ID the client gave the session, or empty if its
+	// boundaries were inferred from gaps between events.
+	SessionID string `json:"sessionId,omitempty"`
+	// Index is the position of the session among the UID's sessions.
+	Index int `json:"index"`
+	// Duration is the number of milliseconds between the session's first and
+	// last event.
+	Duration int64 `json:"duration"`
+	// CommandCount is the number of REPL commands run in the session.
+	CommandCount int `json:"commandCount"`
+	// SuccessRate is the fraction of the session's commands that didn't
+	// cause an error, or nil if the session ran no commands.
+	SuccessRate *float64 `json:"successRate"`
+	// ErrorCount is the number of errors in the session.
+	ErrorCount int `json:"errorCount"`
+	// UsedEditor is true if the session saved the editor at least once.
+	UsedEditor bool `json:"usedEditor"`
+	// RetryStreakCount is the number of runs of identical consecutive REPL
+	// commands in the session.
+	RetryStreakCount int `json:"retryStreakCount"`
+	// EditorCadence is the mean milliseconds between the session's editor
+	// saves, or nil if it saved fewer than two times.
+	EditorCadence *float64 `json:"editorCadence"`
+	// EditorIdleGaps is the number of gaps between the session's editor
+	// saves that were long enough to count as the player being idle.
+	EditorIdleGaps int `json:"editorIdleGaps"`
+	// DistinctVariables is the number of distinct variable names the
+	// session's commands referenced or its VariableHasNoValue errors named.
+	DistinctVariables int `json:"distinctVariables"`
+	// EditorSaves is the number of times the session saved the editor.
+	EditorSaves int `json:"editorSaves"`
+	// EditorRatio is the number of editor saves per REPL command in the
+	// session, or nil if it ran no commands. Sessions with saves but no
+	// commands have EditorSaves set and a nil EditorRatio.
+	EditorRatio *float64 `json:"editorRatio"`
+	// PasteCount is the number of editor saves in the session where the
+	// contents grew enough that code was likely pasted in.
+	PasteCount int `json:"pasteCount"`
+	// FurthestStage is the furthest stage of the funnel the session reached,
+	// or empty if it reached none.
+	FurthestStage string `json:"furthestStage,omitempty"`
+	// LinesAdded and LinesRemoved are the number of lines changed in the
+	// editor between consecutive saves.
+	LinesAdded   int `json:"linesAdded"`
+	LinesRemoved int `json:"linesRemoved"`
+}
+
+// EditorErrorRates compares the average number of errors per session between
+// sessions that used the editor and sessions that didn't.
+type EditorErrorRates struct {
+	EditorSessions    int `json:"editorSessions"`
+	NonEditorSessions int `json:"nonEditorSessions"`
+	// EditorErrorsPerSession is the mean error count of sessions that used
+	// the editor, or nil if there were none.
+	EditorErrorsPerSession *float64 `json:"editorErrorsPerSession"`
+	// NonEditorErrorsPerSession is the mean error count of sessions that
+	// didn't use the editor, or nil if there were none.
+	NonEditorErrorsPerSession *float64 `json:"nonEditorErrorsPerSession"`
+	// Delta is EditorErrorsPerSession minus NonEditorErrorsPerSession, or nil
+	// if either is nil.
+	Delta *float64 `json:"delta"`
+}
+
+// CaptureRanking ranks the values an error pattern's capture groups matched.
+type CaptureRanking struct {
+	// Pattern is the name of the error pattern.
+	Pattern string `json:"pattern"`
+	// Values are the captured values, with the groups of patterns that have
+	// more than one joined by commas.
+	Values []RankedValue `json:"values"`
+}
+
+// ErrorRecovery counts what players did after a REPL command that caused an
+// error.
+type ErrorRecovery struct {
+	// Recovered is the number of erroring commands whose next command in the
+	// session succeeded.
+	Recovered int `json:"recovered"`
+	// KeptErroring is the number whose next command caused an error too.
+	KeptErroring int `json:"keptErroring"`
+	// SessionEnded is the number that were the last command of their
+	// session.
+	SessionEnded int `json:"sessionEnded"`
+	// RecoveryRate is the fraction of erroring commands that were recovered
+	// from, or nil if no commands caused an error.
+	RecoveryRate *float64 `json:"recoveryRate"`
+}
+
+// FunnelStage is the number of sessions that reached a stage of the funnel.
+type FunnelStage struct {
+	Stage    string `json:"stage"`
+	Sessions int    `json:"sessions"`
+}
+
+// SessionRef identifies a session.
+type SessionRef struct {
+	UID       string `json:"uid"`
+	SessionID string `json:"sessionId,omitempty"`
+	Index     int    `json:"index"`
+}
+
+// String returns the session ID if there is one, or otherwise the UID and
+// the session's index.
+func (s SessionRef) String() string {
+	if s.SessionID != "" {
+		return s.SessionID
+	}
+
+	return fmt.Sprintf("%v#%v", s.UID, s.Index)
+}
+
+// RetryStreak is a run of identical consecutive REPL commands.
+type RetryStreak struct {
+	UID     string `json:"uid"`
+	Command string `json:"command"`
+	Length  int    `json:"length"`
+}
+
+// RankedValue is an entry in a ranking of values by how often they occur.
+type RankedValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// rank returns the values in counts sorted by descending count, with ties
+// broken alphabetically. If n is positive, only the first n are returned.
+func rank(counts map[string]int, n int) []RankedValue {
+	var ranked []RankedValue
+	for value, count := range counts {
+		ranked = append(ranked, RankedValue{
+			Value: value,
+			Count: count})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	return ranked
+}
+
+// sumCounts returns the total of the counts.
+func sumCounts(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+
+	return total
+}
+
+// Categories of friction points.
+const (
+	unknownCallableFriction = "unknownCallable"
+	variableNoValueFriction = "variableHasNoValue"
+	erroringCommandFriction = "erroringCommand"
+)
+
+// FrictionPoint is something players often struggle with.
+type FrictionPoint struct {
+	Label    string `json:"label"`
+	Count    int    `json:"count"`
+	Category string `json:"category"`
+}
+
+// rankFriction combines rankings of different categories of friction into
+// one, sorted by descending count, with ties broken by category and then
+// label. If n is positive, only the first n are returned.
+func rankFriction(rankings map[string][]RankedValue, n int) []FrictionPoint {
+	var points []FrictionPoint
+	for category, ranked := range rankings {
+		for _, value := range ranked {
+			points = append(points, FrictionPoint{
+				Label:    value.Value,
+				Count:    value.Count,
+				Category: category,
+			})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Count != points[j].Count {
+			return points[i].Count > points[j].Count
+		}
+		if points[i].Category != points[j].Category {
+			return points[i].Category < points[j].Category
+		}
+		return points[i].Label < points[j].Label
+	})
+
+	if n > 0 && len(points) > n {
+		points = points[:n]
+	}
+
+	return points
+}
+
+// HistogramBucket is the number of values in a range.
+type HistogramBucket struct {
+	// Min is the smallest value in the bucket.
+	Min int `json:"min"`
+	// Max is the largest value in the bucket, or nil if the bucket has no
+	// upper bound.
+	Max   *int `json:"max"`
+	Count int  `json:"count"`
+}
+
+// String returns the range of values in the bucket, like "3-5" or "11+".
+func (b HistogramBucket) String() string {
+	switch {
+	case b.Max == nil:
+		return fmt.Sprintf("%v+", b.Min)
+	case *b.Max == b.Min:
+		return fmt.Sprint(b.Min)
+	default:
+		return fmt.Sprintf("%v-%v", b.Min, *b.Max)
+	}
+}
+
+// newHistogram counts the values into buckets. The bounds are the increasing
+// lower bounds of each bucket, and values below the first bound aren't
+// counted. The last bucket has no upper bound.
+func newHistogram(values []int, bounds []int) []HistogramBucket {
+	buckets := emptyHistogram(bounds)
+	for _, value := range values {
+		addToHistogram(buckets, bounds, value, 1)
+	}
+
+	return buckets
+}
+
+// newCountHistogram is like newHistogram, but for values given as the number
+// of times each occurred.
+func newCountHistogram(counts map[int]int, bounds []int) []HistogramBucket {
+	buckets := emptyHistogram(bounds)
+	for value, count := range counts {
+		addToHistogram(buckets, bounds, value, count)
+	}
+
+	return buckets
+}
+
+// emptyHistogram returns the buckets of a histogram with the given bounds and
+// nothing counted.
+func emptyHistogram(bounds []int) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(bounds))
+	for i, bound := range bounds {
+		buckets[i].Min = bound
+		if i+1 < len(bounds) {
+			max := bounds[i+1] - 1
+			buckets[i].Max = &max
+		}
+	}
+
+	return buckets
+}
+
+// addToHistogram counts the value count times in the bucket it belongs to.
+func addToHistogram(buckets []HistogramBucket, bounds []int, value, count int) {
+	// Find the last bucket whose lower bound is at most the value
+	i := sort.Search(len(bounds), func(i int) bool {
+		return bounds[i] > value
+	}) - 1
+	if i >= 0 {
+		buckets[i].Count += count
+	}
+}
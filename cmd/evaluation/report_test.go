@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNewHistogramBoundaries(t *testing.T) {
+	bounds, err := parseBounds(defaultErrorsPerSessionBounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each value is at the edge of a bucket: 0, 1-2, 3-5, 6-10, 11+
+	values := []int{0, 1, 2, 3, 5, 6, 10, 11, 1000}
+	want := []int{1, 2, 2, 2, 2}
+
+	buckets := newHistogram(values, bounds)
+	if len(buckets) != len(want) {
+		t.Fatalf("got %v buckets, want %v", len(buckets), len(want))
+	}
+	for i, bucket := range buckets {
+		if bucket.Count != want[i] {
+			t.Errorf("bucket %v (min %v): count = %v, want %v", i, bucket.Min, bucket.Count, want[i])
+		}
+	}
+
+	if buckets[len(buckets)-1].Max != nil {
+		t.Errorf("last bucket has max %v, want none", *buckets[len(buckets)-1].Max)
+	}
+	if max := buckets[1].Max; max == nil || *max != 2 {
+		t.Errorf("bucket 1 max = %v, want 2", max)
+	}
+}
+
+func TestNewHistogramBelowFirstBound(t *testing.T) {
+	buckets := newHistogram([]int{0, 4, 5}, []int{5})
+	if buckets[0].Count != 1 {
+		t.Errorf("count = %v, want 1", buckets[0].Count)
+	}
+}
+
+func TestRankFriction(t *testing.T) {
+	rankings := map[string][]RankedValue{
+		unknownCallableFriction: {{"fly", 7}, {"launch", 3}, {"go", 3}},
+		variableNoValueFriction: {{"speed", 9}, {"heading", 3}},
+		erroringCommandFriction: {{"(help 1 2)", 7}, {"(fire)", 1}},
+	}
+
+	// Ties are broken by category and then label
+	want := []FrictionPoint{
+		{Label: "speed", Count: 9, Category: variableNoValueFriction},
+		{Label: "(help 1 2)", Count: 7, Category: erroringCommandFriction},
+		{Label: "fly", Count: 7, Category: unknownCallableFriction},
+		{Label: "go", Count: 3, Category: unknownCallableFriction},
+		{Label: "launch", Count: 3, Category: unknownCallableFriction},
+		{Label: "heading", Count: 3, Category: variableNoValueFriction},
+		{Label: "(fire)", Count: 1, Category: erroringCommandFriction},
+	}
+
+	tests := []struct {
+		n    int
+		want []FrictionPoint
+	}{
+		{0, want},
+		{len(want) + 1, want},
+		{4, want[:4]},
+		{1, want[:1]},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+			// The rankings are a map, so the order is checked over many runs
+			for i := 0; i < 100; i++ {
+				if got := rankFriction(rankings, test.n); !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("run %v: rankFriction() = %+v, want %+v", i, got, test.want)
+				}
+			}
+		})
+	}
+
+	if got := rankFriction(map[string][]RankedValue{}, 5); len(got) != 0 {
+		t.Errorf("with no rankings, rankFriction() = %+v", got)
+	}
+}
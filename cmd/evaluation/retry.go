@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultMaxReadAttempts is the default for maxReadAttempts.
+	defaultMaxReadAttempts = 5
+	// initialRetryDelay is how long to wait before the first retry. Each
+	// retry after that waits twice as long as the last, up to maxRetryDelay.
+	initialRetryDelay = 200 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// maxReadAttempts is the most times a Datastore read will be tried before
+// giving up.
+var maxReadAttempts = defaultMaxReadAttempts
+
+// sleep waits for the given duration or until the context is done. It's a
+// variable so that tests don't have to wait.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRetryable returns true if the error is one of the transient errors that
+// Datastore can return, which may not happen again if the request is retried.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted,
+		codes.ResourceExhausted, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// withRetry calls fn until it succeeds, returns an error that isn't
+// retryable, or has been tried maxReadAttempts times. Retries are delayed with
+// exponential backoff and jitter. The last error from fn is returned.
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := initialRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt >= maxReadAttempts {
+			return err
+		}
+
+		// Jitter keeps concurrent readers from retrying in lockstep
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		log.Printf("Datastore read failed on attempt %v, retrying in %v: %v", attempt, wait, err)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
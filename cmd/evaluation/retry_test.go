@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// flakyStore fails the first failures calls to GetAll of each kind with err,
+// then passes them on to the wrapped store. If kind is set, only queries
+// for that kind fail.
+type flakyStore struct {
+	*fakeStore
+	err      error
+	failures int
+	kind     string
+
+	mutex sync.Mutex
+	// attempts counts the calls to GetAll of each kind
+	attempts map[string]int
+}
+
+func newFlakyStore(store *fakeStore, err error, failures int) *flakyStore {
+	return &flakyStore{
+		fakeStore: store,
+		err:       err,
+		failures:  failures,
+		attempts:  make(map[string]int),
+	}
+}
+
+func (s *flakyStore) GetAll(ctx context.Context, query *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	s.mutex.Lock()
+	kind := queryKind(query)
+	s.attempts[kind]++
+	failed := s.attempts[kind] <= s.failures && (s.kind == "" || s.kind == kind)
+	s.mutex.Unlock()
+
+	if failed {
+		return nil, s.err
+	}
+	return s.fakeStore.GetAll(ctx, query, dst)
+}
+
+// useFakeSleep replaces sleep for the duration of a test with one that
+// returns immediately. The returned function lists the durations slept.
+func useFakeSleep(t *testing.T) func() []time.Duration {
+	var mutex sync.Mutex
+	var slept []time.Duration
+
+	realSleep := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		slept = append(slept, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = realSleep })
+
+	return func() []time.Duration {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]time.Duration(nil), slept...)
+	}
+}
+
+func TestNewSessionRetries(t *testing.T) {
+	useFakeSleep(t)
+
+	fake := newFakeStore()
+	fake.add(datatypes.REPLCommandKind, &datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire)"})
+	fake.add(datatypes.ErrorInstanceKind, &datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments"})
+	fake.add(datatypes.EditorContentKind, &datatypes.EditorContent{UID: "player", Timestamp: 3000, Content: "(fire)"})
+
+	// Each kind fails twice and then succeeds
+	client := newFlakyStore(fake, status.Error(codes.Unavailable, "try again"), 2)
+	sess, err := newSession(context.Background(), client, queryFilter{}, "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sess.events) != 3 {
+		t.Errorf("got %v events, want 3", len(sess.events))
+	}
+	for _, kind := range []string{datatypes.REPLCommandKind, datatypes.ErrorInstanceKind, datatypes.EditorContentKind} {
+		if client.attempts[kind] != 3 {
+			t.Errorf("%v was queried %v times, want 3", kind, client.attempts[kind])
+		}
+	}
+}
+
+func TestNewSessionGivesUp(t *testing.T) {
+	useFakeSleep(t)
+
+	fake := newFakeStore()
+	fake.add(datatypes.REPLCommandKind, &datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire)"})
+
+	// Only one kind fails, since the first kind to give up cancels the
+	// queries of the others
+	client := newFlakyStore(fake, status.Error(codes.Unavailable, "try again"), maxReadAttempts)
+	client.kind = datatypes.REPLCommandKind
+	if _, err := newSession(context.Background(), client, queryFilter{}, "player"); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want the last Unavailable error", err)
+	}
+	if attempts := client.attempts[datatypes.REPLCommandKind]; attempts != maxReadAttempts {
+		t.Errorf("queried %v times, want %v", attempts, maxReadAttempts)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	errPlain := errors.New("not a status")
+
+	tests := []struct {
+		name         string
+		err          error
+		failures     int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"success", nil, 0, false, 1},
+		{"unavailable twice", status.Error(codes.Unavailable, ""), 2, false, 3},
+		{"deadline exceeded twice", status.Error(codes.DeadlineExceeded, ""), 2, false, 3},
+		{"aborted twice", status.Error(codes.Aborted, ""), 2, false, 3},
+		{"resource exhausted twice", status.Error(codes.ResourceExhausted, ""), 2, false, 3},
+		{"internal twice", status.Error(codes.Internal, ""), 2, false, 3},
+		{"always unavailable", status.Error(codes.Unavailable, ""), 100, true, defaultMaxReadAttempts},
+		{"not found", status.Error(codes.NotFound, ""), 2, true, 1},
+		{"invalid argument", status.Error(codes.InvalidArgument, ""), 2, true, 1},
+		{"permission denied", status.Error(codes.PermissionDenied, ""), 2, true, 1},
+		{"failed precondition", status.Error(codes.FailedPrecondition, ""), 2, true, 1},
+		{"not a status", errPlain, 2, true, 1},
+		{"no such entity", datastore.ErrNoSuchEntity, 2, true, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slept := useFakeSleep(t)
+
+			attempts := 0
+			err := withRetry(context.Background(), func() error {
+				attempts++
+				if attempts <= test.failures {
+					return test.err
+				}
+				return nil
+			})
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, test.wantErr)
+			}
+			if err != nil && err != test.err {
+				t.Errorf("got error %v, want the last error %v", err, test.err)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("tried %v times, want %v", attempts, test.wantAttempts)
+			}
+			if waits := len(slept()); waits != attempts-1 {
+				t.Errorf("waited %v times between %v attempts", waits, attempts)
+			}
+		})
+	}
+}
+
+func TestWithRetryBackoff(t *testing.T) {
+	slept := useFakeSleep(t)
+
+	realAttempts := maxReadAttempts
+	maxReadAttempts = 10
+	defer func() { maxReadAttempts = realAttempts }()
+
+	withRetry(context.Background(), func() error {
+		return status.Error(codes.Unavailable, "")
+	})
+
+	// Each wait is jittered down to no less than half of the delay, which
+	// doubles up to maxRetryDelay
+	delay := initialRetryDelay
+	waits := slept()
+	if len(waits) != maxReadAttempts-1 {
+		t.Fatalf("waited %v times, want %v", len(waits), maxReadAttempts-1)
+	}
+	for i, wait := range waits {
+		if wait < delay/2 || wait > delay {
+			t.Errorf("wait %v is %v, want between %v and %v", i, wait, delay/2, delay)
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func TestWithRetryCanceled(t *testing.T) {
+	useFakeSleep(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := withRetry(ctx, func() error {
+		attempts++
+		return status.Error(codes.Unavailable, "")
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if attempts != 1 {
+		t.Errorf("tried %v times after being canceled, want 1", attempts)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// duration returns the number of milliseconds between the session's first and
+// last event. Sessions with fewer than two events have a duration of zero.
+func (u *session) duration() int64 {
+	if len(u.events) < 2 {
+		return 0
+	}
+
+	return u.events[len(u.events)-1].getTimestamp() - u.events[0].getTimestamp()
+}
+
+// commandCount returns the number of REPL commands run in the session.
+func (u *session) commandCount() int {
+	count := 0
+	for _, e := range u.events {
+		if _, ok := e.(replEvent); ok {
+			count++
+		}
+	}
+
+	return count
+}
+
+// errorCount returns the number of errors in the session.
+func (u *session) errorCount() int {
+	count := 0
+	for _, e := range u.events {
+		if _, ok := e.(errorEvent); ok {
+			count++
+		}
+	}
+
+	return count
+}
+
+// distinctErrorTypes returns the number of different error types in the
+// session, with errors that match no pattern counted as one more type.
+func (u *session) distinctErrorTypes() int {
+	types := make(map[string]bool)
+	for _, e := range u.events {
+		if err, ok := e.(errorEvent); ok {
+			name, ok := classifyError(err.Description)
+			if !ok {
+				name = unclassifiedErrorType
+			}
+			types[name] = true
+		}
+	}
+
+	return len(types)
+}
+
+// usedEditor returns true if the session saved the editor at least once.
+func (u *session) usedEditor() bool {
+	for _, e := range u.events {
+		if _, ok := e.(editorEvent); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// firstCommand returns the earliest REPL command run in the session, or false
+// if it ran none.
+func (u *session) firstCommand() (string, bool) {
+	for _, e := range u.events {
+		if cmd, ok := e.(replEvent); ok {
+			return cmd.Command, true
+		}
+	}
+
+	return "", false
+}
+
+// endedInError returns true if the session's last event is an error. If so,
+// cmd is the last REPL command run before it, or false if there was none.
+func (u *session) endedInError() (endedInError bool, cmd string, hasCmd bool) {
+	if len(u.events) == 0 {
+		return false, "", false
+	}
+	if _, ok := u.events[len(u.events)-1].(errorEvent); !ok {
+		return false, "", false
+	}
+
+	for i := len(u.events) - 2; i >= 0; i-- {
+		if cmd, ok := u.events[i].(replEvent); ok {
+			return true, cmd.Command, true
+		}
+	}
+
+	return true, "", false
+}
+
+// editorIdleGap is the shortest time between two editor saves that counts as
+// the player having stopped working in the editor.
+const editorIdleGap = 5 * time.Minute
+
+// editorCadence returns the mean number of milliseconds between consecutive
+// editor saves in the session, and how many of those gaps were at least
+// editorIdleGap long. It returns false if the session has fewer than two
+// saves.
+func (u *session) editorCadence() (cadence float64, idleGaps int, ok bool) {
+	idleMillis := int64(editorIdleGap / time.Millisecond)
+
+	var gaps []float64
+	previous := int64(-1)
+	for _, e := range u.events {
+		if _, ok := e.(editorEvent); !ok {
+			continue
+		}
+
+		timestamp := e.getTimestamp()
+		if previous >= 0 {
+			gap := timestamp - previous
+			gaps = append(gaps, float64(gap))
+			if gap >= idleMillis {
+				idleGaps++
+			}
+		}
+		previous = timestamp
+	}
+
+	cadence, ok = mean(gaps)
+	return cadence, idleGaps, ok
+}
+
+// editorSaveCount returns the number of editor saves in the session.
+func (u *session) editorSaveCount() int {
+	count := 0
+	for _, e := range u.events {
+		if _, ok := e.(editorEvent); ok {
+			count++
+		}
+	}
+
+	return count
+}
+
+// editorRatio returns the number of editor saves per REPL command in the
+// session. It returns false if the session ran no commands, in which case the
+// ratio is undefined.
+func (u *session) editorRatio() (float64, bool) {
+	commands := u.commandCount()
+	if commands == 0 {
+		return 0, false
+	}
+
+	return float64(u.editorSaveCount()) / float64(commands), true
+}
+
+// ranNoCommands returns true if the session has events but none of them are
+// REPL commands, meaning the player only used the editor or hit errors.
+func (u *session) ranNoCommands() bool {
+	return len(u.events) > 0 && u.commandCount() == 0
+}
+
+// successRate returns the fraction of REPL commands in the session that
+// weren't immediately followed by an error. It returns false if the session
+// has no commands.
+func (u *session) successRate() (float64, bool) {
+	total, succeeded := 0, 0
+	for _, pair := range u.commandAndErrors() {
+		if pair.noCmd {
+			continue
+		}
+
+		total++
+		if pair.err == nil {
+			succeeded++
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	return float64(succeeded) / float64(total), true
+}
+
+// errorRecovery counts what happened after each REPL command in a session that
+// caused an error.
+type errorRecovery struct {
+	// recovered is the number whose next command succeeded
+	recovered int
+	// keptErroring is the number whose next command also caused an error
+	keptErroring int
+	// ended is the number that were the session's last command, which counts
+	// as not recovering
+	ended int
+}
+
+// errorRecovery looks at the command after each one in the session that
+// caused an error to see whether the player recovered. Errors that weren't
+// caused by a command are skipped over.
+func (u *session) errorRecovery() errorRecovery {
+	var commands []commandAndError
+	for _, pair := range u.commandAndErrors() {
+		if !pair.noCmd {
+			commands = append(commands, pair)
+		}
+	}
+
+	var recovery errorRecovery
+	for i, pair := range commands {
+		switch {
+		case pair.err == nil:
+		case i+1 == len(commands):
+			recovery.ended++
+		case commands[i+1].err == nil:
+			recovery.recovered++
+		default:
+			recovery.keptErroring++
+		}
+	}
+
+	return recovery
+}
+
+// timesToError returns the number of milliseconds between each REPL command in
+// the session and the error it caused. Negative times, which happen when
+// timestamps are out of order, are clamped to zero.
+func (u *session) timesToError() []float64 {
+	var times []float64
+	for _, pair := range u.commandAndErrors() {
+		if pair.noCmd || pair.err == nil {
+			continue
+		}
+
+		delta := pair.err.Timestamp - pair.cmd.Timestamp
+		if delta < 0 {
+			log.Printf("Warning: error for UID %v came %vms before its command, clamping to 0",
+				u.uid, -delta)
+			delta = 0
+		}
+		times = append(times, float64(delta))
+	}
+
+	return times
+}
+
+// retryStreak is a run of consecutive identical REPL commands, which usually
+// means the player is retrying a command that failed.
+type retryStreak struct {
+	command string
+	length  int
+}
+
+// retryStreaks returns every run of two or more consecutive REPL commands in
+// the session that are identical after trimming whitespace. Other kinds of
+// events between the commands don't break a run.
+func (u *session) retryStreaks() []retryStreak {
+	var streaks []retryStreak
+	var current retryStreak
+
+	endStreak := func() {
+		if current.length >= 2 {
+			streaks = append(streaks, current)
+		}
+	}
+
+	for _, e := range u.events {
+		cmd, ok := e.(replEvent)
+		if !ok {
+			continue
+		}
+
+		command := strings.TrimSpace(cmd.Command)
+		if current.length > 0 && command == current.command {
+			current.length++
+			continue
+		}
+
+		endStreak()
+		current = retryStreak{command: command, length: 1}
+	}
+	endStreak()
+
+	return streaks
+}
+
+// mean returns the arithmetic mean of the values, or false if there are none.
+func mean(values []float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values)), true
+}
+
+// Distribution summarizes a set of values.
+type Distribution struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+}
+
+// newDistribution summarizes the values, or returns nil if there are none.
+func newDistribution(values []float64) *Distribution {
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	avg, _ := mean(sorted)
+
+	return &Distribution{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Mean:   avg,
+		Median: percentile(sorted, 0.5),
+		P90:    percentile(sorted, 0.9),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+// newCountDistribution is like newDistribution, but for values given as the
+// number of times each occurred. It returns the same summary without needing
+// every value in memory.
+func newCountDistribution(counts map[int]int) *Distribution {
+	var values []int
+	total, sum := 0, 0.0
+	for value, count := range counts {
+		if count > 0 {
+			values = append(values, value)
+			total += count
+			sum += float64(value) * float64(count)
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	sort.Ints(values)
+
+	// nth returns the value at index n of the values in sorted order
+	nth := func(n int) float64 {
+		for _, value := range values {
+			if n < counts[value] {
+				return float64(value)
+			}
+			n -= counts[value]
+		}
+		panic("index out of range")
+	}
+	// countPercentile interpolates between the closest ranks, like
+	// percentile does
+	countPercentile := func(p float64) float64 {
+		rank := p * float64(total-1)
+		lower := nth(int(math.Floor(rank)))
+		upper := nth(int(math.Ceil(rank)))
+		return lower + (upper-lower)*(rank-math.Floor(rank))
+	}
+
+	return &Distribution{
+		Count:  total,
+		Min:    float64(values[0]),
+		Mean:   sum / float64(total),
+		Median: countPercentile(0.5),
+		P90:    countPercentile(0.9),
+		Max:    float64(values[len(values)-1]),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted values, where p is
+// between 0 and 1, interpolating linearly between the closest ranks. This
+// makes the median of an even number of values the mean of the middle two.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	fraction := rank - float64(lower)
+	return sorted[lower] + (sorted[upper]-sorted[lower])*fraction
+}
@@ -0,0 +1,857 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// commandEvents returns REPL command events of the UID running each command,
+// a second apart.
+func commandEvents(uid string, commands ...string) []event {
+	events := make([]event, len(commands))
+	for i, command := range commands {
+		events[i] = replEvent(datatypes.REPLCommand{UID: uid, Timestamp: int64(i * 1000), Command: command})
+	}
+
+	return events
+}
+
+// newTestAggregator returns an aggregator with the default options that adds
+// to the report.
+func newTestAggregator(report *Report) *sessionAggregator {
+	errorBounds, err := parseBounds(defaultErrorsPerSessionBounds)
+	if err != nil {
+		panic(err)
+	}
+
+	return newSessionAggregator(report, aggregatorOptions{
+		location:    time.UTC,
+		top:         20,
+		paste:       pasteThreshold{chars: defaultPasteChars, ratio: defaultPasteRatio},
+		editorRun:   regexp.MustCompile(defaultEditorRunPattern),
+		safeMinRuns: defaultSafeCommandMinRuns,
+		errorBounds: errorBounds,
+	})
+}
+
+func TestRetryStreaks(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []event
+		want   []retryStreak
+	}{
+		{"single command", commandEvents("player", "(fire)"), nil},
+		{"no repeats", commandEvents("player", "(fire)", "(help)", "(fire)"), nil},
+		{
+			"one streak",
+			commandEvents("player", "(help)", "(fire)", "(fire)", "(fire)", "(help)"),
+			[]retryStreak{{"(fire)", 3}},
+		},
+		{
+			"whitespace is trimmed",
+			commandEvents("player", "(fire)", "  (fire)", "(fire)\n", "\t(fire) "),
+			[]retryStreak{{"(fire)", 4}},
+		},
+		{
+			"inner whitespace counts",
+			commandEvents("player", "(set-speed 1)", "(set-speed  1)"),
+			nil,
+		},
+		{
+			"streaks in order",
+			commandEvents("player", "(fire)", "(fire)", "(help)", "(help)", "(help)", "(fire)", "(fire)"),
+			[]retryStreak{{"(fire)", 2}, {"(help)", 3}, {"(fire)", 2}},
+		},
+		{
+			"other events don't break a streak",
+			[]event{
+				replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire)"}),
+				errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Ship overheated"}),
+				editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1500}),
+				replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire)"}),
+			},
+			[]retryStreak{{"(fire)", 2}},
+		},
+		{"no commands", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			if got := sess.retryStreaks(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("retryStreaks() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLongestRetryStreak(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+
+	// Each session's longest streak is as long as the first's, so the first
+	// one found is kept
+	sessions := []session{
+		{uid: "first", events: commandEvents("first", "(help)", "(fire)", "(fire)", "(fire)")},
+		{uid: "second", events: commandEvents("second", "(help)", "(help)", "(help)", "(fire)", "(fire)")},
+		{uid: "single", events: commandEvents("single", "(fire)")},
+	}
+	for i, sess := range sessions {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	want := &RetryStreak{UID: "first", Command: "(fire)", Length: 3}
+	if !reflect.DeepEqual(report.LongestRetryStreak, want) {
+		t.Errorf("LongestRetryStreak = %+v, want %+v", report.LongestRetryStreak, want)
+	}
+	if report.RetryStreakCount != 3 {
+		t.Errorf("RetryStreakCount = %v, want 3", report.RetryStreakCount)
+	}
+	if counts := []int{report.Sessions[0].RetryStreakCount, report.Sessions[1].RetryStreakCount, report.Sessions[2].RetryStreakCount}; !reflect.DeepEqual(counts, []int{1, 2, 0}) {
+		t.Errorf("per-session streak counts = %v, want [1 2 0]", counts)
+	}
+}
+
+func TestLongestRetryStreakNone(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	aggregator.add(session{uid: "single", events: commandEvents("single", "(fire)")}, 0)
+	aggregator.finish()
+
+	if report.LongestRetryStreak != nil || report.RetryStreakCount != 0 {
+		t.Errorf("got longest streak %+v of %v, want none", report.LongestRetryStreak, report.RetryStreakCount)
+	}
+}
+
+func TestSessionDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []int64
+		want       int64
+	}{
+		{"no events", nil, 0},
+		{"single event", []int64{5000}, 0},
+		{"two events", []int64{1000, 4500}, 3500},
+		{"many events", []int64{1000, 1000, 2000, 9000}, 8000},
+		{"same time", []int64{3000, 3000}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player"}
+			for _, timestamp := range test.timestamps {
+				sess.events = append(sess.events, replEvent(datatypes.REPLCommand{UID: "player", Timestamp: timestamp}))
+			}
+			if got := sess.duration(); got != test.want {
+				t.Errorf("duration() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewDistribution(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   *Distribution
+	}{
+		{"none", nil, nil},
+		{"one", []float64{7}, &Distribution{Count: 1, Min: 7, Mean: 7, Median: 7, P90: 7, Max: 7}},
+		// The median of an odd count is the middle value
+		{"odd", []float64{9, 1, 5}, &Distribution{Count: 3, Min: 1, Mean: 5, Median: 5, P90: 8.2, Max: 9}},
+		// and of an even count, the mean of the middle two
+		{"even", []float64{8, 2, 4, 10}, &Distribution{Count: 4, Min: 2, Mean: 6, Median: 6, P90: 9.4, Max: 10}},
+		{"even with a skewed middle", []float64{0, 1, 3, 100}, &Distribution{Count: 4, Min: 0, Mean: 26, Median: 2, P90: 70.9, Max: 100}},
+		{"all the same", []float64{2, 2, 2, 2}, &Distribution{Count: 4, Min: 2, Mean: 2, Median: 2, P90: 2, Max: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := newDistribution(test.values)
+			if (got == nil) != (test.want == nil) {
+				t.Fatalf("newDistribution() = %+v, want %+v", got, test.want)
+			}
+			if got == nil {
+				return
+			}
+
+			// P90 is interpolated, so it's compared with some tolerance
+			if math.Abs(got.P90-test.want.P90) > 1e-9 {
+				t.Errorf("P90 = %v, want %v", got.P90, test.want.P90)
+			}
+			got.P90 = test.want.P90
+			if *got != *test.want {
+				t.Errorf("newDistribution() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSessionDurationDistribution(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+
+	// Durations of 0, 2000 and 10000, where the single event counts as 0
+	sessions := []session{
+		{uid: "single", events: commandEvents("single", "(fire)")},
+		{uid: "short", events: commandEvents("short", "(fire)", "(help)", "(fire)")},
+		{uid: "long", events: []event{
+			replEvent(datatypes.REPLCommand{UID: "long", Timestamp: 5000}),
+			replEvent(datatypes.REPLCommand{UID: "long", Timestamp: 15000}),
+		}},
+	}
+	for i, sess := range sessions {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	if report.SessionDuration == nil || report.SessionDuration.Median != 2000 || report.SessionDuration.Mean != 4000 {
+		t.Errorf("SessionDuration = %+v, want a median of 2000 and mean of 4000", report.SessionDuration)
+	}
+}
+
+// erroringSession returns a session of the UID. Each command is followed by
+// an error if it's in erroring.
+func erroringSession(uid string, commands []string, erroring map[int]bool) session {
+	sess := session{uid: uid}
+	for i, command := range commands {
+		timestamp := int64(i * 1000)
+		sess.events = append(sess.events, replEvent(datatypes.REPLCommand{UID: uid, Timestamp: timestamp, Command: command}))
+		if erroring[i] {
+			sess.events = append(sess.events, errorEvent(datatypes.ErrorInstance{UID: uid, Timestamp: timestamp + 100, Description: "Too many arguments"}))
+		}
+	}
+
+	return sess
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		sess session
+		want errorRecovery
+	}{
+		{
+			"recovered",
+			erroringSession("player", []string{"(help 1 2)", "(help)"}, map[int]bool{0: true}),
+			errorRecovery{recovered: 1},
+		},
+		{
+			"kept erroring",
+			erroringSession("player", []string{"(help 1 2)", "(help 1)", "(help)"}, map[int]bool{0: true, 1: true}),
+			errorRecovery{keptErroring: 1, recovered: 1},
+		},
+		{
+			"ended",
+			erroringSession("player", []string{"(help)", "(help 1 2)"}, map[int]bool{1: true}),
+			errorRecovery{ended: 1},
+		},
+		{
+			"every outcome",
+			erroringSession("player", []string{"(a)", "(b)", "(c)", "(d)", "(e)"}, map[int]bool{0: true, 1: true, 4: true}),
+			errorRecovery{keptErroring: 1, recovered: 1, ended: 1},
+		},
+		{
+			"no errors",
+			erroringSession("player", []string{"(help)", "(fire)"}, nil),
+			errorRecovery{},
+		},
+		{
+			// An error with no command of its own neither counts nor
+			// separates the commands around it
+			"error without a command",
+			session{uid: "player", events: []event{
+				errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 0}),
+				replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(help 1 2)"}),
+				errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1100}),
+				errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1200}),
+				replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(help)"}),
+			}},
+			errorRecovery{recovered: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.sess.errorRecovery(); got != test.want {
+				t.Errorf("errorRecovery() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorRecoveryRate(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	aggregator.add(erroringSession("a", []string{"(a)", "(b)", "(c)"}, map[int]bool{0: true, 1: true}), 0)
+	aggregator.add(erroringSession("b", []string{"(a)", "(b)"}, map[int]bool{1: true}), 0)
+	aggregator.finish()
+
+	recovery := report.ErrorRecovery
+	if recovery.Recovered != 1 || recovery.KeptErroring != 1 || recovery.SessionEnded != 1 {
+		t.Errorf("ErrorRecovery = %+v, want one of each outcome", recovery)
+	}
+	if recovery.RecoveryRate == nil || math.Abs(*recovery.RecoveryRate-1.0/3) > 1e-9 {
+		t.Errorf("RecoveryRate = %v, want 1/3", recovery.RecoveryRate)
+	}
+
+	var empty Report
+	aggregator = newTestAggregator(&empty)
+	aggregator.add(erroringSession("c", []string{"(a)"}, nil), 0)
+	aggregator.finish()
+	if empty.ErrorRecovery.RecoveryRate != nil {
+		t.Errorf("with no errors, RecoveryRate = %v, want nil", *empty.ErrorRecovery.RecoveryRate)
+	}
+}
+
+func TestCommandsPerSession(t *testing.T) {
+	// One UID's events across two client sessions, with their own counts of
+	// commands, and an older session without IDs that's split by time
+	var events []event
+	for i, sessionID := range []string{"a", "b", "a", "a", "b"} {
+		events = append(events, replEvent(datatypes.REPLCommand{UID: "player", SessionID: sessionID, Timestamp: int64(i * 1000)}))
+	}
+	events = append(events,
+		errorEvent(datatypes.ErrorInstance{UID: "player", SessionID: "c", Timestamp: 5000}),
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 10}),
+	)
+	sortEvents(events)
+	sessions := groupSessions(session{uid: "player", events: events}, defaultSessionGap)
+
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range sessions {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	// In order of their first event, session a, the one without an ID, b and
+	// c ran 3, 0, 2 and 0 commands, and the ones that ran none still count
+	var counts []int
+	for _, sess := range report.Sessions {
+		counts = append(counts, sess.CommandCount)
+	}
+	if want := []int{3, 0, 2, 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("commands per session = %v, want %v", counts, want)
+	}
+
+	want := &Distribution{Count: 4, Min: 0, Mean: 1.25, Median: 1, P90: 2.7, Max: 3}
+	got := report.CommandsPerSession
+	if got == nil || math.Abs(got.P90-want.P90) > 1e-9 {
+		t.Fatalf("CommandsPerSession = %+v, want %+v", got, want)
+	}
+	got.P90 = want.P90
+	if *got != *want {
+		t.Errorf("CommandsPerSession = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommandsPerSessionNone(t *testing.T) {
+	var report Report
+	newTestAggregator(&report).finish()
+
+	if report.CommandsPerSession != nil {
+		t.Errorf("with no sessions, CommandsPerSession = %+v, want nil", report.CommandsPerSession)
+	}
+}
+
+func TestRanNoCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []event
+		want   bool
+	}{
+		{"empty", nil, false},
+		{"one command", commandEvents("player", "(fire)"), false},
+		{"commands", commandEvents("player", "(fire)", "(help)"), false},
+		{"only errors", []event{
+			errorEvent(datatypes.ErrorInstance{UID: "player", Description: "Too many arguments"}),
+		}, true},
+		{"only editor saves", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Content: "(fire)"}),
+			editorEvent(datatypes.EditorContent{UID: "player", Content: "(help)"}),
+		}, true},
+		{"errors and editor saves", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Content: "(fire"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Description: "Expected ')'"}),
+		}, true},
+		{"an editor save and one command", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Content: "(fire)"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Command: "(fire)"}),
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			if got := sess.ranNoCommands(); got != test.want {
+				t.Errorf("ranNoCommands() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNoCommandSessions(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		{uid: "busy", events: commandEvents("busy", "(fire)", "(help)")},
+		{uid: "idle", sessionID: "idle-1", events: []event{
+			editorEvent(datatypes.EditorContent{UID: "idle", SessionID: "idle-1", Content: "(fire)"}),
+		}},
+		{uid: "once", events: commandEvents("once", "(fire)")},
+		{uid: "broken", events: []event{
+			errorEvent(datatypes.ErrorInstance{UID: "broken", Description: "Too many arguments"}),
+		}},
+		{uid: "empty"},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	want := []SessionRef{
+		{UID: "idle", SessionID: "idle-1", Index: 1},
+		{UID: "broken", Index: 3},
+	}
+	if !reflect.DeepEqual(report.NoCommandSessions, want) {
+		t.Errorf("NoCommandSessions = %+v, want %+v", report.NoCommandSessions, want)
+	}
+}
+
+// withEditorSave returns the session with an editor save added after its
+// other events.
+func withEditorSave(sess session) session {
+	sess.events = append(sess.events, editorEvent(datatypes.EditorContent{UID: sess.uid, Timestamp: 60 * 1000, Content: "(fire)"}))
+	return sess
+}
+
+func TestEditorErrorRates(t *testing.T) {
+	commands := []string{"(fire)", "(help)", "(fire)"}
+	float := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name     string
+		sessions []session
+		want     EditorErrorRates
+	}{
+		{
+			"mixed",
+			[]session{
+				withEditorSave(erroringSession("a", commands, map[int]bool{0: true, 2: true})),
+				withEditorSave(erroringSession("b", commands, nil)),
+				erroringSession("c", commands, map[int]bool{0: true, 1: true, 2: true}),
+				erroringSession("d", commands, nil),
+				erroringSession("e", commands, map[int]bool{1: true}),
+				// An editor save alone puts the session with the editor users
+				withEditorSave(session{uid: "f"}),
+			},
+			EditorErrorRates{
+				EditorSessions:            3,
+				NonEditorSessions:         3,
+				EditorErrorsPerSession:    float(2.0 / 3),
+				NonEditorErrorsPerSession: float(4.0 / 3),
+				Delta:                     float(-2.0 / 3),
+			},
+		},
+		{
+			"no editor users",
+			[]session{
+				erroringSession("c", commands, map[int]bool{0: true}),
+				erroringSession("d", commands, nil),
+			},
+			EditorErrorRates{
+				NonEditorSessions:         2,
+				NonEditorErrorsPerSession: float(0.5),
+			},
+		},
+		{
+			"only editor users",
+			[]session{
+				withEditorSave(erroringSession("a", commands, map[int]bool{0: true})),
+			},
+			EditorErrorRates{
+				EditorSessions:         1,
+				EditorErrorsPerSession: float(1),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var report Report
+			aggregator := newTestAggregator(&report)
+			for i, sess := range test.sessions {
+				aggregator.add(sess, i)
+			}
+			aggregator.finish()
+
+			got := report.EditorErrorRates
+			if got.EditorSessions != test.want.EditorSessions || got.NonEditorSessions != test.want.NonEditorSessions {
+				t.Errorf("got %v editor and %v non-editor sessions, want %v and %v",
+					got.EditorSessions, got.NonEditorSessions, test.want.EditorSessions, test.want.NonEditorSessions)
+			}
+			for _, rate := range []struct {
+				name      string
+				got, want *float64
+			}{
+				{"EditorErrorsPerSession", got.EditorErrorsPerSession, test.want.EditorErrorsPerSession},
+				{"NonEditorErrorsPerSession", got.NonEditorErrorsPerSession, test.want.NonEditorErrorsPerSession},
+				{"Delta", got.Delta, test.want.Delta},
+			} {
+				if (rate.got == nil) != (rate.want == nil) ||
+					rate.got != nil && math.Abs(*rate.got-*rate.want) > 1e-9 {
+					t.Errorf("%v = %v, want %v", rate.name, formatFloat(rate.got), formatFloat(rate.want))
+				}
+			}
+		})
+	}
+}
+
+func TestFirstCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []event
+		want    string
+		wantCmd bool
+	}{
+		{"empty", nil, "", false},
+		{"no commands", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(fire)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments"}),
+		}, "", false},
+		{"commands", commandEvents("player", "(help)", "(fire)"), "(help)", true},
+		{"after other events", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(fire)"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire)"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 3000, Command: "(help)"}),
+		}, "(fire)", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			got, ok := sess.firstCommand()
+			if got != test.want || ok != test.wantCmd {
+				t.Errorf("firstCommand() = %q, %v, want %q, %v", got, ok, test.want, test.wantCmd)
+			}
+		})
+	}
+}
+
+func TestFirstCommands(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		{uid: "a", events: commandEvents("a", "(HELP)", "(fire)")},
+		{uid: "b", events: commandEvents("b", "(help)")},
+		{uid: "c", events: commandEvents("c", "(set-speed 10)", "(help)")},
+		{uid: "d", events: commandEvents("d", "  (set-speed 5)")},
+		// Sessions without commands don't have a first one to count
+		{uid: "e", events: []event{
+			editorEvent(datatypes.EditorContent{UID: "e", Timestamp: 1000, Content: "(help)"}),
+		}},
+		{uid: "f"},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	want := []RankedValue{{"(help)", 2}, {"(set-speed <n>)", 2}}
+	if !reflect.DeepEqual(report.FirstCommands, want) {
+		t.Errorf("FirstCommands = %v, want %v", report.FirstCommands, want)
+	}
+}
+
+func TestEndedInError(t *testing.T) {
+	tests := []struct {
+		name             string
+		events           []event
+		wantEndedInError bool
+		wantCmd          string
+		wantHasCmd       bool
+	}{
+		{"empty", nil, false, "", false},
+		{"ends in a command", commandEvents("player", "(fire)"), false, "", false},
+		{"error then command", []event{
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Too many arguments"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire)"}),
+		}, false, "", false},
+		{"ends in an editor save", []event{
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Too many arguments"}),
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 2000, Content: "(fire)"}),
+		}, false, "", false},
+		{"command then error", []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(help)"}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire 1 2)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Too many arguments"}),
+		}, true, "(fire 1 2)", true},
+		// The command is found past the other events that came after it
+		{"command, save, then errors", []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(fire 1 2)"}),
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 2000, Content: "(fire)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 3000, Description: "Too many arguments"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 4000, Description: "Too many arguments"}),
+		}, true, "(fire 1 2)", true},
+		{"only an error", []event{
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 1000, Description: "Too many arguments"}),
+		}, true, "", false},
+		{"save then error", []event{
+			editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 1000, Content: "(fire"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Expected ')'"}),
+		}, true, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			endedInError, cmd, hasCmd := sess.endedInError()
+			if endedInError != test.wantEndedInError || cmd != test.wantCmd || hasCmd != test.wantHasCmd {
+				t.Errorf("endedInError() = %v, %q, %v, want %v, %q, %v",
+					endedInError, cmd, hasCmd, test.wantEndedInError, test.wantCmd, test.wantHasCmd)
+			}
+		})
+	}
+}
+
+func TestDeadEndCommands(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		erroringSession("a", []string{"(help)", "(fire 1 2)"}, map[int]bool{1: true}),
+		erroringSession("b", []string{"(fire 3 4)"}, map[int]bool{0: true}),
+		erroringSession("c", []string{"(help)"}, map[int]bool{0: true}),
+		// Sessions that recovered from their errors aren't dead ends
+		erroringSession("d", []string{"(fire 1 2)", "(fire)"}, map[int]bool{0: true}),
+		{uid: "e", events: []event{
+			errorEvent(datatypes.ErrorInstance{UID: "e", Timestamp: 1000, Description: "Too many arguments"}),
+		}},
+		{uid: "f", events: []event{
+			editorEvent(datatypes.EditorContent{UID: "f", Timestamp: 1000, Content: "(fire"}),
+			errorEvent(datatypes.ErrorInstance{UID: "f", Timestamp: 2000, Description: "Expected ')'"}),
+		}},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	want := []RankedValue{{"(fire <n> <n>)", 2}, {"(help)", 1}}
+	if !reflect.DeepEqual(report.DeadEndCommands, want) {
+		t.Errorf("DeadEndCommands = %v, want %v", report.DeadEndCommands, want)
+	}
+	if report.ErrorEndingsWithoutCommand != 2 {
+		t.Errorf("ErrorEndingsWithoutCommand = %v, want 2", report.ErrorEndingsWithoutCommand)
+	}
+}
+
+// descriptionErrors returns errors with each of the descriptions, 1s apart.
+func descriptionErrors(uid string, descriptions ...string) []event {
+	events := make([]event, len(descriptions))
+	for i, description := range descriptions {
+		events[i] = errorEvent(datatypes.ErrorInstance{UID: uid, Timestamp: int64(i * 1000), Description: description})
+	}
+
+	return events
+}
+
+func TestDistinctErrorTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []event
+		want   int
+	}{
+		{"no events", nil, 0},
+		{"no errors", commandEvents("player", "(fire)", "(help)"), 0},
+		{"one", descriptionErrors("player", "Too many arguments"), 1},
+		{"the same type repeatedly", descriptionErrors("player",
+			"Too many arguments", "Too many arguments", "Too many arguments"), 1},
+		// Different descriptions can still be of the same type
+		{"the same type with different values", descriptionErrors("player",
+			"Variable speed has no value", "Variable angle has no value"), 1},
+		{"several types", descriptionErrors("player",
+			"Too many arguments", "Variable speed has no value", "Unknown callable 'fly'", "Too many arguments"), 3},
+		// Every error that matches no pattern is counted as one type
+		{"unclassified", descriptionErrors("player",
+			"The ship exploded", "Something else", "Too many arguments"), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			if got := sess.distinctErrorTypes(); got != test.want {
+				t.Errorf("distinctErrorTypes() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorTypesPerUID(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for _, sess := range []session{
+		{uid: "stuck", events: descriptionErrors("stuck", "Too many arguments", "Too many arguments", "Too many arguments")},
+		{uid: "lost", events: descriptionErrors("lost", "Too many arguments", "Variable speed has no value", "Unknown callable 'fly'")},
+		// UIDs without errors count as hitting none
+		{uid: "fine", events: commandEvents("fine", "(fire)")},
+	} {
+		aggregator.addUID(sess)
+	}
+	aggregator.finish()
+
+	got := report.ErrorTypesPerUID
+	if got == nil {
+		t.Fatal("ErrorTypesPerUID is nil")
+	}
+	if got.Count != 3 || got.Min != 0 || got.Median != 1 || got.Max != 3 || math.Abs(got.Mean-4.0/3) > 1e-9 {
+		t.Errorf("ErrorTypesPerUID = %+v, want 3 UIDs hitting 0, 1 and 3 types", got)
+	}
+}
+
+// editorSaves returns editor saves at each of the timestamps.
+func editorSaves(uid string, timestamps ...int64) []event {
+	events := make([]event, len(timestamps))
+	for i, timestamp := range timestamps {
+		events[i] = editorEvent(datatypes.EditorContent{UID: uid, Timestamp: timestamp, Content: "(fire)"})
+	}
+
+	return events
+}
+
+func TestEditorCadence(t *testing.T) {
+	minute := int64(time.Minute / time.Millisecond)
+
+	tests := []struct {
+		name         string
+		events       []event
+		wantCadence  float64
+		wantIdleGaps int
+		wantOK       bool
+	}{
+		{"no saves", commandEvents("player", "(fire)"), 0, 0, false},
+		{"one save", editorSaves("player", 1000), 0, 0, false},
+		{"two saves", editorSaves("player", 1000, 4000), 3000, 0, true},
+		{"steady", editorSaves("player", 0, 10000, 20000, 30000), 10000, 0, true},
+		{"uneven", editorSaves("player", 0, 1000, 4000, 10000), 10000.0 / 3, 0, true},
+		// A gap of exactly editorIdleGap is idle, and one just short of it
+		// isn't
+		{"idle gaps", editorSaves("player", 0, 5*minute, 10*minute-1, 20*minute), float64(20*minute) / 3, 2, true},
+		// Other events between saves don't break up the gaps
+		{"with other events", append(editorSaves("player", 0, 6000),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(fire)"}),
+			errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 3000, Description: "Too many arguments"}),
+		), 6000, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			sortEvents(sess.events)
+			cadence, idleGaps, ok := sess.editorCadence()
+			if ok != test.wantOK || idleGaps != test.wantIdleGaps || math.Abs(cadence-test.wantCadence) > 1e-9 {
+				t.Errorf("editorCadence() = %v, %v, %v, want %v, %v, %v",
+					cadence, idleGaps, ok, test.wantCadence, test.wantIdleGaps, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestEditorCadenceReport(t *testing.T) {
+	minute := int64(time.Minute / time.Millisecond)
+
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		{uid: "a", events: editorSaves("a", 0, 2000, 4000)},
+		{uid: "b", events: editorSaves("b", 0, 6000)},
+		{uid: "c", events: editorSaves("c", 0, 10*minute)},
+		// Sessions with fewer than two saves have no cadence
+		{uid: "d", events: editorSaves("d", 0)},
+		{uid: "e", events: commandEvents("e", "(fire)")},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	if got := report.EditorCadence; got == nil || got.Count != 3 || got.Median != 6000 {
+		t.Errorf("EditorCadence = %+v, want the median of 3 sessions to be 6000", got)
+	}
+	if report.EditorIdleGaps != 1 {
+		t.Errorf("EditorIdleGaps = %v, want 1", report.EditorIdleGaps)
+	}
+
+	// Sessions without a cadence report N/A, marked here by -1
+	wantCadences := []float64{2000, 6000, float64(10 * minute), -1, -1}
+	for i, sess := range report.Sessions {
+		want := wantCadences[i]
+		if got := sess.EditorCadence; (got == nil) != (want < 0) || got != nil && *got != want {
+			t.Errorf("session %v cadence = %v, want %v", i, formatFloat(got), want)
+		}
+	}
+}
+
+func TestEditorRatio(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []event
+		want   float64
+		wantOK bool
+	}{
+		{"empty", nil, 0, false},
+		// With no commands to divide by, there's no ratio, however many
+		// saves there were
+		{"saves without commands", editorSaves("player", 1000, 2000, 3000), 0, false},
+		{"errors without commands", descriptionErrors("player", "Too many arguments"), 0, false},
+		{"commands without saves", commandEvents("player", "(fire)", "(help)"), 0, true},
+		{"a save per command", append(commandEvents("player", "(fire)", "(help)"), editorSaves("player", 500, 1500)...), 1, true},
+		{"more saves than commands", append(commandEvents("player", "(fire)", "(help)"), editorSaves("player", 100, 200, 300)...), 1.5, true},
+		{"fewer saves than commands", append(commandEvents("player", "(fire)", "(help)", "(fire)", "(help)"), editorSaves("player", 100)...), 0.25, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sess := session{uid: "player", events: test.events}
+			got, ok := sess.editorRatio()
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("editorRatio() = %v, %v, want %v, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestEditorRatioReport(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		{uid: "a", events: append(commandEvents("a", "(fire)", "(help)"), editorSaves("a", 100, 200, 300)...)},
+		{uid: "b", events: commandEvents("b", "(fire)")},
+		// Sessions that only saved the editor are counted instead of being
+		// divided by zero
+		{uid: "c", events: editorSaves("c", 100, 200)},
+		{uid: "d", events: editorSaves("d", 100)},
+		// A session with neither has no ratio and isn't editor-only
+		{uid: "e", events: descriptionErrors("e", "Too many arguments")},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	if got := report.EditorRatio; got == nil || got.Count != 2 || got.Min != 0 || got.Max != 1.5 {
+		t.Errorf("EditorRatio = %+v, want the ratios 0 and 1.5", got)
+	}
+	if report.EditorOnlySessions != 2 {
+		t.Errorf("EditorOnlySessions = %v, want 2", report.EditorOnlySessions)
+	}
+	for i, want := range []bool{true, true, false, false, false} {
+		if sess := report.Sessions[i]; (sess.EditorRatio != nil) != want {
+			t.Errorf("session %v ratio = %v, want a ratio: %v", i, formatFloat(sess.EditorRatio), want)
+		}
+	}
+	if saves := report.Sessions[2].EditorSaves; saves != 2 {
+		t.Errorf("editor-only session has %v saves, want 2", saves)
+	}
+}
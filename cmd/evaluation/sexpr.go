@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of a token in a REPL command.
+type tokenKind int
+
+const (
+	openToken tokenKind = iota
+	closeToken
+	// atomToken is a symbol, number, or any other run of characters that
+	// isn't a parenthesis, string or whitespace
+	atomToken
+	stringToken
+)
+
+// token is a piece of a REPL command, as split by tokenizeCommand.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// errUnterminatedString is returned by tokenizeCommand when a string literal
+// isn't closed.
+var errUnterminatedString = errors.New("unterminated string literal")
+
+// tokenizeCommand splits a REPL command into parentheses, atoms, and string
+// literals. A quote before an opening parenthesis is its own atom, and
+// comments from a semicolon to the end of the line are skipped. If the
+// command is malformed, the tokens before the problem are returned along with
+// an error.
+func tokenizeCommand(command string) ([]token, error) {
+	var tokens []token
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == ';':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '(':
+			tokens = append(tokens, token{openToken, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{closeToken, ")"})
+			i++
+		case r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return tokens, errUnterminatedString
+			}
+			i++
+			tokens = append(tokens, token{stringToken, string(runes[start:i])})
+		case r == '\'':
+			tokens = append(tokens, token{atomToken, "'"})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !isTokenBoundary(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{atomToken, string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
+
+// isTokenBoundary returns true if the rune ends an atom.
+func isTokenBoundary(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`()";`, r)
+}
+
+// commandCallables parses a REPL command as s-expressions and returns the
+// symbol at the head of each form, including nested ones, in the order the
+// forms open. Forms headed by another form, a literal or nothing have no
+// callable, and neither do quoted lists. It returns an error if the command
+// is malformed.
+func commandCallables(command string) ([]string, error) {
+	tokens, err := tokenizeCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var callables []string
+	depth := 0
+	for i, tok := range tokens {
+		switch tok.kind {
+		case openToken:
+			depth++
+			quoted := i > 0 && tokens[i-1].kind == atomToken && tokens[i-1].text == "'"
+			if quoted || i+1 >= len(tokens) {
+				continue
+			}
+			if head := tokens[i+1]; head.kind == atomToken && isSymbol(head.text) {
+				callables = append(callables, head.text)
+			}
+		case closeToken:
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unexpected closing parenthesis")
+			}
+		}
+	}
+	if depth > 0 {
+		return nil, errors.New("unclosed parenthesis")
+	}
+
+	return callables, nil
+}
+
+// isSymbol returns true if the atom is a symbol rather than a number or quote.
+func isSymbol(atom string) bool {
+	if atom == "'" {
+		return false
+	}
+
+	_, err := strconv.ParseFloat(atom, 64)
+	return err != nil
+}
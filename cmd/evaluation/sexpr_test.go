@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestTokenizeCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []token
+		wantErr bool
+	}{
+		{"(fire)", []token{{openToken, "("}, {atomToken, "fire"}, {closeToken, ")"}}, false},
+		{
+			`(print "a (b) \"c\"" 1.5)`,
+			[]token{{openToken, "("}, {atomToken, "print"}, {stringToken, `"a (b) \"c\""`}, {atomToken, "1.5"}, {closeToken, ")"}},
+			false,
+		},
+		{
+			"'(a b) ; (not a call)\n(c)",
+			[]token{
+				{atomToken, "'"}, {openToken, "("}, {atomToken, "a"}, {atomToken, "b"}, {closeToken, ")"},
+				{openToken, "("}, {atomToken, "c"}, {closeToken, ")"},
+			},
+			false,
+		},
+		{`(print "oops)`, []token{{openToken, "("}, {atomToken, "print"}}, true},
+		{"", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			got, err := tokenizeCommand(test.command)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("tokenizeCommand() error = %v, want error: %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("tokenizeCommand() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandCallables(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{"(fire)", []string{"fire"}, false},
+		{"(set-speed 10)", []string{"set-speed"}, false},
+		// Nested forms are listed in the order they open
+		{"(set-speed (+ 1 (* 2 3)))", []string{"set-speed", "+", "*"}, false},
+		{"(define (go n) (if (> n 0) (fire) (stop)))", []string{"define", "go", "if", ">", "fire", "stop"}, false},
+		{"(fire) (stop)", []string{"fire", "stop"}, false},
+		{"(thruster-on 1)\n; (ignored)\n(thruster-off 1)", []string{"thruster-on", "thruster-off"}, false},
+		// Forms headed by something other than a symbol have no callable
+		{"((get-fn) 1)", []string{"get-fn"}, false},
+		{"(1 2 3)", nil, false},
+		{`("fire")`, nil, false},
+		{"()", nil, false},
+		{"(map fire '(1 2))", []string{"map"}, false},
+		{`(print "(not a call)")`, []string{"print"}, false},
+		{"fire", nil, false},
+		{"", nil, false},
+		// Malformed commands
+		{"(fire", nil, true},
+		{"(fire))", nil, true},
+		{")(", nil, true},
+		{`(print "unterminated)`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			got, err := commandCallables(test.command)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("commandCallables() error = %v, want error: %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("commandCallables() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandAnalysisCallables(t *testing.T) {
+	analysis := newCommandAnalysis()
+	for _, command := range []string{
+		"(set-speed (+ 1 2))",
+		"(set-speed 3)",
+		"(fire)",
+		"(fire",
+		`(print "oops)`,
+	} {
+		analysis.add(datatypes.REPLCommand{UID: "player", Command: command})
+	}
+
+	want := map[string]int{"set-speed": 2, "+": 1, "fire": 1}
+	if !reflect.DeepEqual(analysis.callables, want) {
+		t.Errorf("callables = %v, want %v", analysis.callables, want)
+	}
+	if analysis.unparsable != 2 {
+		t.Errorf("unparsable = %v, want 2", analysis.unparsable)
+	}
+}
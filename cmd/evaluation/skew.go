@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// skewThreshold is how far an event's timestamp may be behind the timestamp
+// of an event received before it without counting as a skew incident.
+const skewThreshold = time.Minute
+
+// skewIncidents returns the number of events in the session whose timestamp
+// is more than skewThreshold earlier than that of an event the server
+// received before them. These are events whose order by timestamp differs
+// significantly from the order they arrived in, because the client buffered
+// them while offline or its clock was wrong. Events without a received time
+// aren't considered.
+func (u *session) skewIncidents() int {
+	var received []event
+	for _, e := range u.events {
+		if e.getReceivedAt() != 0 {
+			received = append(received, e)
+		}
+	}
+	sort.SliceStable(received, func(i, j int) bool {
+		return received[i].getReceivedAt() < received[j].getReceivedAt()
+	})
+
+	thresholdMillis := int64(skewThreshold / time.Millisecond)
+
+	incidents := 0
+	latest := int64(0)
+	for i, e := range received {
+		timestamp := e.getTimestamp()
+		if i > 0 && timestamp < latest-thresholdMillis {
+			incidents++
+		}
+		if timestamp > latest {
+			latest = timestamp
+		}
+	}
+
+	return incidents
+}
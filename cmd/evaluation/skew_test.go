@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// receivedSession returns a session of REPL commands received a second apart,
+// in order, with the given timestamps in minutes. A negative timestamp is an
+// event without a received time.
+func receivedSession(uid string, minutes ...int64) session {
+	sess := session{uid: uid}
+	for i, minute := range minutes {
+		cmd := datatypes.REPLCommand{UID: uid, Timestamp: minute * 60 * 1000}
+		if minute >= 0 {
+			cmd.ReceivedAt = int64(i+1) * 1000
+		} else {
+			cmd.Timestamp = 0
+		}
+		sess.events = append(sess.events, replEvent(cmd))
+	}
+	sortEvents(sess.events)
+
+	return sess
+}
+
+func TestSkewIncidents(t *testing.T) {
+	tests := []struct {
+		minutes []int64
+		want    int
+	}{
+		{nil, 0},
+		{[]int64{10}, 0},
+		{[]int64{1, 2, 3, 4}, 0},
+		// Exactly skewThreshold behind isn't far enough to count
+		{[]int64{10, 9, 10}, 0},
+		// Buffered while offline, then sent after newer events
+		{[]int64{10, 11, 2, 3, 12}, 2},
+		// Every event is compared with the latest before it, not the one
+		// just before it
+		{[]int64{30, 1, 2, 3, 29, 31}, 3},
+		{[]int64{5, 4, 3, 2, 1}, 3},
+		{[]int64{7, 2, 9, 1, 8, 3, 10}, 3},
+		// Events without a received time aren't considered
+		{[]int64{10, -1, 2}, 1},
+		{[]int64{-1, -1}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.minutes), func(t *testing.T) {
+			sess := receivedSession("player", test.minutes...)
+			if got := sess.skewIncidents(); got != test.want {
+				t.Errorf("skewIncidents() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSkewIncidentsThreshold(t *testing.T) {
+	thresholdMillis := skewThreshold.Milliseconds()
+	sess := func(behind int64) session {
+		return session{uid: "player", events: []event{
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 0, ReceivedAt: 2}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: thresholdMillis * 2, ReceivedAt: 1}),
+			replEvent(datatypes.REPLCommand{UID: "player", Timestamp: thresholdMillis*2 - behind, ReceivedAt: 3}),
+		}}
+	}
+
+	// The event received second is two thresholds behind the first, so it's
+	// always an incident, and the third is behind by the given amount
+	exact, over := sess(thresholdMillis), sess(thresholdMillis+1)
+	if got := exact.skewIncidents(); got != 1 {
+		t.Errorf("exactly skewThreshold behind: skewIncidents() = %v, want 1", got)
+	}
+	if got := over.skewIncidents(); got != 2 {
+		t.Errorf("just over skewThreshold behind: skewIncidents() = %v, want 2", got)
+	}
+}
+
+func TestWorstSkewUID(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	aggregator.addUID(receivedSession("fine", 1, 2, 3))
+	aggregator.addUID(receivedSession("skewed", 10, 2, 11))
+	aggregator.addUID(receivedSession("worst", 30, 1, 2, 3))
+	aggregator.addUID(receivedSession("tied", 30, 1, 2, 3))
+
+	if report.SkewIncidents != 7 {
+		t.Errorf("SkewIncidents = %v, want 7", report.SkewIncidents)
+	}
+	want := RankedValue{Value: "worst", Count: 3}
+	if report.WorstSkewUID == nil || *report.WorstSkewUID != want {
+		t.Errorf("WorstSkewUID = %+v, want %+v", report.WorstSkewUID, want)
+	}
+}
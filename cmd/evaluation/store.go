@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+)
+
+// store is the part of the Datastore client the evaluation uses, so that the
+// analyses can be run against something other than a real Datastore.
+type store interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, query *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error)
+	Run(ctx context.Context, query *datastore.Query) queryIterator
+}
+
+// queryIterator iterates over the results of a query run by a store.
+type queryIterator interface {
+	Next(dst interface{}) (*datastore.Key, error)
+	Cursor() (datastore.Cursor, error)
+}
+
+// clientStore is a store backed by a Datastore client.
+type clientStore struct {
+	*datastore.Client
+}
+
+// Run runs the query with the client. The client's own Run returns a concrete
+// iterator, which doesn't satisfy store.
+func (s clientStore) Run(ctx context.Context, query *datastore.Query) queryIterator {
+	return s.Client.Run(ctx, query)
+}
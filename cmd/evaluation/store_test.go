@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// fakeStore is an in-memory store for tests. It supports the parts of
+// queries the evaluation uses: equality, inequality and "in" filters,
+// distinct projections, keys-only queries, limits and cursors. Results are
+// in key order, or in order of the projected properties for projections.
+//
+// Datastore queries don't expose what they filter on, so the fake reads
+// them with reflection. Unexported fields can be read that way as long as
+// they aren't converted back to interfaces.
+type fakeStore struct {
+	mutex    sync.Mutex
+	entities []fakeEntity
+	nextID   int64
+	// runs counts the queries that have been run
+	runs int
+	// latency is how long each query takes to run, to simulate the round
+	// trip to Datastore
+	latency time.Duration
+}
+
+// fakeEntity is an entity held by a fakeStore. value is a struct.
+type fakeEntity struct {
+	key   *datastore.Key
+	value reflect.Value
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{}
+}
+
+// add stores each of the entities under a new key of the given kind.
+func (s *fakeStore) add(kind string, entities ...interface{}) {
+	for _, entity := range entities {
+		if _, err := s.Put(context.Background(), datastore.IncompleteKey(kind, nil), entity); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (s *fakeStore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, entity := range s.entities {
+		if keysEqual(entity.key, key) {
+			reflect.ValueOf(dst).Elem().Set(entity.value)
+			return nil
+		}
+	}
+
+	return datastore.ErrNoSuchEntity
+}
+
+func (s *fakeStore) GetAll(ctx context.Context, query *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	var dstSlice reflect.Value
+	if dst != nil {
+		dstSlice = reflect.ValueOf(dst).Elem()
+	}
+
+	var keys []*datastore.Key
+	it := s.Run(ctx, query)
+	for {
+		var element reflect.Value
+		var loadInto interface{}
+		if dst != nil {
+			element = reflect.New(dstSlice.Type().Elem())
+			loadInto = element.Interface()
+		}
+
+		key, err := it.Next(loadInto)
+		if err == iterator.Done {
+			return keys, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+		if dst != nil {
+			dstSlice.Set(reflect.Append(dstSlice, element.Elem()))
+		}
+	}
+}
+
+func (s *fakeStore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value := reflect.ValueOf(src)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("fake store: can't store a %v", value.Type())
+	}
+	// Copy the value so that later changes to src aren't stored
+	stored := reflect.New(value.Type()).Elem()
+	stored.Set(value)
+
+	if key.Incomplete() {
+		s.nextID++
+		key = datastore.IDKey(key.Kind, s.nextID, nil)
+	}
+
+	for i, entity := range s.entities {
+		if keysEqual(entity.key, key) {
+			s.entities[i].value = stored
+			return key, nil
+		}
+	}
+	s.entities = append(s.entities, fakeEntity{key, stored})
+
+	return key, nil
+}
+
+func (s *fakeStore) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	values := reflect.ValueOf(src)
+	if values.Len() != len(keys) {
+		return nil, errors.New("fake store: keys and src have different lengths")
+	}
+
+	stored := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		var err error
+		if stored[i], err = s.Put(ctx, key, values.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+// queryKind returns the kind of entity the query is for.
+func queryKind(query *datastore.Query) string {
+	return reflect.ValueOf(query).Elem().FieldByName("kind").String()
+}
+
+func (s *fakeStore) Run(ctx context.Context, query *datastore.Query) queryIterator {
+	time.Sleep(s.latency)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.runs++
+
+	q := reflect.ValueOf(query).Elem()
+	if !q.FieldByName("err").IsNil() {
+		return &fakeIterator{err: errors.New("fake store: invalid query")}
+	}
+	kind := queryKind(query)
+	projection := q.FieldByName("projection")
+	if err := checkProjection(projection, q.FieldByName("filter")); err != nil {
+		return &fakeIterator{err: err}
+	}
+
+	var results []fakeEntity
+	for _, entity := range s.entities {
+		if entity.key.Kind == kind && matchesFilters(entity.value, q.FieldByName("filter")) {
+			results = append(results, entity)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		for p := 0; p < projection.Len(); p++ {
+			name := projection.Index(p).String()
+			a, b := results[i].value.FieldByName(name), results[j].value.FieldByName(name)
+			if c, _ := compareValues(a, b); c != 0 {
+				return c < 0
+			}
+		}
+		return keyLess(results[i].key, results[j].key)
+	})
+
+	it := &fakeIterator{keysOnly: q.FieldByName("keysOnly").Bool()}
+	for p := 0; p < projection.Len(); p++ {
+		it.projection = append(it.projection, projection.Index(p).String())
+	}
+	if q.FieldByName("distinct").Bool() {
+		results = distinctResults(results, it.projection)
+	}
+
+	if start := q.FieldByName("start").Bytes(); len(start) > 0 {
+		offset, err := strconv.Atoi(string(start))
+		if err != nil {
+			return &fakeIterator{err: err}
+		}
+		it.position = offset
+	}
+	it.end = len(results)
+	if limit := int(q.FieldByName("limit").Int()); limit >= 0 && it.position+limit < it.end {
+		it.end = it.position + limit
+	}
+	it.results = results
+
+	return it
+}
+
+// fakeIterator iterates over the results of a query run by a fakeStore.
+type fakeIterator struct {
+	results    []fakeEntity
+	position   int
+	end        int
+	keysOnly   bool
+	projection []string
+	err        error
+}
+
+func (it *fakeIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if it.position >= it.end {
+		return nil, iterator.Done
+	}
+
+	entity := it.results[it.position]
+	it.position++
+
+	switch {
+	case it.keysOnly:
+	case len(it.projection) > 0:
+		props := dst.(*datastore.PropertyList)
+		for _, name := range it.projection {
+			*props = append(*props, datastore.Property{
+				Name:  name,
+				Value: entity.value.FieldByName(name).Interface(),
+			})
+		}
+	default:
+		reflect.ValueOf(dst).Elem().Set(entity.value)
+	}
+
+	return entity.key, nil
+}
+
+func (it *fakeIterator) Cursor() (datastore.Cursor, error) {
+	// The cursor holds the position of the next result, which is all a
+	// fakeStore needs to resume a query
+	position := []byte(strconv.Itoa(it.position))
+	return datastore.DecodeCursor(base64.URLEncoding.EncodeToString(position))
+}
+
+// checkProjection returns an error if a projected property is also filtered
+// on by equality, which Datastore doesn't allow.
+func checkProjection(projection reflect.Value, filters reflect.Value) error {
+	for p := 0; p < projection.Len(); p++ {
+		for i := 0; i < filters.Len(); i++ {
+			filter := filters.Index(i).Elem()
+			operator := filter.FieldByName("Operator").String()
+			name := filter.FieldByName("FieldName").String()
+			if name == projection.Index(p).String() && (operator == "=" || operator == "in") {
+				return fmt.Errorf("fake store: can't project on %v, which is filtered by equality", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesFilters returns true if the entity matches every property filter.
+func matchesFilters(entity reflect.Value, filters reflect.Value) bool {
+	for i := 0; i < filters.Len(); i++ {
+		filter := filters.Index(i).Elem()
+		field := entity.FieldByName(filter.FieldByName("FieldName").String())
+		if !field.IsValid() {
+			return false
+		}
+
+		value := filter.FieldByName("Value").Elem()
+		operator := filter.FieldByName("Operator").String()
+
+		if operator == "in" {
+			found := false
+			for j := 0; j < value.Len(); j++ {
+				if c, ok := compareValues(field, value.Index(j).Elem()); ok && c == 0 {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+			continue
+		}
+
+		c, ok := compareValues(field, value)
+		if !ok {
+			return false
+		}
+		var matches bool
+		switch operator {
+		case "=":
+			matches = c == 0
+		case "!=":
+			matches = c != 0
+		case "<":
+			matches = c < 0
+		case "<=":
+			matches = c <= 0
+		case ">":
+			matches = c > 0
+		case ">=":
+			matches = c >= 0
+		default:
+			panic("fake store: unsupported operator " + operator)
+		}
+		if !matches {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareValues compares two strings or two integers, returning false if the
+// values aren't comparable.
+func compareValues(a, b reflect.Value) (int, bool) {
+	switch {
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		switch {
+		case a.String() < b.String():
+			return -1, true
+		case a.String() > b.String():
+			return 1, true
+		}
+		return 0, true
+	case isInt(a) && isInt(b):
+		switch {
+		case a.Int() < b.Int():
+			return -1, true
+		case a.Int() > b.Int():
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// distinctResults drops results whose projected properties are the same as
+// the result before them. The results must be sorted by those properties.
+func distinctResults(results []fakeEntity, projection []string) []fakeEntity {
+	var distinct []fakeEntity
+	for i, result := range results {
+		if i > 0 && sameProjection(results[i-1], result, projection) {
+			continue
+		}
+		distinct = append(distinct, result)
+	}
+
+	return distinct
+}
+
+func sameProjection(a, b fakeEntity, projection []string) bool {
+	for _, name := range projection {
+		if c, _ := compareValues(a.value.FieldByName(name), b.value.FieldByName(name)); c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func keysEqual(a, b *datastore.Key) bool {
+	return a.Kind == b.Kind && a.ID == b.ID && a.Name == b.Name
+}
+
+// keyLess orders keys the way Datastore does, with numeric IDs before names.
+func keyLess(a, b *datastore.Key) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if (a.Name == "") != (b.Name == "") {
+		return a.Name == ""
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.ID < b.ID
+}
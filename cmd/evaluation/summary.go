@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// summaryDateLayout is the layout of the dates DailySummary entities are
+// keyed by.
+const summaryDateLayout = "2006-01-02"
+
+// maxPutBatchSize is the most entities Datastore will write in one PutMulti
+// call.
+const maxPutBatchSize = 500
+
+// eventDate returns the day, as YYYY-MM-DD in the given time zone, that the
+// event with the timestamp happened on.
+func eventDate(timestamp int64, location *time.Location) string {
+	return millisToTime(timestamp).In(location).Format(summaryDateLayout)
+}
+
+// daySessions counts the sessions that started on a day.
+type daySessions struct {
+	sessions       int
+	editorSessions int
+}
+
+// newDailySummaries combines the error counts and session counts of each day
+// into summaries, ordered by date.
+func newDailySummaries(errorCnts map[string]map[string]int, sessions map[string]daySessions, updatedAt int64) []datatypes.DailySummary {
+	dates := make(map[string]bool)
+	for date := range errorCnts {
+		dates[date] = true
+	}
+	for date := range sessions {
+		dates[date] = true
+	}
+
+	var summaries []datatypes.DailySummary
+	for date := range dates {
+		summary := datatypes.DailySummary{
+			Date:         date,
+			SessionCount: sessions[date].sessions,
+			UpdatedAt:    updatedAt,
+		}
+		if summary.SessionCount > 0 {
+			summary.EditorUseRatio = float64(sessions[date].editorSessions) / float64(summary.SessionCount)
+		}
+		for _, info := range sortedErrorTypeCounts(errorCnts[date]) {
+			summary.ErrorCounts = append(summary.ErrorCounts, datatypes.ErrorTypeCount{
+				Type:  info.errorType,
+				Count: info.count,
+			})
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Date < summaries[j].Date
+	})
+
+	return summaries
+}
+
+// writeDailySummaries stores the summaries in Datastore, keyed by their date
+// so that summaries from an earlier run are overwritten.
+func writeDailySummaries(ctx context.Context, client store, summaries []datatypes.DailySummary) error {
+	for start := 0; start < len(summaries); start += maxPutBatchSize {
+		end := start + maxPutBatchSize
+		if end > len(summaries) {
+			end = len(summaries)
+		}
+
+		batch := summaries[start:end]
+		keys := make([]*datastore.Key, len(batch))
+		for i, summary := range batch {
+			keys[i] = datastore.NameKey(datatypes.DailySummaryKind, summary.Date, nil)
+		}
+
+		err := withRetry(ctx, func() error {
+			_, err := client.PutMulti(ctx, keys, batch)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("writing summaries from %v: %v", batch[0].Date, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestWriteDailySummaries(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeStore()
+
+	errorCnts := map[string]map[string]int{
+		"2020-01-01": {"TooManyArguments": 2, unclassifiedErrorType: 1},
+		"2020-01-02": {"VariableHasNoValue": 1},
+	}
+	sessions := map[string]daySessions{
+		"2020-01-01": {sessions: 4, editorSessions: 1},
+		"2020-01-03": {sessions: 2},
+	}
+	summaries := newDailySummaries(errorCnts, sessions, 1000)
+	if err := writeDailySummaries(ctx, client, summaries); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored datatypes.DailySummary
+	if err := client.Get(ctx, datastore.NameKey(datatypes.DailySummaryKind, "2020-01-01", nil), &stored); err != nil {
+		t.Fatal(err)
+	}
+	want := datatypes.DailySummary{
+		Date: "2020-01-01",
+		ErrorCounts: []datatypes.ErrorTypeCount{
+			{Type: "TooManyArguments", Count: 2},
+			{Type: unclassifiedErrorType, Count: 1},
+		},
+		SessionCount:   4,
+		EditorUseRatio: 0.25,
+		UpdatedAt:      1000,
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored summary = %+v, want %+v", stored, want)
+	}
+
+	var all []datatypes.DailySummary
+	if _, err := client.GetAll(ctx, datastore.NewQuery(datatypes.DailySummaryKind), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("stored %v summaries, want one per day", len(all))
+	}
+
+	// Writing a day again overwrites its summary
+	rerun := newDailySummaries(nil, map[string]daySessions{"2020-01-01": {sessions: 5}}, 2000)
+	if err := writeDailySummaries(ctx, client, rerun); err != nil {
+		t.Fatal(err)
+	}
+	all = nil
+	if _, err := client.GetAll(ctx, datastore.NewQuery(datatypes.DailySummaryKind), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("stored %v summaries after rerun, want 3", len(all))
+	}
+	if err := client.Get(ctx, datastore.NameKey(datatypes.DailySummaryKind, "2020-01-01", nil), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.SessionCount != 5 || stored.UpdatedAt != 2000 {
+		t.Errorf("summary wasn't overwritten: %+v", stored)
+	}
+}
+
+func TestEventDate(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// 2020-01-02 03:00 UTC is still the 1st in New York
+	timestamp := time.Date(2020, 1, 2, 3, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	if got := eventDate(timestamp, time.UTC); got != "2020-01-02" {
+		t.Errorf("eventDate() in UTC = %v, want 2020-01-02", got)
+	}
+	if got := eventDate(timestamp, location); got != "2020-01-01" {
+		t.Errorf("eventDate() in New York = %v, want 2020-01-01", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// variableTokenPattern matches atoms that could name a variable, as opposed to
+// numbers and other literals.
+var variableTokenPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_\-?!*]*$`)
+
+// commandVariables returns the atoms in a REPL command that look like
+// variable names. Atoms right after an opening parenthesis are the callable
+// and aren't counted, and neither is anything inside a string literal. A
+// malformed command still has the variables before the problem returned.
+func commandVariables(command string) []string {
+	tokens, _ := tokenizeCommand(command)
+
+	var variables []string
+	for i, tok := range tokens {
+		if tok.kind != atomToken || (i > 0 && tokens[i-1].kind == openToken) {
+			continue
+		}
+		if variableTokenPattern.MatchString(tok.text) {
+			variables = append(variables, tok.text)
+		}
+	}
+
+	return variables
+}
+
+// distinctVariables estimates how many different variables the session
+// worked with, as the number of distinct names either referenced by its
+// commands or captured from its VariableHasNoValue errors. Names are compared
+// case-insensitively.
+func (u *session) distinctVariables() int {
+	pattern := findErrPattern("VariableHasNoValue")
+
+	names := make(map[string]bool)
+	for _, e := range u.events {
+		switch e := e.(type) {
+		case replEvent:
+			for _, name := range commandVariables(e.Command) {
+				names[strings.ToLower(name)] = true
+			}
+		case errorEvent:
+			if pattern == nil {
+				continue
+			}
+			if match := pattern.FindStringSubmatch(e.Description); len(match) > 1 {
+				names[strings.ToLower(match[1])] = true
+			}
+		}
+	}
+
+	return len(names)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestCommandVariables(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"(fire)", nil},
+		{"(set-speed 10)", nil},
+		{"(set-speed speed)", []string{"speed"}},
+		{"(define speed 10)", []string{"speed"}},
+		{"(set-speed (+ speed boost))", []string{"speed", "boost"}},
+		{"(if ready? (fire) (wait))", []string{"ready?"}},
+		// Neither literals nor the insides of strings are variables
+		{`(print "speed is" speed)`, []string{"speed"}},
+		{"(set-speed 1.5 -3)", nil},
+		// A malformed command still has the variables before the problem
+		{"(set-speed speed", []string{"speed"}},
+		{"speed", []string{"speed"}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			if got := commandVariables(test.command); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("commandVariables(%q) = %q, want %q", test.command, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDistinctVariables(t *testing.T) {
+	// The session works with speed, angle, boost and fuel
+	sess := session{uid: "player", events: []event{
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 1000, Command: "(define speed 10)"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 2000, Command: "(set-speed (+ speed boost))"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 2000, Description: "Variable boost has no value"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 3000, Command: "(rotate ANGLE)"}),
+		replEvent(datatypes.REPLCommand{UID: "player", Timestamp: 4000, Command: `(print "fuel")`}),
+		// A variable can be found only from an error
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 5000, Description: "Variable fuel has no value"}),
+		errorEvent(datatypes.ErrorInstance{UID: "player", Timestamp: 6000, Description: "Variable Angle has no value"}),
+		editorEvent(datatypes.EditorContent{UID: "player", Timestamp: 7000, Content: "(define thrust 5)"}),
+	}}
+	if got := sess.distinctVariables(); got != 4 {
+		t.Errorf("distinctVariables() = %v, want 4", got)
+	}
+
+	none := session{uid: "player", events: commandEvents("player", "(fire)", "(set-speed 10)")}
+	if got := none.distinctVariables(); got != 0 {
+		t.Errorf("without variables, distinctVariables() = %v, want 0", got)
+	}
+}
+
+func TestVariablesPerSession(t *testing.T) {
+	var report Report
+	aggregator := newTestAggregator(&report)
+	for i, sess := range []session{
+		{uid: "player", events: commandEvents("player", "(set-speed speed)", "(rotate angle)", "(set-speed speed)")},
+		{uid: "player", events: commandEvents("player", "(define boost 1)")},
+		// Sessions without variables report zero
+		{uid: "player", events: commandEvents("player", "(fire)")},
+		{uid: "player"},
+	} {
+		aggregator.add(sess, i)
+	}
+	aggregator.finish()
+
+	var counts []int
+	for _, sess := range report.Sessions {
+		counts = append(counts, sess.DistinctVariables)
+	}
+	if want := []int{2, 1, 0, 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("distinct variables per session = %v, want %v", counts, want)
+	}
+	if got := report.VariablesPerSession; got == nil || got.Count != 4 || got.Median != 0.5 || got.Max != 2 {
+		t.Errorf("VariablesPerSession = %+v, want a median of 0.5 over 4 sessions", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envFloat returns the value of the environment variable as a number, or def
+// if it isn't set. An invalid value stops the server from starting so that a
+// misconfiguration doesn't go unnoticed.
+func envFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid value for %v: %v", name, err))
+	}
+
+	return parsed
+}
+
+// envDuration returns the value of the environment variable as a duration in
+// the format accepted by time.ParseDuration, or def if it isn't set. Like
+// envFloat, an invalid value stops the server from starting.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid value for %v: %v", name, err))
+	}
+
+	return parsed
+}
+
+// envPositiveFloat is like envFloat, but a value that isn't greater than zero
+// is also invalid.
+func envPositiveFloat(name string, def float64) float64 {
+	parsed := envFloat(name, def)
+	if parsed <= 0 {
+		panic(fmt.Sprintf("invalid value for %v: must be positive, got %v", name, parsed))
+	}
+
+	return parsed
+}
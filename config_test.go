@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnvPositiveFloat(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      float64
+		wantPanic bool
+	}{
+		{"", 10, false},
+		{"2.5", 2.5, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"fast", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			os.Setenv("TEST_RATE", test.value)
+			defer os.Unsetenv("TEST_RATE")
+
+			defer func() {
+				if r := recover(); (r != nil) != test.wantPanic {
+					t.Errorf("panic = %v, want panic: %v", r, test.wantPanic)
+				}
+			}()
+
+			if got := envPositiveFloat("TEST_RATE", 10); got != test.want {
+				t.Errorf("envPositiveFloat() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// corsMaxAge is how many seconds browsers may cache the result of a preflight
+// request.
+const corsMaxAge = 10 * 60
+
+// corsAllowedHeaders are the request headers browser clients may send.
+var corsAllowedHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	apiKeyHeader,
+	idempotencyKeyHeader,
+	requestIDHeader,
+}
+
+// loadAllowedOrigins returns the origins in the comma-separated
+// CORS_ALLOWED_ORIGINS environment variable. If none are set, no cross-origin
+// requests are allowed.
+func loadAllowedOrigins() map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+
+	return origins
+}
+
+// cors is a middleware handler which lets browsers on the allowed origins
+// make POST requests. Preflight requests are answered directly, since
+// browsers don't send credentials like the API key with them, so cors must
+// wrap requireAPIKey and postOnly rather than the other way around. Requests
+// from other origins are rejected, and requests without an Origin header, like
+// those from the game, are passed through unchanged.
+func cors(allowedOrigins map[string]bool, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				main.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !allowedOrigins[origin] {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "POST")
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			// Let scripts see when they're being rate limited
+			w.Header().Set("Access-Control-Expose-Headers", "Retry-After, "+requestIDHeader)
+			main.ServeHTTP(w, r)
+		},
+	)
+}
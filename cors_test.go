@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	os.Setenv("CORS_ALLOWED_ORIGINS", "https://play.example.com, https://beta.example.com")
+	defer os.Unsetenv("CORS_ALLOWED_ORIGINS")
+
+	var reached int
+	handler := cors(loadAllowedOrigins(), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { reached++ },
+	))
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		preflight     bool
+		want          int
+		wantReached   bool
+		wantAllowedTo string
+	}{
+		{"preflight from allowed origin", "OPTIONS", "https://play.example.com", true, http.StatusNoContent, false, "https://play.example.com"},
+		{"preflight from disallowed origin", "OPTIONS", "https://evil.example.com", true, http.StatusForbidden, false, ""},
+		{"POST from allowed origin", "POST", "https://beta.example.com", false, http.StatusOK, true, "https://beta.example.com"},
+		{"POST from disallowed origin", "POST", "https://evil.example.com", false, http.StatusForbidden, false, ""},
+		{"POST without origin", "POST", "", false, http.StatusOK, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reached = 0
+			r := httptest.NewRequest(test.method, "/error", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				r.Header.Set("Access-Control-Request-Method", "POST")
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.wantAllowedTo {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, test.wantAllowedTo)
+			}
+			if test.origin != "" && w.Header().Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", w.Header().Get("Vary"))
+			}
+			if test.preflight && test.want == http.StatusNoContent {
+				if got := w.Header().Get("Access-Control-Allow-Methods"); got != "POST" {
+					t.Errorf("Access-Control-Allow-Methods = %q, want POST", got)
+				}
+				if w.Header().Get("Access-Control-Allow-Headers") == "" {
+					t.Error("Access-Control-Allow-Headers is missing")
+				}
+			}
+			if reached := reached > 0; reached != test.wantReached {
+				t.Errorf("request reached the wrapped handler: %v, want %v", reached, test.wantReached)
+			}
+		})
+	}
+}
@@ -1,25 +1,186 @@
 package datatypes
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrMissingUID is returned by Validate when an event has no UID.
+var ErrMissingUID = errors.New("uid must not be empty")
+
 const REPLCommandKind = "REPLCommand"
 
 type REPLCommand struct {
-	UID       string `json:"uid"`
-	Timestamp int64  `json:"timestamp"`
-	Command   string `json:"command"`
+	UID         string `json:"uid"`
+	SessionID   string `json:"sessionId"`
+	GameVersion string `json:"gameVersion"`
+	Platform    string `json:"platform"`
+	Timestamp   int64  `json:"timestamp"`
+	// Command isn't indexed because it can be longer than the 1500 bytes
+	// Datastore allows for indexed strings.
+	Command string `json:"command" datastore:",noindex"`
+	// Result is what the command returned, or empty if the client didn't
+	// send it. Older clients don't.
+	Result string `json:"result" datastore:",noindex"`
+	// ReceivedAt is when the server received the event, in Unix
+	// milliseconds. It's set by the server, and is zero for events stored
+	// before it was added.
+	ReceivedAt int64 `json:"receivedAt"`
+}
+
+// DefaultMaxCommandLength is the default for MaxCommandLength.
+const DefaultMaxCommandLength = 4096
+
+// MaxCommandLength is the longest REPL command, in runes, that Validate
+// accepts.
+var MaxCommandLength = DefaultMaxCommandLength
+
+// Validate returns an error if the REPL command is not fit to be stored.
+func (c REPLCommand) Validate() error {
+	if err := ValidateUID(c.UID); err != nil {
+		return err
+	}
+
+	if length := utf8.RuneCountInString(c.Command); length > MaxCommandLength {
+		return fmt.Errorf("command is %v characters long, the limit is %v", length, MaxCommandLength)
+	}
+
+	return nil
 }
 
 const EditorContentKind = "EditorContent"
 
 type EditorContent struct {
-	UID       string `json:"uid"`
-	Timestamp int64  `json:"timestamp"`
-	Content   string `json:"content"`
+	UID         string `json:"uid"`
+	SessionID   string `json:"sessionId"`
+	GameVersion string `json:"gameVersion"`
+	Platform    string `json:"platform"`
+	Timestamp   int64  `json:"timestamp"`
+	Content     string `json:"content"`
+	// ReceivedAt is when the server received the event, in Unix
+	// milliseconds. It's set by the server, and is zero for events stored
+	// before it was added.
+	ReceivedAt int64 `json:"receivedAt"`
+}
+
+// Validate returns an error if the editor content is not fit to be stored.
+func (c EditorContent) Validate() error {
+	return ValidateUID(c.UID)
 }
 
 const ErrorInstanceKind = "Error"
 
 type ErrorInstance struct {
 	UID         string `json:"uid"`
+	SessionID   string `json:"sessionId"`
+	GameVersion string `json:"gameVersion"`
+	Platform    string `json:"platform"`
 	Timestamp   int64  `json:"timestamp"`
 	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	// ReceivedAt is when the server received the event, in Unix
+	// milliseconds. It's set by the server, and is zero for events stored
+	// before it was added.
+	ReceivedAt int64 `json:"receivedAt"`
+}
+
+// Validate returns an error if the error instance is not fit to be stored.
+// An empty severity is valid and means DefaultSeverity.
+func (e ErrorInstance) Validate() error {
+	if err := ValidateUID(e.UID); err != nil {
+		return err
+	}
+
+	if e.Severity != "" {
+		if _, ok := SeverityRank(e.Severity); !ok {
+			return fmt.Errorf("unknown severity %q", e.Severity)
+		}
+	}
+
+	return nil
+}
+
+// EffectiveSeverity returns the severity of the error, which is
+// DefaultSeverity if none was given.
+func (e ErrorInstance) EffectiveSeverity() string {
+	if e.Severity == "" {
+		return DefaultSeverity
+	}
+
+	return e.Severity
+}
+
+const DailySummaryKind = "DailySummary"
+
+// DailySummary holds aggregates of the events of a single day, as computed by
+// the evaluation tool. Summaries are keyed by their date, so recomputing a
+// day overwrites its summary.
+type DailySummary struct {
+	// Date is the day summarized, as YYYY-MM-DD
+	Date           string           `json:"date"`
+	ErrorCounts    []ErrorTypeCount `json:"errorCounts"`
+	SessionCount   int              `json:"sessionCount"`
+	EditorUseRatio float64          `json:"editorUseRatio"`
+	// UpdatedAt is when the summary was computed, in Unix milliseconds
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// ErrorTypeCount is the number of errors of a type in a DailySummary.
+type ErrorTypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// ErrorTypeAliases maps error types that have been renamed to their current
+// names. Summaries stored before a rename still use the old name.
+var ErrorTypeAliases = map[string]string{
+	"ArugmentMustBeOfType": "ArgumentMustBeOfType",
+}
+
+// CanonicalErrorType returns the current name of an error type, which is the
+// name itself unless it's in ErrorTypeAliases.
+func CanonicalErrorType(name string) string {
+	if canonical, ok := ErrorTypeAliases[name]; ok {
+		return canonical
+	}
+
+	return name
+}
+
+// Error severities, from least to most severe.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityFatal   = "fatal"
+)
+
+// DefaultSeverity is the severity of errors that weren't given one.
+const DefaultSeverity = SeverityWarning
+
+// severities are the valid severities in increasing order of severity.
+var severities = []string{SeverityInfo, SeverityWarning, SeverityFatal}
+
+// SeverityRank returns the position of the severity in the order of
+// severities, where more severe errors have a higher rank. It returns false if
+// the severity isn't valid.
+func SeverityRank(severity string) (int, bool) {
+	for i, s := range severities {
+		if s == severity {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// ValidateUID checks that a UID is present. A UID made up entirely of
+// whitespace is treated as missing.
+func ValidateUID(uid string) error {
+	if strings.TrimSpace(uid) == "" {
+		return ErrMissingUID
+	}
+
+	return nil
 }
@@ -0,0 +1,96 @@
+package datatypes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestREPLCommandValidateLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{"at limit", strings.Repeat("a", MaxCommandLength), false},
+		{"over limit", strings.Repeat("a", MaxCommandLength+1), true},
+		// Each of these is 2 bytes, so the command is over the limit in
+		// bytes but not in runes
+		{"multibyte at limit", strings.Repeat("λ", MaxCommandLength), false},
+		{"multibyte over limit", strings.Repeat("λ", MaxCommandLength+1), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command := REPLCommand{UID: "uid", Command: test.command}
+			err := command.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSeverityRank(t *testing.T) {
+	info, infoOK := SeverityRank(SeverityInfo)
+	warning, warningOK := SeverityRank(SeverityWarning)
+	fatal, fatalOK := SeverityRank(SeverityFatal)
+	if !infoOK || !warningOK || !fatalOK {
+		t.Fatalf("a built-in severity is invalid")
+	}
+	if !(info < warning && warning < fatal) {
+		t.Errorf("ranks are info %v, warning %v, fatal %v, want them increasing", info, warning, fatal)
+	}
+
+	for _, severity := range []string{"", "Fatal", "error"} {
+		if _, ok := SeverityRank(severity); ok {
+			t.Errorf("SeverityRank(%q) is valid", severity)
+		}
+	}
+}
+
+func TestErrorInstanceSeverity(t *testing.T) {
+	tests := []struct {
+		severity      string
+		wantErr       bool
+		wantEffective string
+	}{
+		{"", false, SeverityWarning},
+		{SeverityInfo, false, SeverityInfo},
+		{SeverityWarning, false, SeverityWarning},
+		{SeverityFatal, false, SeverityFatal},
+		{"crash", true, "crash"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.severity, func(t *testing.T) {
+			instance := ErrorInstance{UID: "uid", Severity: test.severity}
+			if err := instance.Validate(); (err != nil) != test.wantErr {
+				t.Errorf("Validate() = %v, want error: %v", err, test.wantErr)
+			}
+			if got := instance.EffectiveSeverity(); got != test.wantEffective {
+				t.Errorf("EffectiveSeverity() = %q, want %q", got, test.wantEffective)
+			}
+		})
+	}
+}
+
+func TestCanonicalErrorType(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ArgumentMustBeOfType", "ArgumentMustBeOfType"},
+		// Summaries stored before the rename use the misspelling
+		{"ArugmentMustBeOfType", "ArgumentMustBeOfType"},
+		{"TooManyArguments", "TooManyArguments"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CanonicalErrorType(test.name); got != test.want {
+				t.Errorf("CanonicalErrorType(%q) = %q, want %q", test.name, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "net/http"
+
+// newHealthzHandler responds that the server is alive.
+func newHealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// newReadyzHandler responds that the server is ready to handle requests if it
+// can reach Datastore.
+func newReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := newContext(r)
+	if err := store.ping(ctx); err != nil {
+		logger.Errorf(ctx, "readiness check failed: %v", err)
+		http.Error(w, "Datastore is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok\n"))
+}
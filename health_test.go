@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	fake := useFakeStore(t)
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		newReadyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	if got := readyz(); got != http.StatusOK {
+		t.Errorf("status with a reachable store = %v, want %v", got, http.StatusOK)
+	}
+
+	fake.err = errors.New("unavailable")
+	if got := readyz(); got != http.StatusServiceUnavailable {
+		t.Errorf("status with an unreachable store = %v, want %v", got, http.StatusServiceUnavailable)
+	}
+
+	// Liveness doesn't depend on the store
+	w := httptest.NewRecorder()
+	newHealthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	fake.err = nil
+	if got := readyz(); got != http.StatusOK {
+		t.Errorf("status once the store recovered = %v, want %v", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock replaces now for the duration of a test with a clock that only
+// moves when advanced.
+type fakeClock struct {
+	current time.Time
+}
+
+// newFakeClock installs a fake clock, restoring the real one when the test
+// finishes.
+func newFakeClock(t testing.TB) *fakeClock {
+	clock := &fakeClock{current: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	realNow := now
+	now = func() time.Time { return clock.current }
+	t.Cleanup(func() { now = realNow })
+
+	return clock
+}
+
+// advance moves the clock forward.
+func (c *fakeClock) advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
+
+// fakeKey is the key of an event in a fakeStore.
+type fakeKey struct {
+	kind string
+	name string
+	id   int64
+}
+
+func (k fakeKey) Encode() string {
+	if k.name != "" {
+		return k.kind + "/" + k.name
+	}
+	return fmt.Sprintf("%v/%v", k.kind, k.id)
+}
+
+// fakeStore is an in-memory eventStore for tests.
+type fakeStore struct {
+	mutex  sync.Mutex
+	events map[fakeKey]event
+	nextID int64
+	// failUIDs are the UIDs of events that fail to be written
+	failUIDs map[string]bool
+	// undeletableKinds are the kinds of events that fail to be deleted
+	undeletableKinds map[string]bool
+	// deleteBatches is the number of keys in each call to deleteMulti
+	deleteBatches []int
+	// err, if set, fails every call
+	err error
+}
+
+// useFakeStore runs the handlers against a new fakeStore for the duration of
+// a test, with their logs written to the test's log.
+func useFakeStore(t testing.TB) *fakeStore {
+	fake := &fakeStore{
+		events:           make(map[fakeKey]event),
+		failUIDs:         make(map[string]bool),
+		undeletableKinds: make(map[string]bool),
+	}
+
+	realContext, realStore, realLogger := newContext, store, logger
+	newContext = (*http.Request).Context
+	store = fake
+	logger = testLogger{t}
+	t.Cleanup(func() {
+		newContext, store, logger = realContext, realStore, realLogger
+	})
+
+	return fake
+}
+
+// stored returns copies of the stored events of the kind, in the order they
+// were stored.
+func (s *fakeStore) stored(kind string) []event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var keys []fakeKey
+	for key := range s.events {
+		if key.kind == kind {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	events := make([]event, len(keys))
+	for i, key := range keys {
+		events[i] = s.events[key]
+	}
+	return events
+}
+
+// copyEvent returns a copy of the event, so that the handler changing its
+// event after storing it doesn't change what's stored.
+func copyEvent(content event) event {
+	copied := newEventLike(content)
+	reflect.ValueOf(copied).Elem().Set(reflect.ValueOf(content).Elem())
+	return copied
+}
+
+// newKey returns a key for a new event of the kind. The mutex must be held.
+func (s *fakeStore) newKey(kind string) fakeKey {
+	s.nextID++
+	return fakeKey{kind: kind, id: s.nextID}
+}
+
+func (s *fakeStore) put(ctx context.Context, kind string, content event) (storeKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.failUIDs[eventUID(content)] {
+		return nil, errors.New("fake store: write failed")
+	}
+
+	key := s.newKey(kind)
+	s.events[key] = copyEvent(content)
+	return key, nil
+}
+
+func (s *fakeStore) putIfAbsent(ctx context.Context, kind, name string, content event) (event, storeKey, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, nil, false, s.err
+	}
+
+	key := fakeKey{kind: kind, name: name}
+	if existing, ok := s.events[key]; ok {
+		return copyEvent(existing), key, false, nil
+	}
+	s.events[key] = copyEvent(content)
+	return content, key, true, nil
+}
+
+func (s *fakeStore) putMulti(ctx context.Context, kinds []string, contents []event) ([]error, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var errs []error
+	for i, content := range contents {
+		if s.failUIDs[eventUID(content)] {
+			if errs == nil {
+				errs = make([]error, len(contents))
+			}
+			errs[i] = errors.New("fake store: write failed")
+			continue
+		}
+		s.events[s.newKey(kinds[i])] = copyEvent(content)
+	}
+
+	return errs, nil
+}
+
+func (s *fakeStore) getAll(ctx context.Context, kind, uid string, dst interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	slice := reflect.ValueOf(dst).Elem()
+	for _, content := range s.stored(kind) {
+		if eventUID(content) == uid {
+			slice.Set(reflect.Append(slice, reflect.ValueOf(content).Elem()))
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) keys(ctx context.Context, kind, uid string) ([]storeKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var keys []storeKey
+	for key, content := range s.events {
+		if key.kind == kind && eventUID(content) == uid {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *fakeStore) deleteMulti(ctx context.Context, keys []storeKey) ([]error, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.deleteBatches = append(s.deleteBatches, len(keys))
+
+	var errs []error
+	for i, key := range keys {
+		key := key.(fakeKey)
+		if s.undeletableKinds[key.kind] {
+			if errs == nil {
+				errs = make([]error, len(keys))
+			}
+			errs[i] = errors.New("fake store: delete failed")
+			continue
+		}
+		delete(s.events, key)
+	}
+	return errs, nil
+}
+
+func (s *fakeStore) ping(ctx context.Context) error {
+	return s.err
+}
+
+// testLogger writes the logs of the handlers to a test's log.
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.t.Logf("INFO: "+format, args...)
+}
+
+func (l testLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	l.t.Logf("WARNING: "+format, args...)
+}
+
+func (l testLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	l.t.Logf("ERROR: "+format, args...)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// ndjsonMediaType is the Content-Type of newline-delimited JSON bodies.
+const ndjsonMediaType = "application/x-ndjson"
+
+// maxIngestLines is the most lines accepted in a single NDJSON body.
+const maxIngestLines = 10000
+
+// maxIngestLineBytes is the longest line accepted in an NDJSON body.
+const maxIngestLineBytes = 1024 * 1024
+
+// ingestKinds are the values of the "kind" field of each line of an NDJSON
+// body, and the Datastore kind and a new event of that kind for each.
+var ingestKinds = map[string]struct {
+	kind     string
+	newEvent func() event
+}{
+	"replCommand":   {datatypes.REPLCommandKind, func() event { return &datatypes.REPLCommand{} }},
+	"editorContent": {datatypes.EditorContentKind, func() event { return &datatypes.EditorContent{} }},
+	"error":         {datatypes.ErrorInstanceKind, func() event { return &datatypes.ErrorInstance{} }},
+}
+
+// ingestLine is the part of an NDJSON line that says what kind of event it is.
+type ingestLine struct {
+	Kind string `json:"kind"`
+}
+
+// ingestResponse reports how many lines of an NDJSON body were stored and
+// which ones could not be.
+type ingestResponse struct {
+	Stored int `json:"stored"`
+	// Failed are the lines that couldn't be parsed, were invalid, or
+	// couldn't be written
+	Failed []ingestFailure `json:"failed,omitempty"`
+	// Error, if not empty, is why the body stopped being read. Lines before
+	// that are still stored
+	Error string `json:"error,omitempty"`
+}
+
+// ingestFailure describes a line of an NDJSON body that could not be stored.
+type ingestFailure struct {
+	// Line is the line number, counting from 1
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// newIngestHandler stores the events in a newline-delimited JSON body, where
+// each line is an event with a "kind" field of "replCommand", "editorContent"
+// or "error". The body is read as a stream, and events are written in chunks
+// of maxBatchSize, so only one chunk is held in memory at a time. Blank lines
+// are skipped.
+func newIngestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := newContext(r)
+
+	var resp ingestResponse
+	var chunk []batchEntry
+	flush := func() {
+		resp.Stored += storeIngestChunk(ctx, chunk, &resp.Failed)
+		chunk = chunk[:0]
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, maxIngestLineBytes)
+	status := http.StatusOK
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if lineNum > maxIngestLines {
+			resp.Error = fmt.Sprintf("bodies may contain at most %v lines", maxIngestLines)
+			status = http.StatusRequestEntityTooLarge
+			break
+		}
+
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		content, kind, err := decodeIngestLine(line)
+		if err != nil {
+			resp.Failed = append(resp.Failed, ingestFailure{lineNum, err.Error()})
+			continue
+		}
+		if err := prepareEvent(content); err != nil {
+			resp.Failed = append(resp.Failed, ingestFailure{lineNum, err.Error()})
+			continue
+		}
+
+		chunk = append(chunk, batchEntry{index: lineNum, kind: kind, content: content})
+		if len(chunk) == maxBatchSize {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warningf(ctx, "could not read NDJSON body: %v", err)
+		resp.Error = "could not read body: " + err.Error()
+		status = bodyErrorStatus(err)
+	}
+	flush()
+	sort.Slice(resp.Failed, func(i, j int) bool {
+		return resp.Failed[i].Line < resp.Failed[j].Line
+	})
+
+	logger.Infof(ctx, "Ingested %v events, %v failed", resp.Stored, len(resp.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
+
+// decodeIngestLine decodes a line of an NDJSON body into an event of the kind
+// it names, and returns the event along with its Datastore kind.
+func decodeIngestLine(line []byte) (event, string, error) {
+	var tagged ingestLine
+	if err := json.Unmarshal(line, &tagged); err != nil {
+		return nil, "", fmt.Errorf("malformed JSON: %v", err)
+	}
+
+	info, ok := ingestKinds[tagged.Kind]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown kind %q", tagged.Kind)
+	}
+
+	content := info.newEvent()
+	if err := json.Unmarshal(line, content); err != nil {
+		return nil, "", fmt.Errorf("invalid %v: %v", tagged.Kind, err)
+	}
+
+	return content, info.kind, nil
+}
+
+// storeIngestChunk writes a chunk of events from an NDJSON body, adding the
+// lines that couldn't be written to failed, and returns how many were.
+func storeIngestChunk(ctx context.Context, chunk []batchEntry, failed *[]ingestFailure) int {
+	putErrs, err := putEntries(ctx, chunk)
+	if err != nil {
+		logger.Errorf(ctx, "could not write NDJSON chunk to datastore: %v", err)
+	}
+
+	var stored []batchEntry
+	for i, entry := range chunk {
+		if err != nil || (putErrs != nil && putErrs[i] != nil) {
+			*failed = append(*failed, ingestFailure{entry.index, "could not save event"})
+			continue
+		}
+		stored = append(stored, entry)
+	}
+	recordStoredEvents(ctx, stored)
+
+	return len(stored)
+}
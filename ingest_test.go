@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestIngest(t *testing.T) {
+	newFakeClock(t)
+	store := useFakeStore(t)
+	store.failUIDs["unwritable"] = true
+	publisher := useFakePublisher(t)
+
+	body := strings.Join([]string{
+		`{"kind":"replCommand","uid":"a","command":"(help)"}`,
+		`{"kind":"editorContent","uid":"a","content":"(define x 1)"}`,
+		`{"kind":"error","uid":"a","description":"Too many arguments"}`,
+		`{"kind":"error","uid":"a"`,
+		``,
+		`{"kind":"spaceship","uid":"a"}`,
+		`{"kind":"replCommand"}`,
+		`{"kind":"replCommand","uid":"unwritable"}`,
+		`{"kind":"error","uid":"b"}`,
+	}, "\n")
+
+	r := httptest.NewRequest("POST", "/ingest", strings.NewReader(body))
+	r.Header.Set("Content-Type", ndjsonMediaType)
+	w := httptest.NewRecorder()
+	newIngestHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+	}
+	var resp ingestResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Stored != 4 {
+		t.Errorf("stored %v lines, want 4", resp.Stored)
+	}
+	var failedLines []int
+	for _, failure := range resp.Failed {
+		failedLines = append(failedLines, failure.Line)
+	}
+	// Blank lines are skipped, but still counted
+	if want := []int{4, 6, 7, 8}; !equalInts(failedLines, want) {
+		t.Errorf("failed lines %v, want %v", failedLines, want)
+	}
+
+	for kind, want := range map[string]int{
+		datatypes.REPLCommandKind:   1,
+		datatypes.EditorContentKind: 1,
+		datatypes.ErrorInstanceKind: 2,
+	} {
+		if got := len(store.stored(kind)); got != want {
+			t.Errorf("stored %v events of kind %v, want %v", got, kind, want)
+		}
+	}
+
+	// The stored lines are published as they'd be from their own endpoints
+	var publishedKinds []string
+	for _, message := range publisher.messages() {
+		publishedKinds = append(publishedKinds, message.kind)
+	}
+	wantKinds := []string{
+		datatypes.REPLCommandKind,
+		datatypes.EditorContentKind,
+		datatypes.ErrorInstanceKind,
+		datatypes.ErrorInstanceKind,
+	}
+	if strings.Join(publishedKinds, ",") != strings.Join(wantKinds, ",") {
+		t.Errorf("published kinds %v, want %v", publishedKinds, wantKinds)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// requestLogEntry is the structured log entry written for each request.
+type requestLogEntry struct {
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	RequestID string  `json:"requestId"`
+	UID       string  `json:"uid,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMS float64 `json:"latencyMs"`
+}
+
+// loggingResponseWriter wraps a http.ResponseWriter to capture what's needed
+// for the request's log entry.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	entry *requestLogEntry
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.entry.Status == 0 {
+		w.entry.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(data []byte) (int, error) {
+	if w.entry.Status == 0 {
+		w.entry.Status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// setLogUID records the UID a request is for in its log entry. It does
+// nothing if the request isn't being logged by logRequests.
+func setLogUID(w http.ResponseWriter, uid string) {
+	if w, ok := w.(*loggingResponseWriter); ok {
+		w.entry.UID = uid
+	}
+}
+
+// requestIDHeader is the header that identifies a request in both the
+// client's and the server's logs.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client. Longer
+// IDs are replaced by a generated one.
+const maxRequestIDLength = 128
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("could not generate request ID: " + err.Error())
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// isValidRequestID returns true if a request ID from a client is short enough
+// and only contains printable ASCII, so that it's safe to log and echo back.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// propagateRequestID is a middleware handler which makes sure every request
+// has an ID in its X-Request-ID header, generating one if the client didn't
+// send a valid one, and echoes it back on the response.
+func propagateRequestID(main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if !isValidRequestID(id) {
+				id = newRequestID()
+				r.Header.Set(requestIDHeader, id)
+			}
+			w.Header().Set(requestIDHeader, id)
+
+			main.ServeHTTP(w, r)
+		},
+	)
+}
+
+// logRequests is a middleware handler which writes a JSON log entry for every
+// request with its method, path, request ID, UID, status code and latency.
+func logRequests(main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			start := now()
+
+			entry := &requestLogEntry{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				RequestID: r.Header.Get(requestIDHeader),
+			}
+			main.ServeHTTP(&loggingResponseWriter{w, entry}, r)
+
+			if entry.Status == 0 {
+				// Nothing was written, which net/http sends as a 200
+				entry.Status = http.StatusOK
+			}
+			entry.LatencyMS = float64(now().Sub(start)) / float64(time.Millisecond)
+
+			ctx := newContext(r)
+			line, err := json.Marshal(entry)
+			if err != nil {
+				logger.Errorf(ctx, "could not encode request log entry: %v", err)
+				return
+			}
+			logger.Infof(ctx, "%s", line)
+		},
+	)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPropagateRequestID(t *testing.T) {
+	var seen string
+	handler := propagateRequestID(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { seen = r.Header.Get(requestIDHeader) },
+	))
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantEcho bool
+	}{
+		{"supplied", "client-request-1", true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"control characters", "bad\nid", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/error", nil)
+			if test.id != "" {
+				r.Header.Set(requestIDHeader, test.id)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			got := w.Header().Get(requestIDHeader)
+			if test.wantEcho && got != test.id {
+				t.Errorf("responded with ID %q, want the supplied %q", got, test.id)
+			}
+			if !test.wantEcho && !uuid.MatchString(got) {
+				t.Errorf("responded with ID %q, want a generated UUID", got)
+			}
+			if seen != got {
+				t.Errorf("handler saw ID %q, but %q was responded with", seen, got)
+			}
+		})
+	}
+
+	// Generated IDs are different for each request
+	if newRequestID() == newRequestID() {
+		t.Error("generated the same request ID twice")
+	}
+}
+
+// recordingLogger keeps the info logs written through it.
+type recordingLogger struct {
+	testLogger
+	infos []string
+}
+
+func (l *recordingLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func TestLogRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"status written", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}, http.StatusTeapot},
+		{"error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Not here", http.StatusNotFound)
+		}, http.StatusNotFound},
+		{"body only", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}, http.StatusOK},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK},
+		{"status written twice", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock(t)
+			useFakeStore(t)
+			recorder := &recordingLogger{testLogger: testLogger{t}}
+			logger = recorder
+
+			handler := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				setLogUID(w, "player")
+				clock.advance(1500 * time.Microsecond)
+				test.handler(w, r)
+			}))
+			r := httptest.NewRequest("POST", "/error", nil)
+			r.Header.Set(requestIDHeader, "request-1")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("responded with %v, want %v", w.Code, test.want)
+			}
+			if len(recorder.infos) != 1 {
+				t.Fatalf("wrote %v log entries, want 1", len(recorder.infos))
+			}
+			var entry requestLogEntry
+			if err := json.Unmarshal([]byte(recorder.infos[0]), &entry); err != nil {
+				t.Fatal(err)
+			}
+			want := requestLogEntry{
+				Method:    "POST",
+				Path:      "/error",
+				RequestID: "request-1",
+				UID:       "player",
+				Status:    test.want,
+				LatencyMS: 1.5,
+			}
+			if entry != want {
+				t.Errorf("logged %+v, want %+v", entry, want)
+			}
+		})
+	}
+}
@@ -3,93 +3,342 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/velovix/lambda-starship-user-stats/datatypes"
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 	_ "google.golang.org/appengine/remote_api"
 )
 
 func main() {
-	http.Handle("/repl-command", postOnly(newREPLCommandHandler))
-	http.Handle("/editor-content", postOnly(newEditorContentHandler))
-	http.Handle("/error", postOnly(newErrorHandler))
+	maxClockSkew = envDuration("MAX_CLOCK_SKEW", defaultMaxClockSkew)
+	datatypes.MaxCommandLength = int(envFloat("MAX_COMMAND_LENGTH", datatypes.DefaultMaxCommandLength))
+	putLatencies = newLatencyTracker(int(envFloat("LATENCY_BUFFER_SIZE", defaultLatencyBufferSize)))
+	apiKeys := loadAPIKeys()
+	eventPublisher = loadPublisher()
+	allowedOrigins := loadAllowedOrigins()
+	maxBodyBytes := int64(envFloat("MAX_BODY_BYTES", defaultMaxBodyBytes))
+	limiter := newRateLimiter(
+		envPositiveFloat("RATE_LIMIT", defaultRateLimit),
+		envPositiveFloat("RATE_LIMIT_BURST", defaultRateLimitBurst))
 
-	appengine.Main()
+	ingestAs := func(mediaType string, limit func(*rateLimiter, http.Handler) http.Handler, handler func(http.ResponseWriter, *http.Request)) http.Handler {
+		// The body is decompressed before it's limited, so the limit applies to
+		// the decompressed size
+		limited := decompressBody(limitBody(maxBodyBytes, limit(limiter, http.HandlerFunc(handler))))
+		return cors(allowedOrigins, requireAPIKey(apiKeys, postOnly(requireMediaType(mediaType, limited).ServeHTTP)))
+	}
+	ingest := func(handler func(http.ResponseWriter, *http.Request)) http.Handler {
+		return ingestAs(jsonMediaType, rateLimit, handler)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/repl-command", ingest(newREPLCommandHandler))
+	mux.Handle("/editor-content", ingest(newEditorContentHandler))
+	mux.Handle("/error", ingest(newErrorHandler))
+	mux.Handle("/batch", ingest(newBatchHandler))
+	// NDJSON bodies are streamed, so they're rate limited by address
+	// instead of being read up front for a UID
+	mux.Handle("/ingest", ingestAs(ndjsonMediaType, rateLimitByAddress, newIngestHandler))
+	mux.Handle("/user/", requireAPIKey(apiKeys, http.HandlerFunc(newUserHandler)))
+	mux.HandleFunc("/openapi.json", newOpenAPIHandler)
+	mux.HandleFunc("/stats", newStatsHandler)
+	mux.HandleFunc("/healthz", newHealthzHandler)
+	mux.HandleFunc("/readyz", newReadyzHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	http.Handle("/", propagateRequestID(logRequests(mux)))
+
+	if appengine.IsAppEngine() {
+		appengine.Main()
+	} else {
+		useStandaloneEnvironment()
+		serve(http.DefaultServeMux, envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout))
+	}
+}
+
+// now returns the current time. It's a variable so that tests can substitute
+// a fixed clock.
+var now = time.Now
+
+// defaultMaxClockSkew is the default for maxClockSkew.
+const defaultMaxClockSkew = 5 * time.Minute
+
+// maxClockSkew is how far in the future an event's timestamp may be before
+// it's rejected.
+var maxClockSkew = defaultMaxClockSkew
+
+// event is implemented by pointers to each of the types in datatypes that are
+// stored by the API.
+type event interface {
+	Validate() error
 }
 
-// newREPLCommandHandler stores a replCommand in datastore based on the data
-// from the request.
+// newREPLCommandHandler stores a datatypes.REPLCommand in datastore based on
+// the data from the request.
 func newREPLCommandHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+	storeEvent(w, r, datatypes.REPLCommandKind, "REPL command", &datatypes.REPLCommand{})
+}
 
-	var content datatypes.REPLCommand
-	json.NewDecoder(r.Body).Decode(&content)
+// newEditorContentHandler stores a datatypes.EditorContent in datastore based
+// on the data from the request.
+func newEditorContentHandler(w http.ResponseWriter, r *http.Request) {
+	storeEvent(w, r, datatypes.EditorContentKind, "editor content", &datatypes.EditorContent{})
+}
 
-	// Write to the datastore
-	key := datastore.NewKey(ctx, datatypes.REPLCommandKind, "", 0, nil)
-	if _, err := datastore.Put(ctx, key, &content); err != nil {
-		log.Errorf(ctx, "could not write to datastore: %v", err)
-		http.Error(w, "Could not save REPL command", 500)
+// newErrorHandler stores a datatypes.ErrorInstance in datastore based on the
+// data from the request.
+func newErrorHandler(w http.ResponseWriter, r *http.Request) {
+	storeEvent(w, r, datatypes.ErrorInstanceKind, "error", &datatypes.ErrorInstance{})
+}
+
+// storeEvent decodes the request body into content and, if it's valid, writes
+// it to datastore under the given kind. The description is a human-readable
+// name for the event used in logs and error messages.
+//
+// If the body is a JSON array instead of a single event, every event in it is
+// stored with storeEventArray.
+func storeEvent(w http.ResponseWriter, r *http.Request, kind, description string, content event) {
+	ctx := newContext(r)
+
+	isArray, err := peekJSONArray(r)
+	if err != nil {
+		logger.Warningf(ctx, "could not read %v: %v", description, err)
+		http.Error(w, "Invalid "+description+": "+err.Error(), bodyErrorStatus(err))
+		return
+	}
+	if isArray {
+		storeEventArray(w, r, kind, description, content)
+		return
+	}
+
+	if err := decodeBody(r, content); err != nil {
+		logger.Warningf(ctx, "could not decode %v: %v", description, err)
+		http.Error(w, "Invalid "+description+": "+err.Error(), bodyErrorStatus(err))
+		return
+	}
+	setLogUID(w, eventUID(content))
+
+	if err := prepareEvent(content); err != nil {
+		logger.Warningf(ctx, "rejecting %v: %v", description, err)
+		http.Error(w, "Invalid "+description+": "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Infof(ctx, "Saved REPL command %v", content)
+	// Write to the datastore
+	var key storeKey
+	created := true
+	start := now()
+	if idempotencyKey := r.Header.Get(idempotencyKeyHeader); idempotencyKey != "" {
+		if err := validateIdempotencyKey(idempotencyKey); err != nil {
+			http.Error(w, "Invalid idempotency key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	if _, err := w.Write([]byte{}); err != nil {
-		log.Errorf(ctx, "failed to send response: %v", err)
+		// Retries with the same key get back the event stored the first time
+		content, key, created, err = store.putIfAbsent(ctx, kind, idempotencyKey, content)
+	} else {
+		key, err = store.put(ctx, kind, content)
+	}
+	observePut(kind, start)
+	if err != nil {
+		logger.Errorf(ctx, "could not write to datastore: %v", err)
+		http.Error(w, "Could not save "+description, 500)
 		return
 	}
+	if created {
+		logger.Infof(ctx, "Saved %v %v", description, content)
+		recordStoredEvents(ctx, []batchEntry{{kind: kind, content: content}})
+	} else {
+		// The event was counted and published when it was first stored
+		logger.Infof(ctx, "Already saved %v %v", description, content)
+	}
+
+	writeStoredEvent(ctx, w, key, content)
 }
 
-// newEditorContentHandler stores an editorContent in datastore based on the
-// data from the request.
-func newEditorContentHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// idempotencyKeyHeader is the request header clients can use to make retried
+// requests only store an event once.
+const idempotencyKeyHeader = "Idempotency-Key"
 
-	var content datatypes.EditorContent
-	json.NewDecoder(r.Body).Decode(&content)
+// maxIdempotencyKeyLength is the longest idempotency key accepted.
+const maxIdempotencyKeyLength = 256
 
-	// Write to the datastore
-	key := datastore.NewKey(ctx, datatypes.EditorContentKind, "", 0, nil)
-	if _, err := datastore.Put(ctx, key, &content); err != nil {
-		log.Errorf(ctx, "could not write to datastore: %v", err)
-		http.Error(w, "Could not save editor content", 500)
-		return
+// validateIdempotencyKey returns an error if the idempotency key can't be used
+// as a Datastore key name.
+func validateIdempotencyKey(idempotencyKey string) error {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return fmt.Errorf("must be at most %v bytes", maxIdempotencyKeyLength)
 	}
+	if strings.HasPrefix(idempotencyKey, "__") {
+		// Datastore reserves these names
+		return errors.New("must not start with \"__\"")
+	}
+
+	return nil
+}
+
+// storedEventResponse is the response to a request that stored an event.
+type storedEventResponse struct {
+	// Key is the encoded Datastore key of the stored event
+	Key string `json:"key"`
+	// Record is the event as it was stored
+	Record event `json:"record"`
+}
 
-	log.Infof(ctx, "Saved editor content %v", content)
+// writeStoredEvent responds with the key and contents of a stored event.
+func writeStoredEvent(ctx context.Context, w http.ResponseWriter, key storeKey, content event) {
+	w.Header().Set("Content-Type", "application/json")
 
-	if _, err := w.Write([]byte{}); err != nil {
-		log.Errorf(ctx, "failed to send response: %v", err)
+	resp := storedEventResponse{
+		Key:    key.Encode(),
+		Record: content,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
 		return
 	}
 }
 
-// newErrorHandler stores an errorInstance in datastore based on the data from
-// the request.
-func newErrorHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// prepareEvent validates a decoded event and fills in any fields the server is
+// responsible for.
+func prepareEvent(content event) error {
+	if err := content.Validate(); err != nil {
+		return err
+	}
+
+	if instance, ok := content.(*datatypes.ErrorInstance); ok {
+		instance.Severity = instance.EffectiveSeverity()
+	}
 
-	var content datatypes.ErrorInstance
-	json.NewDecoder(r.Body).Decode(&content)
+	// Clients without a working clock send a zero timestamp, so fall back to
+	// the time the event was received
+	current := now()
+	*eventReceivedAt(content) = unixMillis(current)
+	timestamp := eventTimestamp(content)
+	if *timestamp == 0 {
+		*timestamp = unixMillis(current)
+	}
 
-	// Write to the datastore
-	key := datastore.NewKey(ctx, datatypes.ErrorInstanceKind, "", 0, nil)
-	if _, err := datastore.Put(ctx, key, &content); err != nil {
-		log.Errorf(ctx, "could not write to datastore: %v", err)
-		http.Error(w, "Could not save error", 500)
-		return
+	// Events from the past are fine, since clients buffer them while offline,
+	// but ones from the future mean the client's clock is wrong
+	if limit := unixMillis(current.Add(maxClockSkew)); *timestamp > limit {
+		return fmt.Errorf("timestamp %v is more than %v in the future", *timestamp, maxClockSkew)
 	}
 
-	log.Infof(ctx, "Saved error %v", content)
+	return nil
+}
 
-	if _, err := w.Write([]byte{}); err != nil {
-		log.Errorf(ctx, "failed to send response: %v", err)
-		return
+// eventUID returns the UID of the given event.
+func eventUID(content event) string {
+	switch content := content.(type) {
+	case *datatypes.REPLCommand:
+		return content.UID
+	case *datatypes.EditorContent:
+		return content.UID
+	case *datatypes.ErrorInstance:
+		return content.UID
+	default:
+		panic(fmt.Sprintf("unknown event type %T", content))
+	}
+}
+
+// eventTimestamp returns a pointer to the timestamp field of the given event.
+func eventTimestamp(content event) *int64 {
+	switch content := content.(type) {
+	case *datatypes.REPLCommand:
+		return &content.Timestamp
+	case *datatypes.EditorContent:
+		return &content.Timestamp
+	case *datatypes.ErrorInstance:
+		return &content.Timestamp
+	default:
+		panic(fmt.Sprintf("unknown event type %T", content))
+	}
+}
+
+// eventReceivedAt returns a pointer to the received time field of the given
+// event.
+func eventReceivedAt(content event) *int64 {
+	switch content := content.(type) {
+	case *datatypes.REPLCommand:
+		return &content.ReceivedAt
+	case *datatypes.EditorContent:
+		return &content.ReceivedAt
+	case *datatypes.ErrorInstance:
+		return &content.ReceivedAt
+	default:
+		panic(fmt.Sprintf("unknown event type %T", content))
+	}
+}
+
+// unixMillis returns t as the number of milliseconds since the Unix epoch,
+// which is the format event timestamps are stored in.
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// decodeBody decodes the JSON request body into v. If the body could not be
+// decoded because of a specific field, the returned error names that field.
+func decodeBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == io.EOF {
+		return errors.New("empty request body")
+	} else if err == io.ErrUnexpectedEOF {
+		return errors.New("malformed JSON: body ends before the value does")
+	}
+
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		if err.Field != "" {
+			return fmt.Errorf("field %v: expected %v, got %v", err.Field, err.Type, err.Value)
+		}
+		return fmt.Errorf("expected %v, got %v", err.Type, err.Value)
+	case *json.SyntaxError:
+		return fmt.Errorf("malformed JSON at offset %v: %v", err.Offset, err)
+	default:
+		return err
+	}
+}
+
+// bufferedBody is a request body that can be peeked at before it's decoded.
+type bufferedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// peekJSONArray returns true if the first non-whitespace byte of the request
+// body starts a JSON array. The body is left unread, so it can still be
+// decoded. An empty body isn't an array.
+func peekJSONArray(r *http.Request) (bool, error) {
+	reader := bufio.NewReader(r.Body)
+	r.Body = bufferedBody{reader, r.Body}
+
+	for {
+		next, err := reader.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		switch next[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.Discard(1)
+		default:
+			return next[0] == '[', nil
+		}
 	}
 }
 
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// postEvent sends the body to the handler as a POST request with the given
+// headers, alternating names and values.
+func postEvent(handler http.HandlerFunc, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", jsonMediaType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestStoreEvent(t *testing.T) {
+	clock := newFakeClock(t)
+	fake := useFakeStore(t)
+
+	w := postEvent(newErrorHandler, `{"uid":"player","description":"Too many arguments"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+	}
+
+	var resp struct {
+		Key    string                  `json:"key"`
+		Record datatypes.ErrorInstance `json:"record"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Key == "" {
+		t.Error("response has no key")
+	}
+
+	stored := fake.stored(datatypes.ErrorInstanceKind)
+	if len(stored) != 1 {
+		t.Fatalf("stored %v errors, want 1", len(stored))
+	}
+	instance := *stored[0].(*datatypes.ErrorInstance)
+	want := datatypes.ErrorInstance{
+		UID:         "player",
+		Description: "Too many arguments",
+		Severity:    datatypes.DefaultSeverity,
+		Timestamp:   unixMillis(clock.current),
+		ReceivedAt:  unixMillis(clock.current),
+	}
+	if instance != want {
+		t.Errorf("stored %+v, want %+v", instance, want)
+	}
+	if resp.Record != want {
+		t.Errorf("response record = %+v, want %+v", resp.Record, want)
+	}
+}
+
+func TestStoreEventRejected(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"missing UID", `{"command":"(help)"}`, http.StatusBadRequest},
+		{"malformed", `{"uid":`, http.StatusBadRequest},
+		{"empty", ``, http.StatusBadRequest},
+		{"too long", `{"uid":"player","command":"` + strings.Repeat("a", datatypes.MaxCommandLength+1) + `"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := postEvent(newREPLCommandHandler, test.body); w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+
+	if stored := fake.stored(datatypes.REPLCommandKind); len(stored) != 0 {
+		t.Errorf("stored %v rejected commands", len(stored))
+	}
+}
+
+func TestStoreErrorSeverity(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	for _, body := range []string{
+		`{"uid":"player","severity":"fatal"}`,
+		`{"uid":"player"}`,
+	} {
+		if w := postEvent(newErrorHandler, body); w.Code != http.StatusOK {
+			t.Fatalf("storing %v: status = %v", body, w.Code)
+		}
+	}
+	if w := postEvent(newErrorHandler, `{"uid":"player","severity":"crash"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown severity: status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	// An error sent without a severity is stored with the default
+	stored := fake.stored(datatypes.ErrorInstanceKind)
+	if len(stored) != 2 {
+		t.Fatalf("stored %v errors, want 2", len(stored))
+	}
+	for i, want := range []string{datatypes.SeverityFatal, datatypes.DefaultSeverity} {
+		if got := stored[i].(*datatypes.ErrorInstance).Severity; got != want {
+			t.Errorf("error %v stored with severity %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestStoreREPLCommandResult(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	// Older clients don't send a result
+	for _, body := range []string{
+		`{"uid":"player","command":"(fire 1 2)","result":"Too many arguments"}`,
+		`{"uid":"player","command":"(fire)"}`,
+	} {
+		if w := postEvent(newREPLCommandHandler, body); w.Code != http.StatusOK {
+			t.Fatalf("storing %v: status = %v", body, w.Code)
+		}
+	}
+
+	stored := fake.stored(datatypes.REPLCommandKind)
+	if len(stored) != 2 {
+		t.Fatalf("stored %v commands, want 2", len(stored))
+	}
+	for i, want := range []string{"Too many arguments", ""} {
+		if got := stored[i].(*datatypes.REPLCommand).Result; got != want {
+			t.Errorf("command %v stored with result %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestStoreEventMalformed(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"repl-command", newREPLCommandHandler},
+		{"editor-content", newEditorContentHandler},
+		{"error", newErrorHandler},
+	}
+	bodies := []struct {
+		name string
+		body string
+		// want is part of the error message the client should get
+		want string
+	}{
+		{"truncated", `{"uid":"player"`, "malformed JSON"},
+		{"not JSON", `uid=player`, "malformed JSON at offset 1"},
+		{"wrong field type", `{"uid":"player","timestamp":"yesterday"}`, "field timestamp: expected int64, got string"},
+		{"wrong body type", `"player"`, "got string"},
+		{"empty", ``, "empty request body"},
+	}
+
+	for _, h := range handlers {
+		for _, body := range bodies {
+			t.Run(h.name+"/"+body.name, func(t *testing.T) {
+				w := postEvent(h.handler, body.body)
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+				}
+				if !strings.Contains(w.Body.String(), body.want) {
+					t.Errorf("error = %q, want it to contain %q", w.Body.String(), body.want)
+				}
+			})
+		}
+	}
+
+	for _, kind := range []string{datatypes.REPLCommandKind, datatypes.EditorContentKind, datatypes.ErrorInstanceKind} {
+		if stored := fake.stored(kind); len(stored) != 0 {
+			t.Errorf("stored %v malformed events of kind %v", len(stored), kind)
+		}
+	}
+}
+
+func TestStoreEventStoreFailure(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	fake.err = errors.New("unavailable")
+
+	if w := postEvent(newEditorContentHandler, `{"uid":"player"}`); w.Code != 500 {
+		t.Errorf("status = %v, want 500", w.Code)
+	}
+}
+
+func TestStoreEventIdempotencyKey(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	var keys []string
+	for i := 0; i < 2; i++ {
+		w := postEvent(newREPLCommandHandler, `{"uid":"player","command":"(help)"}`, idempotencyKeyHeader, "retried")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %v: status = %v, want %v", i, w.Code, http.StatusOK)
+		}
+
+		var resp struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, resp.Key)
+	}
+
+	if keys[0] != keys[1] {
+		t.Errorf("retry got key %q, want the first request's %q", keys[1], keys[0])
+	}
+	if stored := fake.stored(datatypes.REPLCommandKind); len(stored) != 1 {
+		t.Errorf("stored %v commands, want 1", len(stored))
+	}
+}
+
+func TestPrepareEventTimestamp(t *testing.T) {
+	clock := newFakeClock(t)
+	received := unixMillis(clock.current)
+	// An hour ago, which is how old a buffered event might be
+	sent := unixMillis(clock.current.Add(-time.Hour))
+
+	tests := []struct {
+		name      string
+		content   event
+		timestamp int64
+	}{
+		{"repl command without timestamp", &datatypes.REPLCommand{UID: "player"}, received},
+		{"repl command with timestamp", &datatypes.REPLCommand{UID: "player", Timestamp: sent}, sent},
+		{"editor content without timestamp", &datatypes.EditorContent{UID: "player"}, received},
+		{"editor content with timestamp", &datatypes.EditorContent{UID: "player", Timestamp: sent}, sent},
+		{"error without timestamp", &datatypes.ErrorInstance{UID: "player"}, received},
+		{"error with timestamp", &datatypes.ErrorInstance{UID: "player", Timestamp: sent}, sent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := prepareEvent(test.content); err != nil {
+				t.Fatal(err)
+			}
+			if got := *eventTimestamp(test.content); got != test.timestamp {
+				t.Errorf("timestamp = %v, want %v", got, test.timestamp)
+			}
+			if got := *eventReceivedAt(test.content); got != received {
+				t.Errorf("received at = %v, want %v", got, received)
+			}
+		})
+	}
+}
+
+func TestPrepareEventClockSkew(t *testing.T) {
+	clock := newFakeClock(t)
+
+	realMaxClockSkew := maxClockSkew
+	maxClockSkew = time.Minute
+	t.Cleanup(func() { maxClockSkew = realMaxClockSkew })
+
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		wantErr bool
+	}{
+		{"long past", -30 * 24 * time.Hour, false},
+		{"within skew", 30 * time.Second, false},
+		{"at skew limit", time.Minute, false},
+		{"just past skew limit", time.Minute + time.Millisecond, true},
+		{"far future", 24 * time.Hour, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := &datatypes.ErrorInstance{
+				UID:       "player",
+				Timestamp: unixMillis(clock.current.Add(test.offset)),
+			}
+			err := prepareEvent(content)
+			if (err != nil) != test.wantErr {
+				t.Errorf("prepareEvent() = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoreEventFutureTimestamp(t *testing.T) {
+	clock := newFakeClock(t)
+	fake := useFakeStore(t)
+
+	body := fmt.Sprintf(`{"uid":"player","timestamp":%v}`, unixMillis(clock.current.Add(defaultMaxClockSkew+time.Second)))
+	if w := postEvent(newREPLCommandHandler, body); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if stored := fake.stored(datatypes.REPLCommandKind); len(stored) != 0 {
+		t.Errorf("stored %v commands from the future", len(stored))
+	}
+}
+
+func TestStoreEventBodyTooLarge(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	const maxBytes = 64
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"at limit", `{"uid":"player","command":"` + strings.Repeat("a", maxBytes-29) + `"}`, http.StatusOK},
+		{"over limit", `{"uid":"player","command":"` + strings.Repeat("a", maxBytes) + `"}`, http.StatusRequestEntityTooLarge},
+		{"array over limit", `[{"uid":"player","command":"` + strings.Repeat("a", maxBytes) + `"}]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := limitBody(maxBytes, http.HandlerFunc(newREPLCommandHandler))
+			if w := postEvent(handler.ServeHTTP, test.body); w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+
+	if stored := fake.stored(datatypes.REPLCommandKind); len(stored) != 1 {
+		t.Errorf("stored %v commands, want only the one within the limit", len(stored))
+	}
+}
+
+func TestStoreEventSingleAndArray(t *testing.T) {
+	endpoints := []struct {
+		name     string
+		handler  http.HandlerFunc
+		kind     string
+		category string
+	}{
+		{"repl-command", newREPLCommandHandler, datatypes.REPLCommandKind, replCommandsCategory},
+		{"editor-content", newEditorContentHandler, datatypes.EditorContentKind, editorContentsCategory},
+		{"error", newErrorHandler, datatypes.ErrorInstanceKind, errorsCategory},
+	}
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint.name+"/single", func(t *testing.T) {
+			newFakeClock(t)
+			fake := useFakeStore(t)
+
+			w := postEvent(endpoint.handler, ` {"uid":"player"}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+			}
+			var resp struct {
+				Key    string          `json:"key"`
+				Record json.RawMessage `json:"record"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Key == "" || len(resp.Record) == 0 {
+				t.Errorf("response %v isn't a single stored event", w.Body)
+			}
+			if got := len(fake.stored(endpoint.kind)); got != 1 {
+				t.Errorf("stored %v events, want 1", got)
+			}
+		})
+
+		t.Run(endpoint.name+"/array", func(t *testing.T) {
+			newFakeClock(t)
+			fake := useFakeStore(t)
+
+			// Arrays may start after whitespace, and invalid elements don't
+			// stop the rest from being stored
+			w := postEvent(endpoint.handler, "\n [{\"uid\":\"a\"},{},{\"uid\":\"b\"}]")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+			}
+			var resp batchResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Stored[endpoint.category] != 2 {
+				t.Errorf("response reports %v stored, want 2 %v", resp.Stored, endpoint.category)
+			}
+			failed := resp.Failed[endpoint.category]
+			if len(failed) != 1 || failed[0].Index != 1 {
+				t.Errorf("response reports %+v failed, want index 1", resp.Failed)
+			}
+			if got := len(fake.stored(endpoint.kind)); got != 2 {
+				t.Errorf("stored %v events, want 2", got)
+			}
+		})
+	}
+}
+
+func TestStoreEventArrayRejected(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	tests := []struct {
+		name    string
+		body    string
+		headers []string
+		want    int
+	}{
+		{"malformed", `[{"uid":"a"},`, nil, http.StatusBadRequest},
+		{"wrong element type", `["a"]`, nil, http.StatusBadRequest},
+		{"too many events", "[" + strings.Repeat(`{"uid":"a"},`, maxBatchSize) + `{"uid":"a"}]`, nil, http.StatusBadRequest},
+		{"idempotency key", `[{"uid":"a"}]`, []string{idempotencyKeyHeader, "key"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := postEvent(newErrorHandler, test.body, test.headers...); w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+
+	if stored := fake.stored(datatypes.ErrorInstanceKind); len(stored) != 0 {
+		t.Errorf("stored %v errors from rejected arrays", len(stored))
+	}
+}
+
+func TestStoredEventResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		kind    string
+		body    string
+		record  event
+	}{
+		{"repl-command", newREPLCommandHandler, datatypes.REPLCommandKind,
+			`{"uid":"player","command":"(help)","result":"ok"}`, &datatypes.REPLCommand{}},
+		{"editor-content", newEditorContentHandler, datatypes.EditorContentKind,
+			`{"uid":"player","content":"(define x 1)"}`, &datatypes.EditorContent{}},
+		{"error", newErrorHandler, datatypes.ErrorInstanceKind,
+			`{"uid":"player","description":"Too many arguments"}`, &datatypes.ErrorInstance{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeClock(t)
+			fake := useFakeStore(t)
+
+			w := postEvent(test.handler, test.body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+				t.Fatal(err)
+			}
+			if len(fields) != 2 || fields["key"] == nil || fields["record"] == nil {
+				t.Fatalf("response %v should have only a key and a record", w.Body)
+			}
+
+			var key string
+			if err := json.Unmarshal(fields["key"], &key); err != nil {
+				t.Fatal(err)
+			}
+			if want := (fakeKey{kind: test.kind, id: 1}).Encode(); key != want {
+				t.Errorf("key = %q, want %q", key, want)
+			}
+
+			// The record is the event as it was stored, including the fields
+			// the server fills in
+			if err := json.Unmarshal(fields["record"], test.record); err != nil {
+				t.Fatal(err)
+			}
+			stored := fake.stored(test.kind)
+			if len(stored) != 1 || !reflect.DeepEqual(test.record, stored[0]) {
+				t.Errorf("record = %+v, want the stored %+v", test.record, stored)
+			}
+		})
+	}
+}
+
+func TestStoredEventResponseStoreFailure(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	fake.err = errors.New("unavailable")
+
+	w := postEvent(newErrorHandler, `{"uid":"player"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+	if json.Valid(w.Body.Bytes()) {
+		t.Errorf("a failed write responded with JSON %v", w.Body)
+	}
+}
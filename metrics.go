@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// eventsIngested counts the events stored by the API, labeled by their
+	// Datastore kind.
+	eventsIngested = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_ingested_total",
+			Help: "Number of events stored, by kind.",
+		},
+		[]string{"kind"},
+	)
+
+	// datastorePutDuration tracks how long writes to Datastore take.
+	datastorePutDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "datastore_put_duration_seconds",
+			Help:    "Time taken by Datastore writes.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventsIngested, datastorePutDuration)
+}
+
+// observePut records a Datastore write of the given kind that started at the
+// given time.
+func observePut(kind string, start time.Time) {
+	latency := now().Sub(start)
+	datastorePutDuration.Observe(latency.Seconds())
+	putLatencies.record(kind, latency)
+}
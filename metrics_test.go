@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetric returns the value of the metric on the line of the /metrics
+// response that starts with the given name and labels, or zero if there's
+// none.
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("scraping /metrics: status = %v", w.Code)
+	}
+
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == series {
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return value
+		}
+	}
+
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+
+	const ingested = `events_ingested_total{kind="Error"}`
+	const puts = `datastore_put_duration_seconds_count`
+	ingestedBefore, putsBefore := scrapeMetric(t, ingested), scrapeMetric(t, puts)
+
+	for _, body := range []string{`{"uid":"a"}`, `{"uid":"b"}`, `{}`} {
+		postEvent(newErrorHandler, body)
+	}
+
+	// The event without a UID was rejected, so it's neither written nor
+	// counted
+	if got := scrapeMetric(t, ingested) - ingestedBefore; got != 2 {
+		t.Errorf("%v went up by %v, want 2", ingested, got)
+	}
+	if got := scrapeMetric(t, puts) - putsBefore; got != 2 {
+		t.Errorf("%v went up by %v, want 2", puts, got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiKeyHeader is the request header clients put their API key in.
+const apiKeyHeader = "X-API-Key"
+
+// loadAPIKeys returns the API keys in the comma-separated API_KEYS
+// environment variable. If none are set, no requests will be authorized.
+func loadAPIKeys() [][sha256.Size]byte {
+	var keys [][sha256.Size]byte
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, sha256.Sum256([]byte(key)))
+		}
+	}
+
+	return keys
+}
+
+// requireAPIKey is a middleware handler which fails if a request doesn't
+// have one of the given API keys in its X-API-Key header. Keys are compared
+// by their hashes in constant time, so the time taken doesn't reveal how much
+// of a key was correct or which key it was close to.
+func requireAPIKey(keys [][sha256.Size]byte, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			provided := r.Header.Get(apiKeyHeader)
+			if provided == "" {
+				http.Error(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+
+			providedHash := sha256.Sum256([]byte(provided))
+			matched := 0
+			for _, key := range keys {
+				matched |= subtle.ConstantTimeCompare(providedHash[:], key[:])
+			}
+			if matched != 1 {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+
+			main.ServeHTTP(w, r)
+		},
+	)
+}
+
+// jsonMediaType is the Content-Type of JSON bodies.
+const jsonMediaType = "application/json"
+
+// requireMediaType is a middleware handler which fails if a request's
+// Content-Type isn't the given media type. Parameters like charset are
+// allowed.
+func requireMediaType(required string, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != required {
+				http.Error(w, "Content-Type must be "+required, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			main.ServeHTTP(w, r)
+		},
+	)
+}
+
+// decompressBody is a middleware handler which decompresses request bodies
+// sent with "Content-Encoding: gzip". It fails if the body isn't valid gzip or
+// uses any other encoding.
+func decompressBody(main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+			case "", "identity":
+			case "gzip":
+				reader, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "Invalid gzip body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer reader.Close()
+
+				r.Body = reader
+				r.Header.Del("Content-Encoding")
+			default:
+				http.Error(w, "Content-Encoding must be gzip or identity", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			main.ServeHTTP(w, r)
+		},
+	)
+}
+
+// defaultMaxBodyBytes is the default size limit of request bodies.
+const defaultMaxBodyBytes = 256 * 1024
+
+// limitBody is a middleware handler which limits the request body to at most
+// maxBytes bytes. Reading past the limit fails with an error for which
+// isBodyTooLarge returns true.
+func limitBody(maxBytes int64, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			main.ServeHTTP(w, r)
+		},
+	)
+}
+
+// isBodyTooLarge returns true if the error was caused by reading past the
+// limit set by limitBody.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// bodyErrorStatus returns the status code to respond with when the request
+// body couldn't be read or decoded.
+func bodyErrorStatus(err error) int {
+	if isBodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	os.Setenv("API_KEYS", "first, second,")
+	defer os.Unsetenv("API_KEYS")
+	keys := loadAPIKeys()
+
+	handler := requireAPIKey(keys, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	))
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "third", http.StatusUnauthorized},
+		{"prefix of a key", "firs", http.StatusUnauthorized},
+		{"first key", "first", http.StatusOK},
+		// Whitespace around keys in API_KEYS is ignored
+		{"second key", "second", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/error", nil)
+			if test.key != "" {
+				r.Header.Set(apiKeyHeader, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyNoneConfigured(t *testing.T) {
+	os.Unsetenv("API_KEYS")
+	handler := requireAPIKey(loadAPIKeys(), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	))
+
+	r := httptest.NewRequest("POST", "/error", nil)
+	r.Header.Set(apiKeyHeader, "anything")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireMediaType(t *testing.T) {
+	handler := requireMediaType(jsonMediaType, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	))
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        int
+	}{
+		{"correct", "application/json", http.StatusOK},
+		{"with charset", "application/json; charset=utf-8", http.StatusOK},
+		{"different case", "Application/JSON", http.StatusOK},
+		{"missing", "", http.StatusUnsupportedMediaType},
+		{"wrong", "text/plain", http.StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"malformed", "application/json;;", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/error", nil)
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+}
+
+// gzipped returns the text compressed with gzip.
+func gzipped(text string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(text))
+	w.Close()
+	return b.String()
+}
+
+func TestDecompressBody(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	handler := decompressBody(http.HandlerFunc(newErrorHandler))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     string
+		want     int
+	}{
+		{"gzip", "gzip", gzipped(`{"uid":"gzip"}`), http.StatusOK},
+		{"gzip with different case", " GZip ", gzipped(`{"uid":"gzip-case"}`), http.StatusOK},
+		{"identity", "identity", `{"uid":"identity"}`, http.StatusOK},
+		{"none", "", `{"uid":"none"}`, http.StatusOK},
+		{"bad gzip", "gzip", `{"uid":"bad-gzip"}`, http.StatusBadRequest},
+		{"truncated gzip", "gzip", gzipped(`{"uid":"truncated"}`)[:12], http.StatusBadRequest},
+		{"unknown encoding", "br", `{"uid":"brotli"}`, http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := []string{}
+			if test.encoding != "" {
+				headers = append(headers, "Content-Encoding", test.encoding)
+			}
+			if w := postEvent(handler.ServeHTTP, test.body, headers...); w.Code != test.want {
+				t.Errorf("status = %v, want %v: %v", w.Code, test.want, w.Body)
+			}
+		})
+	}
+
+	var uids []string
+	for _, content := range fake.stored(datatypes.ErrorInstanceKind) {
+		uids = append(uids, eventUID(content))
+	}
+	want := []string{"gzip", "gzip-case", "identity", "none"}
+	if strings.Join(uids, ",") != strings.Join(want, ",") {
+		t.Errorf("stored errors of %v, want %v", uids, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// jsonObject is a JSON object in the OpenAPI document.
+type jsonObject map[string]interface{}
+
+// ingestPath describes an endpoint that stores events.
+type ingestPath struct {
+	path      string
+	summary   string
+	mediaType string
+	// body is the type of the request body or, for NDJSON, of each line
+	body reflect.Type
+}
+
+// ingestPaths are the endpoints described by the OpenAPI document.
+var ingestPaths = []ingestPath{
+	{"/repl-command", "Store a command run in the REPL", jsonMediaType, reflect.TypeOf(datatypes.REPLCommand{})},
+	{"/editor-content", "Store the contents of the editor when it was saved", jsonMediaType, reflect.TypeOf(datatypes.EditorContent{})},
+	{"/error", "Store an error the game reported", jsonMediaType, reflect.TypeOf(datatypes.ErrorInstance{})},
+	{"/batch", "Store events of every kind at once", jsonMediaType, reflect.TypeOf(batchRequest{})},
+	{"/ingest", "Store a stream of events of every kind, one per line", ndjsonMediaType, reflect.TypeOf(ingestLine{})},
+}
+
+// openAPISpec is the encoded OpenAPI document. The request schemas are
+// generated from the types the API decodes, so they can't fall out of sync.
+var openAPISpec = mustEncodeJSON(newOpenAPISpec())
+
+// newOpenAPIHandler responds with an OpenAPI 3 document describing the ingest
+// endpoints.
+func newOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// newOpenAPISpec builds the OpenAPI document.
+func newOpenAPISpec() jsonObject {
+	errorResponse := func(description string) jsonObject {
+		return jsonObject{
+			"description": description,
+			"content": jsonObject{
+				"text/plain": jsonObject{"schema": jsonObject{"type": "string"}},
+			},
+		}
+	}
+
+	batchResponseSchema := schemaFor(reflect.TypeOf(batchResponse{}))
+
+	paths := jsonObject{}
+	for _, p := range ingestPaths {
+		var success jsonObject
+		requestSchema := schemaFor(p.body)
+		switch p.body {
+		case reflect.TypeOf(batchRequest{}):
+			success = jsonObject{
+				"description": "The events that could be stored were, and the rest are listed as failures",
+				"content": jsonObject{
+					"application/json": jsonObject{"schema": batchResponseSchema},
+				},
+			}
+		case reflect.TypeOf(ingestLine{}):
+			requestSchema = ingestLineSchema()
+			success = jsonObject{
+				"description": "The lines that could be stored were, and the rest are listed as failures",
+				"content": jsonObject{
+					"application/json": jsonObject{"schema": schemaFor(reflect.TypeOf(ingestResponse{}))},
+				},
+			}
+		default:
+			// Single-kind endpoints also accept an array of events, which
+			// is responded to like a batch
+			requestSchema = jsonObject{"oneOf": []jsonObject{
+				requestSchema,
+				{"type": "array", "items": requestSchema, "maxItems": maxBatchSize},
+			}}
+			success = jsonObject{
+				"description": "The event was stored or, for arrays, the events that could be stored were",
+				"content": jsonObject{
+					"application/json": jsonObject{"schema": jsonObject{"oneOf": []jsonObject{
+						{
+							"type": "object",
+							"properties": jsonObject{
+								"key":    jsonObject{"type": "string"},
+								"record": schemaFor(p.body),
+							},
+						},
+						batchResponseSchema,
+					}}},
+				},
+			}
+		}
+
+		// NDJSON bodies are stored line by line, so retries can't be made
+		// idempotent
+		parameters := []jsonObject{}
+		if p.mediaType == jsonMediaType {
+			parameters = append(parameters, jsonObject{
+				"name":        idempotencyKeyHeader,
+				"in":          "header",
+				"description": "A key that makes retries of the request only store the event once",
+				"schema":      jsonObject{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+
+		// Only POST is described, since every other method is rejected
+		paths[p.path] = jsonObject{
+			"post": jsonObject{
+				"summary":    p.summary,
+				"parameters": parameters,
+				"requestBody": jsonObject{
+					"required": true,
+					"content": jsonObject{
+						p.mediaType: jsonObject{"schema": requestSchema},
+					},
+				},
+				"responses": jsonObject{
+					"200": success,
+					"400": errorResponse("The body is malformed or the event is invalid"),
+					"401": errorResponse("The API key is missing or invalid"),
+					"405": errorResponse("The method isn't POST"),
+					"413": errorResponse("The body is too large"),
+					"415": errorResponse("The body isn't " + p.mediaType + " or uses an unsupported encoding"),
+					"429": errorResponse("The client is being rate limited, see Retry-After"),
+					"500": errorResponse("The event couldn't be saved"),
+				},
+			},
+		}
+	}
+
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "lambda-starship-user-stats",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"securitySchemes": jsonObject{
+				"apiKey": jsonObject{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+		"security": []jsonObject{{"apiKey": []string{}}},
+	}
+}
+
+// ingestLineSchema returns the schema of a line of an NDJSON body, which is
+// an event of any of the ingestKinds along with the name of its kind.
+func ingestLineSchema() jsonObject {
+	var names []string
+	for name := range ingestKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var kinds []jsonObject
+	for _, name := range names {
+		schema := schemaFor(reflect.TypeOf(ingestKinds[name].newEvent()))
+		properties := jsonObject{"kind": jsonObject{"type": "string", "enum": []string{name}}}
+		for property, propertySchema := range schema["properties"].(jsonObject) {
+			properties[property] = propertySchema
+		}
+		schema["properties"] = properties
+		required, _ := schema["required"].([]string)
+		schema["required"] = append([]string{"kind"}, required...)
+		kinds = append(kinds, schema)
+	}
+
+	return jsonObject{
+		"description": "Each line of the body is one of these events",
+		"oneOf":       kinds,
+	}
+}
+
+// schemaFor returns a JSON schema for values of the given type, as encoded by
+// encoding/json.
+func schemaFor(t reflect.Type) jsonObject {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := jsonObject{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = schemaFor(field.Type)
+			if name == "uid" {
+				required = append(required, name)
+			}
+		}
+
+		schema := jsonObject{"type": "object", "properties": properties}
+		if required != nil {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// Interfaces can hold anything
+		return jsonObject{}
+	}
+}
+
+// mustEncodeJSON encodes the value as JSON, panicking on failure.
+func mustEncodeJSON(v interface{}) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return encoded
+}
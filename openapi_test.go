@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	newOpenAPIHandler(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]struct {
+					Schema json.RawMessage `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q, want 3.0.3", spec.OpenAPI)
+	}
+
+	tests := []struct {
+		path      string
+		mediaType string
+	}{
+		{"/repl-command", jsonMediaType},
+		{"/editor-content", jsonMediaType},
+		{"/error", jsonMediaType},
+		{"/batch", jsonMediaType},
+		{"/ingest", ndjsonMediaType},
+	}
+	if len(spec.Paths) != len(tests) {
+		t.Errorf("spec has %v paths, want %v", len(spec.Paths), len(tests))
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			operations, ok := spec.Paths[test.path]
+			if !ok {
+				t.Fatal("path is missing")
+			}
+			// Ingest endpoints only accept POST
+			if len(operations) != 1 {
+				t.Errorf("spec has %v operations, want only post", len(operations))
+			}
+			post, ok := operations["post"]
+			if !ok {
+				t.Fatal("post operation is missing")
+			}
+
+			if _, ok := post.RequestBody.Content[test.mediaType]; !ok {
+				t.Errorf("request body isn't described as %v", test.mediaType)
+			}
+			for _, status := range []string{"200", "400", "401", "405", "413", "415", "429", "500"} {
+				if _, ok := post.Responses[status]; !ok {
+					t.Errorf("%v response is missing", status)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	type nested struct {
+		Name string `json:"name"`
+	}
+	type example struct {
+		UID      string         `json:"uid"`
+		Count    int64          `json:"count,omitempty"`
+		Ratio    float64        `json:"ratio"`
+		Enabled  bool           `json:"enabled"`
+		Tags     []string       `json:"tags"`
+		Nested   *nested        `json:"nested"`
+		Counts   map[string]int `json:"counts"`
+		Untagged string
+		Ignored  string `json:"-"`
+		hidden   string
+	}
+
+	got := string(mustEncodeJSON(schemaFor(reflect.TypeOf(example{}))))
+	want := `{"properties":{` +
+		`"Untagged":{"type":"string"},` +
+		`"count":{"type":"integer"},` +
+		`"counts":{"additionalProperties":{"type":"integer"},"type":"object"},` +
+		`"enabled":{"type":"boolean"},` +
+		`"nested":{"properties":{"name":{"type":"string"}},"type":"object"},` +
+		`"ratio":{"type":"number"},` +
+		`"tags":{"items":{"type":"string"},"type":"array"},` +
+		`"uid":{"type":"string"}},` +
+		`"required":["uid"],"type":"object"}`
+	if got != want {
+		t.Errorf("schema = %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// publisher sends stored events to a real-time stream.
+type publisher interface {
+	// publish sends the messages and waits until all of them have been
+	// accepted. It returns the error of each message that wasn't, or nil if
+	// all were.
+	publish(ctx context.Context, messages []eventMessage) []error
+}
+
+// eventMessage is a stored event to be published.
+type eventMessage struct {
+	kind string
+	// data is the JSON-encoded event
+	data []byte
+}
+
+// eventPublisher is where stored events are published, or nil if they aren't
+// published anywhere.
+var eventPublisher publisher
+
+// pubsubPublisher publishes events to a Pub/Sub topic.
+type pubsubPublisher struct {
+	topic *pubsub.Topic
+}
+
+// loadPublisher returns a publisher for the Pub/Sub topic named by the
+// PUBSUB_TOPIC environment variable, in the project named by
+// GOOGLE_CLOUD_PROJECT. If no topic is set, events aren't published and nil is
+// returned. A client that can't be created stops the server from starting.
+func loadPublisher() publisher {
+	topicID := os.Getenv("PUBSUB_TOPIC")
+	if topicID == "" {
+		return nil
+	}
+
+	client, err := pubsub.NewClient(context.Background(), os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		panic("could not create Pub/Sub client: " + err.Error())
+	}
+
+	return pubsubPublisher{client.Topic(topicID)}
+}
+
+// publish sends each message with its kind as the "kind" attribute. Every
+// message is handed to the client before waiting on any of them, so that the
+// client can send them to Pub/Sub together.
+func (p pubsubPublisher) publish(ctx context.Context, messages []eventMessage) []error {
+	results := make([]*pubsub.PublishResult, len(messages))
+	for i, message := range messages {
+		results[i] = p.topic.Publish(ctx, &pubsub.Message{
+			Data:       message.data,
+			Attributes: map[string]string{"kind": message.kind},
+		})
+	}
+
+	var errs []error
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			if errs == nil {
+				errs = make([]error, len(messages))
+			}
+			errs[i] = err
+		}
+	}
+
+	return errs
+}
+
+// recordStoredEvents counts events that were just stored in the metrics and
+// publishes them together.
+func recordStoredEvents(ctx context.Context, stored []batchEntry) {
+	for _, entry := range stored {
+		eventsIngested.WithLabelValues(entry.kind).Inc()
+	}
+	publishEvents(ctx, stored)
+}
+
+// publishEvents publishes stored events to eventPublisher, if there is one.
+// Datastore is the source of truth, so failures are logged rather than
+// failing the request.
+func publishEvents(ctx context.Context, stored []batchEntry) {
+	if eventPublisher == nil || len(stored) == 0 {
+		return
+	}
+
+	var messages []eventMessage
+	for _, entry := range stored {
+		data, err := json.Marshal(entry.content)
+		if err != nil {
+			logger.Errorf(ctx, "could not encode %v for publishing: %v", entry.kind, err)
+			continue
+		}
+		messages = append(messages, eventMessage{entry.kind, data})
+	}
+
+	for i, err := range eventPublisher.publish(ctx, messages) {
+		if err != nil {
+			logger.Errorf(ctx, "could not publish %v: %v", messages[i].kind, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+// fakePublisher records the messages it's asked to publish.
+type fakePublisher struct {
+	mutex sync.Mutex
+	// calls are the messages of each call to publish
+	calls [][]eventMessage
+	// err, if set, fails every message
+	err error
+}
+
+// useFakePublisher publishes events to a new fakePublisher for the duration
+// of a test.
+func useFakePublisher(t testing.TB) *fakePublisher {
+	fake := &fakePublisher{}
+
+	realPublisher := eventPublisher
+	eventPublisher = fake
+	t.Cleanup(func() { eventPublisher = realPublisher })
+
+	return fake
+}
+
+func (p *fakePublisher) publish(ctx context.Context, messages []eventMessage) []error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.calls = append(p.calls, messages)
+	if p.err == nil {
+		return nil
+	}
+
+	errs := make([]error, len(messages))
+	for i := range errs {
+		errs[i] = p.err
+	}
+	return errs
+}
+
+// messages returns every message published, in order.
+func (p *fakePublisher) messages() []eventMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var messages []eventMessage
+	for _, call := range p.calls {
+		messages = append(messages, call...)
+	}
+	return messages
+}
+
+func TestPublishPerEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		kind    string
+		uid     string
+	}{
+		{"repl-command", newREPLCommandHandler, `{"uid":"a","command":"(help)"}`, datatypes.REPLCommandKind, "a"},
+		{"editor-content", newEditorContentHandler, `{"uid":"b","content":"(define x 1)"}`, datatypes.EditorContentKind, "b"},
+		{"error", newErrorHandler, `{"uid":"c","description":"Too many arguments"}`, datatypes.ErrorInstanceKind, "c"},
+		{"single-kind array", newErrorHandler, `[{"uid":"d"}]`, datatypes.ErrorInstanceKind, "d"},
+		{"batch", newBatchHandler, `{"editorContents":[{"uid":"e"}]}`, datatypes.EditorContentKind, "e"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeClock(t)
+			useFakeStore(t)
+			fake := useFakePublisher(t)
+
+			if w := postEvent(test.handler, test.body); w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+			}
+
+			messages := fake.messages()
+			if len(messages) != 1 {
+				t.Fatalf("published %v messages, want 1", len(messages))
+			}
+			if messages[0].kind != test.kind {
+				t.Errorf("published kind %v, want %v", messages[0].kind, test.kind)
+			}
+
+			var published struct {
+				UID        string `json:"uid"`
+				ReceivedAt int64  `json:"receivedAt"`
+			}
+			if err := json.Unmarshal(messages[0].data, &published); err != nil {
+				t.Fatalf("published invalid JSON: %v", err)
+			}
+			if published.UID != test.uid || published.ReceivedAt == 0 {
+				t.Errorf("published %s, want the stored event", messages[0].data)
+			}
+		})
+	}
+}
+
+func TestPublishBatchTogether(t *testing.T) {
+	newFakeClock(t)
+	store := useFakeStore(t)
+	store.failUIDs["unwritable"] = true
+	fake := useFakePublisher(t)
+
+	body := `{"replCommands":[{"uid":"a"},{"uid":"unwritable"}],"errors":[{"uid":"a"},{}]}`
+	if w := postEvent(newBatchHandler, body); w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %v", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Only the stored events are published, and all in one call
+	if len(fake.calls) != 1 {
+		t.Fatalf("publish was called %v times, want once", len(fake.calls))
+	}
+	if got := len(fake.calls[0]); got != 2 {
+		t.Errorf("published %v events, want the 2 that were stored", got)
+	}
+}
+
+func TestPublishIdempotentRetry(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+	fake := useFakePublisher(t)
+
+	counter := eventsIngested.WithLabelValues(datatypes.REPLCommandKind)
+	before := testutil.ToFloat64(counter)
+
+	for i := 0; i < 3; i++ {
+		w := postEvent(newREPLCommandHandler, `{"uid":"a"}`, idempotencyKeyHeader, "once")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %v: status = %v, want %v", i, w.Code, http.StatusOK)
+		}
+	}
+
+	if got := len(fake.messages()); got != 1 {
+		t.Errorf("published %v messages, want 1", got)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("counted %v events, want 1", got)
+	}
+}
+
+func TestPublishFailureDoesNotFailRequest(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+	fake := useFakePublisher(t)
+	fake.err = errors.New("unavailable")
+
+	if w := postEvent(newErrorHandler, `{"uid":"a"}`); w.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+}
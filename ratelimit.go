@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimit is the default number of requests per second allowed
+	// for each UID.
+	defaultRateLimit = 10
+	// defaultRateLimitBurst is the default number of requests a UID can make
+	// in quick succession before being limited.
+	defaultRateLimitBurst = 20
+	// maxIdleBuckets is the number of token buckets the rate limiter will
+	// hold before it starts discarding ones that are idle.
+	maxIdleBuckets = 10000
+)
+
+// tokenBucket tracks how many requests a client can make. Tokens are refilled
+// continuously and each request spends one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the rate of requests from each client using a token
+// bucket per client.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter creates a rateLimiter that allows rate requests per second
+// from each client, with bursts of up to burst requests.
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow spends a token from the client's bucket if one is available. If not,
+// it returns false and how long until one will be.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current := now()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.discardFullBuckets(current)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: current}
+		l.buckets[client] = bucket
+	}
+
+	elapsed := current.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.last = current
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// discardFullBuckets removes the buckets of clients that have been idle long
+// enough to refill completely, since they're no different from a new bucket.
+func (l *rateLimiter) discardFullBuckets(current time.Time) {
+	for client, bucket := range l.buckets {
+		elapsed := current.Sub(bucket.last).Seconds()
+		if bucket.tokens+elapsed*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimit is a middleware handler which fails with a 429 if the client has
+// made too many requests recently. Clients are identified by the UID in the
+// request body, or by their IP address if the body has no UID. The whole body
+// is read to find the UID, so only endpoints whose bodies are a single JSON
+// value should use it.
+func rateLimit(limiter *rateLimiter, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Could not read request body", bodyErrorStatus(err))
+				return
+			}
+			// Put the body back for the main handler
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			if allowClient(limiter, w, rateLimitClient(r, body)) {
+				main.ServeHTTP(w, r)
+			}
+		},
+	)
+}
+
+// rateLimitByAddress is like rateLimit, but identifies clients only by their
+// IP address. It doesn't touch the body, so streamed bodies can be read by
+// the main handler without being held in memory, and a body with events from
+// many UIDs counts as the one request it is.
+func rateLimitByAddress(limiter *rateLimiter, main http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if allowClient(limiter, w, "ip:"+remoteHost(r)) {
+				main.ServeHTTP(w, r)
+			}
+		},
+	)
+}
+
+// allowClient spends one of the client's tokens, or responds with a 429 and
+// returns false if it has none.
+func allowClient(limiter *rateLimiter, w http.ResponseWriter, client string) bool {
+	ok, wait := limiter.allow(client)
+	if !ok {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// rateLimitClient returns the identity of the client for rate limiting
+// purposes.
+func rateLimitClient(r *http.Request, body []byte) string {
+	var content struct {
+		UID string `json:"uid"`
+	}
+	if json.Unmarshal(body, &content) == nil && content.UID != "" {
+		return "uid:" + content.UID
+	}
+
+	return "ip:" + remoteHost(r)
+}
+
+// remoteHost returns the IP address the request came from.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	clock := newFakeClock(t)
+	limiter := newRateLimiter(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("client"); !ok {
+			t.Fatalf("request %v within the burst was limited", i)
+		}
+	}
+
+	ok, wait := limiter.allow("client")
+	if ok {
+		t.Fatal("request past the burst was allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want %v", wait, 500*time.Millisecond)
+	}
+
+	if ok, _ := limiter.allow("other"); !ok {
+		t.Error("a different client was limited")
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, wait := limiter.allow("client"); ok || wait != 250*time.Millisecond {
+		t.Errorf("allow() = %v, %v, want false, %v", ok, wait, 250*time.Millisecond)
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, _ := limiter.allow("client"); !ok {
+		t.Error("request was limited after a token was refilled")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	newFakeClock(t)
+
+	var bodies []string
+	handler := rateLimit(newRateLimiter(1, 2), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+		},
+	))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/error", strings.NewReader(body))
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	body := `{"uid":"player"}`
+	for i := 0; i < 2; i++ {
+		if w := send(body); w.Code != http.StatusOK {
+			t.Fatalf("request %v: status = %v, want %v", i, w.Code, http.StatusOK)
+		}
+	}
+
+	w := send(body)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// Requests without a UID are limited by IP address instead
+	if w := send(`{}`); w.Code != http.StatusOK {
+		t.Errorf("request without a UID: status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// The limiter reads the body to find the UID, so it has to be put back
+	// for the handler
+	want := []string{body, body, `{}`}
+	if strings.Join(bodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("handler got bodies %q, want %q", bodies, want)
+	}
+}
+
+func TestRateLimitByAddress(t *testing.T) {
+	newFakeClock(t)
+
+	var bodies []string
+	handler := rateLimitByAddress(newRateLimiter(1, 1), http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+		},
+	))
+
+	send := func(body, remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/ingest", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	body := "{\"uid\":\"a\"}\n{\"uid\":\"b\"}"
+	if w := send(body, "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// The UIDs in the body don't matter, only the address does
+	if w := send(`{"uid":"c"}`, "192.0.2.1:5678"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request from the address: status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if w := send(body, "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("request from another address: status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	want := []string{body, body}
+	if strings.Join(bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("handler got bodies %q, want %q", bodies, want)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout is the default for how long in-flight requests are
+// given to finish when the server is shut down.
+const defaultShutdownTimeout = 10 * time.Second
+
+// useStandaloneEnvironment makes the handlers use the Cloud Datastore client
+// and the standard library's logger, since App Engine's APIs only work on App
+// Engine.
+func useStandaloneEnvironment() {
+	newContext = (*http.Request).Context
+	store = loadCloudStore()
+	logger = stdLogger{log.New(os.Stderr, "", log.LstdFlags)}
+}
+
+// serve runs the API with a standard HTTP server, for when it isn't running on
+// App Engine. The server listens on the port in the PORT environment variable,
+// or 8080 if it isn't set. On SIGINT or SIGTERM, the server stops accepting
+// requests and waits up to the shutdown timeout for in-flight ones to finish,
+// so that events being written when the server is redeployed aren't dropped.
+func serve(handler http.Handler, shutdownTimeout time.Duration) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("listening: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Listening on port %v", port)
+	if err := serveUntilDone(ctx, listener, handler, shutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Shut down")
+}
+
+// serveUntilDone serves requests from the listener until the context is done,
+// then shuts down gracefully, waiting up to the shutdown timeout for in-flight
+// requests to finish.
+func serveUntilDone(ctx context.Context, listener net.Listener, handler http.Handler, shutdownTimeout time.Duration) error {
+	server := &http.Server{Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("serving: %v", err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %v for requests to finish", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %v", err)
+	}
+	return nil
+}
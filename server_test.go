@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves the handler on a local port until the returned context
+// is cancelled. The error serveUntilDone returns is sent on the channel.
+func startServer(t *testing.T, handler http.Handler, shutdownTimeout time.Duration) (string, context.CancelFunc, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilDone(ctx, listener, handler, shutdownTimeout)
+	}()
+	t.Cleanup(cancel)
+
+	return "http://" + listener.Addr().String(), cancel, done
+}
+
+func TestServeUntilDoneFinishesInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("stored"))
+	})
+
+	url, shutdown, done := startServer(t, handler, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+
+	<-started
+	shutdown()
+
+	// The server waits for the request rather than dropping it
+	select {
+	case err := <-done:
+		t.Fatalf("server shut down with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// New connections aren't accepted once shutting down
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("server accepted a request while shutting down")
+	}
+
+	close(release)
+	if got := <-inFlight; got.err != nil || got.body != "stored" {
+		t.Errorf("in-flight request got %q, %v, want it to finish", got.body, got.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serveUntilDone() = %v, want nil", err)
+	}
+}
+
+func TestServeUntilDoneShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	url, shutdown, done := startServer(t, handler, 10*time.Millisecond)
+	go http.Get(url)
+
+	<-started
+	shutdown()
+
+	err := <-done
+	if err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("serveUntilDone() = %v, want a shutdown error", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBufferSize is the default number of recent writes of each
+// kind whose latency is kept.
+const defaultLatencyBufferSize = 1000
+
+// batchPutKind is the kind writes are tracked under when they store events of
+// more than one kind.
+const batchPutKind = "batch"
+
+// latencyTracker keeps the latency of the most recent Datastore writes of
+// each kind, so that percentiles can be computed without a metrics backend.
+// It's safe for concurrent use.
+type latencyTracker struct {
+	mutex sync.Mutex
+	// size is how many latencies are kept per kind
+	size int
+	// latencies is a ring buffer of the latencies of each kind, with the
+	// next one written at next[kind]
+	latencies map[string][]time.Duration
+	next      map[string]int
+}
+
+// newLatencyTracker creates a tracker that keeps size latencies per kind. The
+// size must be positive.
+func newLatencyTracker(size int) *latencyTracker {
+	if size < 1 {
+		panic(fmt.Sprintf("latency buffer size must be positive, got %v", size))
+	}
+
+	return &latencyTracker{
+		size:      size,
+		latencies: make(map[string][]time.Duration),
+		next:      make(map[string]int),
+	}
+}
+
+// putLatencies tracks the latency of Datastore writes.
+var putLatencies = newLatencyTracker(defaultLatencyBufferSize)
+
+// record adds the latency of a write of the given kind, replacing the oldest
+// one if the buffer is full.
+func (t *latencyTracker) record(kind string, latency time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	buffer := t.latencies[kind]
+	if len(buffer) < t.size {
+		t.latencies[kind] = append(buffer, latency)
+		return
+	}
+
+	buffer[t.next[kind]] = latency
+	t.next[kind] = (t.next[kind] + 1) % t.size
+}
+
+// LatencySummary holds percentiles of the latencies of recent writes.
+type LatencySummary struct {
+	// Count is the number of writes the percentiles are computed from
+	Count int     `json:"count"`
+	P50MS float64 `json:"p50Ms"`
+	P90MS float64 `json:"p90Ms"`
+	P99MS float64 `json:"p99Ms"`
+}
+
+// summaries computes the percentiles of each kind's buffered latencies.
+func (t *latencyTracker) summaries() map[string]LatencySummary {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	summaries := make(map[string]LatencySummary)
+	for kind, buffer := range t.latencies {
+		sorted := make([]time.Duration, len(buffer))
+		copy(sorted, buffer)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+
+		summaries[kind] = LatencySummary{
+			Count: len(sorted),
+			P50MS: latencyPercentile(sorted, 50),
+			P90MS: latencyPercentile(sorted, 90),
+			P99MS: latencyPercentile(sorted, 99),
+		}
+	}
+
+	return summaries
+}
+
+// latencyPercentile returns the p-th percentile of the sorted latencies in
+// milliseconds, using the nearest-rank method.
+func latencyPercentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return float64(sorted[rank-1]) / float64(time.Millisecond)
+}
+
+// statsResponse is the response to a request for the server's stats.
+type statsResponse struct {
+	// PutLatency summarizes recent Datastore write latencies by kind
+	PutLatency map[string]LatencySummary `json:"putLatency"`
+}
+
+// newStatsHandler responds with percentiles of recent Datastore write
+// latencies. They only cover the writes handled by this instance.
+func newStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := newContext(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := statsResponse{PutLatency: putLatencies.summaries()}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
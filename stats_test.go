@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := newLatencyTracker(100)
+	// 1ms to 100ms, recorded out of order
+	for i := 0; i < 100; i++ {
+		tracker.record("Error", time.Duration((i*37)%100+1)*time.Millisecond)
+	}
+	tracker.record("REPLCommand", 7*time.Millisecond)
+
+	summaries := tracker.summaries()
+	want := LatencySummary{Count: 100, P50MS: 50, P90MS: 90, P99MS: 99}
+	if got := summaries["Error"]; got != want {
+		t.Errorf("Error summary = %+v, want %+v", got, want)
+	}
+	// A single latency is every percentile
+	want = LatencySummary{Count: 1, P50MS: 7, P90MS: 7, P99MS: 7}
+	if got := summaries["REPLCommand"]; got != want {
+		t.Errorf("REPLCommand summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestLatencyTrackerRingBuffer(t *testing.T) {
+	tracker := newLatencyTracker(10)
+	for i := 0; i < 10; i++ {
+		tracker.record("Error", time.Second)
+	}
+	// These replace the oldest latencies, so the slow ones age out
+	for i := 1; i <= 10; i++ {
+		tracker.record("Error", time.Duration(i)*time.Millisecond)
+	}
+
+	want := LatencySummary{Count: 10, P50MS: 5, P90MS: 9, P99MS: 10}
+	if got := tracker.summaries()["Error"]; got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	useFakeStore(t)
+	realLatencies := putLatencies
+	putLatencies = newLatencyTracker(10)
+	t.Cleanup(func() { putLatencies = realLatencies })
+
+	putLatencies.record(batchPutKind, 4*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	newStatsHandler(w, httptest.NewRequest("GET", "/stats", nil))
+
+	var resp statsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := LatencySummary{Count: 1, P50MS: 4, P90MS: 4, P99MS: 4}
+	if got := resp.PutLatency[batchPutKind]; got != want || len(resp.PutLatency) != 1 {
+		t.Errorf("stats = %+v, want only %v: %+v", resp.PutLatency, batchPutKind, want)
+	}
+}
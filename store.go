@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// storeKey is the key of a stored event. Only the store that returned it
+// knows what's inside.
+type storeKey interface {
+	// Encode returns the key as an opaque string that's safe to send to
+	// clients.
+	Encode() string
+}
+
+// eventStore is where the handlers keep events. They only reach Datastore
+// through it, so that the API can run outside of App Engine and be tested
+// without a Datastore.
+type eventStore interface {
+	// put stores an event of the given kind under a new key.
+	put(ctx context.Context, kind string, content event) (storeKey, error)
+	// putIfAbsent stores an event of the given kind under the named key,
+	// unless an event already exists there. It returns the event that's
+	// stored under the key, and whether it was created by this call. The
+	// check and write happen in a transaction, so concurrent calls with the
+	// same name store at most one event.
+	putIfAbsent(ctx context.Context, kind, name string, content event) (stored event, key storeKey, created bool, err error)
+	// putMulti stores each event under a new key of the kind at the same
+	// index, in a single write. If only some of them couldn't be stored,
+	// the returned slice holds the error of each event, and is nil if all
+	// were. An error is returned if the write failed as a whole.
+	putMulti(ctx context.Context, kinds []string, contents []event) ([]error, error)
+	// getAll loads every event of the kind with the UID into dst, which is
+	// a pointer to a slice of the kind's type.
+	getAll(ctx context.Context, kind, uid string, dst interface{}) error
+	// keys returns the keys of every event of the kind with the UID.
+	keys(ctx context.Context, kind, uid string) ([]storeKey, error)
+	// deleteMulti deletes the events with the given keys, reporting errors
+	// like putMulti.
+	deleteMulti(ctx context.Context, keys []storeKey) ([]error, error)
+	// ping makes the cheapest possible round trip to the store, to check
+	// that it's reachable.
+	ping(ctx context.Context) error
+}
+
+// requestLogger writes the logs of a request.
+type requestLogger interface {
+	Infof(ctx context.Context, format string, args ...interface{})
+	Warningf(ctx context.Context, format string, args ...interface{})
+	Errorf(ctx context.Context, format string, args ...interface{})
+}
+
+// The environment the handlers run in. They default to App Engine's, and
+// main replaces them when the API runs elsewhere.
+var (
+	// newContext returns the context to handle a request with
+	newContext = appengine.NewContext
+	// store is where events are kept
+	store eventStore = appengineStore{}
+	// logger is where the logs of each request are written
+	logger requestLogger = appengineLogger{}
+)
+
+// appengineStore is an eventStore backed by App Engine's Datastore API. It
+// needs a context from appengine.NewContext.
+type appengineStore struct{}
+
+func (appengineStore) put(ctx context.Context, kind string, content event) (storeKey, error) {
+	return datastore.Put(ctx, datastore.NewIncompleteKey(ctx, kind, nil), content)
+}
+
+func (appengineStore) putIfAbsent(ctx context.Context, kind, name string, content event) (event, storeKey, bool, error) {
+	key := datastore.NewKey(ctx, kind, name, 0, nil)
+
+	var stored event
+	var created bool
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		existing := newEventLike(content)
+		err := datastore.Get(tc, key, existing)
+		if err == nil {
+			stored, created = existing, false
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		stored, created = content, true
+		_, err = datastore.Put(tc, key, content)
+		return err
+	}, nil)
+
+	return stored, key, created, err
+}
+
+func (appengineStore) putMulti(ctx context.Context, kinds []string, contents []event) ([]error, error) {
+	keys := make([]*datastore.Key, len(kinds))
+	src := make([]interface{}, len(contents))
+	for i, kind := range kinds {
+		keys[i] = datastore.NewIncompleteKey(ctx, kind, nil)
+		src[i] = contents[i]
+	}
+
+	_, err := datastore.PutMulti(ctx, keys, src)
+	return splitMultiError(err)
+}
+
+func (appengineStore) getAll(ctx context.Context, kind, uid string, dst interface{}) error {
+	_, err := datastore.NewQuery(kind).Filter("UID =", uid).GetAll(ctx, dst)
+	return err
+}
+
+func (appengineStore) keys(ctx context.Context, kind, uid string) ([]storeKey, error) {
+	keys, err := datastore.NewQuery(kind).
+		Filter("UID =", uid).
+		KeysOnly().
+		GetAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	storeKeys := make([]storeKey, len(keys))
+	for i, key := range keys {
+		storeKeys[i] = key
+	}
+	return storeKeys, nil
+}
+
+func (appengineStore) deleteMulti(ctx context.Context, keys []storeKey) ([]error, error) {
+	datastoreKeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		datastoreKeys[i] = key.(*datastore.Key)
+	}
+
+	return splitMultiError(datastore.DeleteMulti(ctx, datastoreKeys))
+}
+
+func (appengineStore) ping(ctx context.Context) error {
+	_, err := datastore.NewQuery(datatypes.REPLCommandKind).
+		KeysOnly().
+		Limit(1).
+		GetAll(ctx, nil)
+	return err
+}
+
+// splitMultiError separates the per-entity errors of a multi-entity call from
+// an error that failed the whole call.
+func splitMultiError(err error) ([]error, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	multiErr, ok := err.(appengine.MultiError)
+	if !ok {
+		return nil, err
+	}
+	return multiErr, nil
+}
+
+// newEventLike returns a new, empty event of the same type as content.
+func newEventLike(content event) event {
+	return reflect.New(reflect.TypeOf(content).Elem()).Interface().(event)
+}
+
+// appengineLogger writes logs with App Engine's logging API. It needs a
+// context from appengine.NewContext.
+type appengineLogger struct{}
+
+func (appengineLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+	log.Infof(ctx, format, args...)
+}
+
+func (appengineLogger) Warningf(ctx context.Context, format string, args ...interface{}) {
+	log.Warningf(ctx, format, args...)
+}
+
+func (appengineLogger) Errorf(ctx context.Context, format string, args ...interface{}) {
+	log.Errorf(ctx, format, args...)
+}
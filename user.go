@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+const (
+	// defaultEventsLimit is the number of events returned by the events
+	// handler when the request doesn't specify a limit.
+	defaultEventsLimit = 100
+	// maxEventsLimit is the most events the events handler will return at
+	// once.
+	maxEventsLimit = 1000
+)
+
+// Event type discriminators used when events of different kinds are returned
+// together.
+const (
+	replCommandType   = "replCommand"
+	editorContentType = "editorContent"
+	errorType         = "error"
+)
+
+// userEvents holds every event recorded for a single UID.
+type userEvents struct {
+	REPLCommands   []datatypes.REPLCommand   `json:"replCommands"`
+	EditorContents []datatypes.EditorContent `json:"editorContents"`
+	Errors         []datatypes.ErrorInstance `json:"errors"`
+}
+
+// count returns the total number of events.
+func (u userEvents) count() int {
+	return len(u.REPLCommands) + len(u.EditorContents) + len(u.Errors)
+}
+
+// sortByTimestamp sorts the events of each kind chronologically.
+func (u userEvents) sortByTimestamp() {
+	sort.SliceStable(u.REPLCommands, func(i, j int) bool {
+		return u.REPLCommands[i].Timestamp < u.REPLCommands[j].Timestamp
+	})
+	sort.SliceStable(u.EditorContents, func(i, j int) bool {
+		return u.EditorContents[i].Timestamp < u.EditorContents[j].Timestamp
+	})
+	sort.SliceStable(u.Errors, func(i, j int) bool {
+		return u.Errors[i].Timestamp < u.Errors[j].Timestamp
+	})
+}
+
+// timelineEvent is an event of any kind, tagged with its type.
+type timelineEvent struct {
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	Event     interface{} `json:"event"`
+}
+
+// timeline returns all the events merged together in chronological order.
+func (u userEvents) timeline() []timelineEvent {
+	var timeline []timelineEvent
+	for _, cmd := range u.REPLCommands {
+		timeline = append(timeline, timelineEvent{replCommandType, cmd.Timestamp, cmd})
+	}
+	for _, content := range u.EditorContents {
+		timeline = append(timeline, timelineEvent{editorContentType, content.Timestamp, content})
+	}
+	for _, instance := range u.Errors {
+		timeline = append(timeline, timelineEvent{errorType, instance.Timestamp, instance})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Timestamp < timeline[j].Timestamp
+	})
+
+	return timeline
+}
+
+// getUserEvents queries every event recorded for the UID.
+func getUserEvents(ctx context.Context, uid string) (userEvents, error) {
+	var events userEvents
+
+	if err := store.getAll(ctx, datatypes.REPLCommandKind, uid, &events.REPLCommands); err != nil {
+		return userEvents{}, fmt.Errorf("getting REPL commands: %v", err)
+	}
+
+	if err := store.getAll(ctx, datatypes.EditorContentKind, uid, &events.EditorContents); err != nil {
+		return userEvents{}, fmt.Errorf("getting editor contents: %v", err)
+	}
+
+	if err := store.getAll(ctx, datatypes.ErrorInstanceKind, uid, &events.Errors); err != nil {
+		return userEvents{}, fmt.Errorf("getting errors: %v", err)
+	}
+
+	return events, nil
+}
+
+// newUserHandler routes requests for the data of a single user, which have
+// paths of the form /user/{uid}/{resource}.
+func newUserHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/user/"), "/")
+	uid := parts[0]
+	if datatypes.ValidateUID(uid) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	setLogUID(w, uid)
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != "DELETE" {
+			http.Error(w, "Only DELETE requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		newDeleteUserHandler(w, r, uid)
+	case len(parts) == 2 && parts[1] == "events":
+		if r.Method != "GET" {
+			http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		newUserEventsHandler(w, r, uid)
+	case len(parts) == 2 && parts[1] == "export":
+		if r.Method != "GET" {
+			http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		newUserExportHandler(w, r, uid)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newUserEventsHandler responds with the events of a user in chronological
+// order. The "limit" and "offset" query parameters select which page of
+// events is returned.
+func newUserEventsHandler(w http.ResponseWriter, r *http.Request, uid string) {
+	ctx := newContext(r)
+
+	limit, err := intQueryParam(r, "limit", defaultEventsLimit)
+	if err != nil || limit <= 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+
+	offset, err := intQueryParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	events, err := getUserEvents(ctx, uid)
+	if err != nil {
+		logger.Errorf(ctx, "could not read events: %v", err)
+		http.Error(w, "Could not get events", 500)
+		return
+	}
+	if events.count() == 0 {
+		http.Error(w, "No events found for UID", http.StatusNotFound)
+		return
+	}
+
+	timeline := events.timeline()
+	if offset > len(timeline) {
+		offset = len(timeline)
+	}
+	timeline = timeline[offset:]
+	if len(timeline) > limit {
+		timeline = timeline[:limit]
+	}
+	if timeline == nil {
+		timeline = []timelineEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(timeline); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
+
+// userExport is a portable copy of every event recorded for a user.
+type userExport struct {
+	UID string `json:"uid"`
+	userEvents
+}
+
+// newUserExportHandler responds with every event of a user as a single JSON
+// document to be downloaded, with the events of each kind in chronological
+// order.
+func newUserExportHandler(w http.ResponseWriter, r *http.Request, uid string) {
+	ctx := newContext(r)
+
+	events, err := getUserEvents(ctx, uid)
+	if err != nil {
+		logger.Errorf(ctx, "could not read events: %v", err)
+		http.Error(w, "Could not get events", 500)
+		return
+	}
+	if events.count() == 0 {
+		http.Error(w, "No events found for UID", http.StatusNotFound)
+		return
+	}
+
+	events.sortByTimestamp()
+	// Kinds without events are exported as empty arrays rather than null
+	if events.REPLCommands == nil {
+		events.REPLCommands = []datatypes.REPLCommand{}
+	}
+	if events.EditorContents == nil {
+		events.EditorContents = []datatypes.EditorContent{}
+	}
+	if events.Errors == nil {
+		events.Errors = []datatypes.ErrorInstance{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": uid + "-export.json",
+	}))
+	if err := json.NewEncoder(w).Encode(userExport{uid, events}); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
+
+// maxDeleteBatchSize is the most entities Datastore will delete in one
+// DeleteMulti call.
+const maxDeleteBatchSize = 500
+
+// userKinds are the kinds of entities that hold a user's data.
+var userKinds = []string{
+	datatypes.REPLCommandKind,
+	datatypes.EditorContentKind,
+	datatypes.ErrorInstanceKind,
+}
+
+// deleteUserResponse reports how many entities of each kind were deleted and
+// how many could not be. For a dry run, Deleted is how many would have been.
+type deleteUserResponse struct {
+	DryRun  bool           `json:"dryRun,omitempty"`
+	Deleted map[string]int `json:"deleted"`
+	Failed  map[string]int `json:"failed,omitempty"`
+}
+
+// newDeleteUserHandler deletes every event recorded for a user. Deleting a
+// user that has no events succeeds with zero counts, so the request can be
+// safely retried. If some entities can't be deleted, the response reports
+// how many with a 500 status and the rest are still deleted. With the
+// "dryRun=true" query parameter, nothing is deleted and the response reports
+// what would have been.
+func newDeleteUserHandler(w http.ResponseWriter, r *http.Request, uid string) {
+	ctx := newContext(r)
+
+	dryRun, err := boolQueryParam(r, "dryRun", false)
+	if err != nil {
+		http.Error(w, "Invalid dryRun", http.StatusBadRequest)
+		return
+	}
+
+	resp := deleteUserResponse{
+		DryRun:  dryRun,
+		Deleted: make(map[string]int),
+		Failed:  make(map[string]int),
+	}
+
+	for _, kind := range userKinds {
+		keys, err := store.keys(ctx, kind, uid)
+		if err != nil {
+			logger.Errorf(ctx, "could not query %v keys: %v", kind, err)
+			http.Error(w, "Could not delete user", 500)
+			return
+		}
+
+		if dryRun {
+			resp.Deleted[kind] = len(keys)
+			continue
+		}
+
+		resp.Deleted[kind] = 0
+		for len(keys) > 0 {
+			batch := keys
+			if len(batch) > maxDeleteBatchSize {
+				batch = batch[:maxDeleteBatchSize]
+			}
+			keys = keys[len(batch):]
+
+			deleted, failed := deleteKeys(ctx, batch)
+			resp.Deleted[kind] += deleted
+			if failed > 0 {
+				resp.Failed[kind] += failed
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if len(resp.Failed) > 0 {
+		status = 500
+	}
+
+	if dryRun {
+		logger.Infof(ctx, "Dry run of deleting user %v: %v", uid, resp)
+	} else {
+		logger.Infof(ctx, "Deleted user %v: %v", uid, resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf(ctx, "failed to send response: %v", err)
+		return
+	}
+}
+
+// deleteKeys deletes the entities with the given keys, returning how many
+// were and weren't deleted.
+func deleteKeys(ctx context.Context, keys []storeKey) (deleted, failed int) {
+	keyErrs, err := store.deleteMulti(ctx, keys)
+	if err != nil {
+		logger.Errorf(ctx, "could not delete from datastore: %v", err)
+		return 0, len(keys)
+	}
+	if keyErrs == nil {
+		return len(keys), 0
+	}
+
+	for _, keyErr := range keyErrs {
+		if keyErr != nil {
+			logger.Errorf(ctx, "could not delete from datastore: %v", keyErr)
+			failed++
+		} else {
+			deleted++
+		}
+	}
+
+	return deleted, failed
+}
+
+// intQueryParam parses the named query parameter as an integer, returning def
+// if it isn't present.
+func intQueryParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// boolQueryParam parses the named query parameter as a boolean, returning def
+// if it isn't present.
+func boolQueryParam(r *http.Request, name string, def bool) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.ParseBool(value)
+}
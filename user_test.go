@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/velovix/lambda-starship-user-stats/datatypes"
+)
+
+func TestDeleteUser(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+
+	for _, body := range []string{`{"uid":"player"}`, `{"uid":"player"}`, `{"uid":"other"}`} {
+		if w := postEvent(newREPLCommandHandler, body); w.Code != http.StatusOK {
+			t.Fatalf("storing %v: status = %v", body, w.Code)
+		}
+	}
+	if w := postEvent(newErrorHandler, `{"uid":"player"}`); w.Code != http.StatusOK {
+		t.Fatalf("storing error: status = %v", w.Code)
+	}
+
+	deleteUser := func(target string) deleteUserResponse {
+		w := httptest.NewRecorder()
+		newUserHandler(w, httptest.NewRequest("DELETE", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("DELETE %v: status = %v, want %v", target, w.Code, http.StatusOK)
+		}
+
+		var resp deleteUserResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := deleteUser("/user/player?dryRun=true")
+	if resp.Deleted[datatypes.REPLCommandKind] != 2 || resp.Deleted[datatypes.ErrorInstanceKind] != 1 {
+		t.Errorf("dry run reported %v deleted", resp.Deleted)
+	}
+	if got := len(fake.stored(datatypes.REPLCommandKind)); got != 3 {
+		t.Errorf("dry run left %v commands, want 3", got)
+	}
+
+	resp = deleteUser("/user/player")
+	if resp.Deleted[datatypes.REPLCommandKind] != 2 || resp.Deleted[datatypes.ErrorInstanceKind] != 1 {
+		t.Errorf("reported %v deleted", resp.Deleted)
+	}
+	remaining := fake.stored(datatypes.REPLCommandKind)
+	if len(remaining) != 1 || eventUID(remaining[0]) != "other" {
+		t.Errorf("left %v commands, want only the other user's", len(remaining))
+	}
+
+	// Deleting again succeeds with nothing deleted
+	resp = deleteUser("/user/player")
+	if resp.Deleted[datatypes.REPLCommandKind] != 0 {
+		t.Errorf("second delete reported %v deleted", resp.Deleted)
+	}
+}
+
+func TestDeleteUserPartialFailure(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	fake.undeletableKinds[datatypes.ErrorInstanceKind] = true
+
+	for _, handler := range []http.HandlerFunc{newREPLCommandHandler, newErrorHandler, newErrorHandler} {
+		if w := postEvent(handler, `{"uid":"player"}`); w.Code != http.StatusOK {
+			t.Fatalf("storing event: status = %v", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("DELETE", "/user/player", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+
+	var resp deleteUserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Deleted[datatypes.REPLCommandKind] != 1 || resp.Deleted[datatypes.ErrorInstanceKind] != 0 {
+		t.Errorf("reported %v deleted", resp.Deleted)
+	}
+	if resp.Failed[datatypes.ErrorInstanceKind] != 2 || resp.Failed[datatypes.REPLCommandKind] != 0 {
+		t.Errorf("reported %v failed", resp.Failed)
+	}
+
+	// What could be deleted still was
+	if got := len(fake.stored(datatypes.REPLCommandKind)); got != 0 {
+		t.Errorf("left %v commands, want 0", got)
+	}
+	if got := len(fake.stored(datatypes.ErrorInstanceKind)); got != 2 {
+		t.Errorf("left %v errors, want the 2 that failed", got)
+	}
+
+	// Once the store recovers, a retry deletes the rest
+	delete(fake.undeletableKinds, datatypes.ErrorInstanceKind)
+	w = httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("DELETE", "/user/player", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("retry: status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := len(fake.stored(datatypes.ErrorInstanceKind)); got != 0 {
+		t.Errorf("retry left %v errors, want 0", got)
+	}
+}
+
+// getUser sends a GET request for the target to the user handler.
+func getUser(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func TestUserEvents(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+
+	for _, event := range []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{newErrorHandler, `{"uid":"player","timestamp":3000,"description":"Too many arguments"}`},
+		{newREPLCommandHandler, `{"uid":"player","timestamp":2000,"command":"(help 1 2)"}`},
+		{newEditorContentHandler, `{"uid":"player","timestamp":1000,"content":"(fire)"}`},
+		{newREPLCommandHandler, `{"uid":"player","timestamp":4000,"command":"(fire)"}`},
+		{newREPLCommandHandler, `{"uid":"other","timestamp":1500,"command":"(help)"}`},
+	} {
+		if w := postEvent(event.handler, event.body); w.Code != http.StatusOK {
+			t.Fatalf("storing %v: status = %v", event.body, w.Code)
+		}
+	}
+
+	w := getUser(t, "/user/player/events")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var timeline []struct {
+		Type      string          `json:"type"`
+		Timestamp int64           `json:"timestamp"`
+		Event     json.RawMessage `json:"event"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every kind is merged into one chronological list of only the UID's
+	// events, each tagged with its type
+	want := []struct {
+		typ       string
+		timestamp int64
+	}{
+		{editorContentType, 1000},
+		{replCommandType, 2000},
+		{errorType, 3000},
+		{replCommandType, 4000},
+	}
+	if len(timeline) != len(want) {
+		t.Fatalf("got %v events, want %v", len(timeline), len(want))
+	}
+	for i, event := range timeline {
+		if event.Type != want[i].typ || event.Timestamp != want[i].timestamp {
+			t.Errorf("event %v is a %v at %v, want a %v at %v", i, event.Type, event.Timestamp, want[i].typ, want[i].timestamp)
+		}
+		var fields struct {
+			UID string `json:"uid"`
+		}
+		if err := json.Unmarshal(event.Event, &fields); err != nil || fields.UID != "player" {
+			t.Errorf("event %v is %s, want one of player's events", i, event.Event)
+		}
+	}
+
+	paged := getUser(t, "/user/player/events?limit=2&offset=1")
+	if err := json.Unmarshal(paged.Body.Bytes(), &timeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 2 || timeline[0].Timestamp != 2000 || timeline[1].Timestamp != 3000 {
+		t.Errorf("page of 2 after the first = %+v, want the events at 2000 and 3000", timeline)
+	}
+
+	past := getUser(t, "/user/player/events?offset=10")
+	if past.Code != http.StatusOK || strings.TrimSpace(past.Body.String()) != "[]" {
+		t.Errorf("offset past the end = %v %q, want an empty array", past.Code, past.Body.String())
+	}
+}
+
+func TestUserEventsLimitCap(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	for i := 0; i < maxEventsLimit+1; i++ {
+		fake.put(context.Background(), datatypes.ErrorInstanceKind, &datatypes.ErrorInstance{UID: "player", Timestamp: int64(i)})
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", defaultEventsLimit},
+		{"?limit=5", 5},
+		{"?limit=" + strconv.Itoa(maxEventsLimit), maxEventsLimit},
+		{"?limit=" + strconv.Itoa(maxEventsLimit+1), maxEventsLimit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			var timeline []json.RawMessage
+			if err := json.Unmarshal(getUser(t, "/user/player/events"+test.query).Body.Bytes(), &timeline); err != nil {
+				t.Fatal(err)
+			}
+			if len(timeline) != test.want {
+				t.Errorf("got %v events, want %v", len(timeline), test.want)
+			}
+		})
+	}
+}
+
+func TestUserEventsRejected(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+	if w := postEvent(newErrorHandler, `{"uid":"player"}`); w.Code != http.StatusOK {
+		t.Fatalf("storing error: status = %v", w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"unknown UID", "/user/nobody/events", http.StatusNotFound},
+		{"blank UID", "/user/%20/events", http.StatusNotFound},
+		{"zero limit", "/user/player/events?limit=0", http.StatusBadRequest},
+		{"negative offset", "/user/player/events?offset=-1", http.StatusBadRequest},
+		{"unknown resource", "/user/player/sessions", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := getUser(t, test.target); w.Code != test.want {
+				t.Errorf("status = %v, want %v", w.Code, test.want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("POST", "/user/player/events", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDeleteUserPaths(t *testing.T) {
+	commands := maxDeleteBatchSize + 1
+	tests := []struct {
+		name       string
+		query      string
+		wantDryRun bool
+	}{
+		{"default", "", false},
+		{"real", "?dryRun=false", false},
+		{"dry run", "?dryRun=true", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newFakeClock(t)
+			fake := useFakeStore(t)
+			ctx := context.Background()
+			for i := 0; i < commands; i++ {
+				fake.put(ctx, datatypes.REPLCommandKind, &datatypes.REPLCommand{UID: "player"})
+			}
+			fake.put(ctx, datatypes.EditorContentKind, &datatypes.EditorContent{UID: "player"})
+			fake.put(ctx, datatypes.ErrorInstanceKind, &datatypes.ErrorInstance{UID: "player"})
+			fake.put(ctx, datatypes.REPLCommandKind, &datatypes.REPLCommand{UID: "other"})
+			fake.put(ctx, datatypes.EditorContentKind, &datatypes.EditorContent{UID: "other"})
+			fake.put(ctx, datatypes.ErrorInstanceKind, &datatypes.ErrorInstance{UID: "other"})
+
+			w := httptest.NewRecorder()
+			newUserHandler(w, httptest.NewRequest("DELETE", "/user/player"+test.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+			}
+			var resp deleteUserResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.DryRun != test.wantDryRun {
+				t.Errorf("dryRun = %v, want %v", resp.DryRun, test.wantDryRun)
+			}
+			wantDeleted := map[string]int{
+				datatypes.REPLCommandKind:   commands,
+				datatypes.EditorContentKind: 1,
+				datatypes.ErrorInstanceKind: 1,
+			}
+			if !reflect.DeepEqual(resp.Deleted, wantDeleted) {
+				t.Errorf("deleted = %v, want %v", resp.Deleted, wantDeleted)
+			}
+			if len(resp.Failed) != 0 {
+				t.Errorf("failed = %v, want none", resp.Failed)
+			}
+
+			// Batches of one kind are split to the store's limit
+			var batches []int
+			if !test.wantDryRun {
+				batches = []int{maxDeleteBatchSize, 1, 1, 1}
+			}
+			if !reflect.DeepEqual(fake.deleteBatches, batches) {
+				t.Errorf("deleted in batches of %v, want %v", fake.deleteBatches, batches)
+			}
+
+			for kind, deleted := range wantDeleted {
+				want := 1
+				if test.wantDryRun {
+					want += deleted
+				}
+				remaining := fake.stored(kind)
+				if len(remaining) != want {
+					t.Errorf("%v left, want %v", len(remaining), want)
+				}
+				for _, content := range remaining {
+					if !test.wantDryRun && eventUID(content) == "player" {
+						t.Errorf("player's %v was not deleted", kind)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteUserInvalidDryRun(t *testing.T) {
+	newFakeClock(t)
+	fake := useFakeStore(t)
+	fake.put(context.Background(), datatypes.REPLCommandKind, &datatypes.REPLCommand{UID: "player"})
+
+	w := httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("DELETE", "/user/player?dryRun=maybe", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+	if got := len(fake.stored(datatypes.REPLCommandKind)); got != 1 {
+		t.Errorf("%v commands left, want 1", got)
+	}
+}
+
+func TestUserExport(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+
+	for _, event := range []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{newREPLCommandHandler, `{"uid":"player","timestamp":4000,"command":"(fire)"}`},
+		{newErrorHandler, `{"uid":"player","timestamp":3000,"description":"Too many arguments"}`},
+		{newREPLCommandHandler, `{"uid":"player","timestamp":2000,"command":"(help 1 2)"}`},
+		{newREPLCommandHandler, `{"uid":"other","timestamp":1500,"command":"(help)"}`},
+	} {
+		if w := postEvent(event.handler, event.body); w.Code != http.StatusOK {
+			t.Fatalf("storing %v: status = %v", event.body, w.Code)
+		}
+	}
+
+	w := getUser(t, "/user/player/export")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	disposition, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+	if err != nil || disposition != "attachment" || params["filename"] != "player-export.json" {
+		t.Errorf("Content-Disposition = %q, want an attachment named player-export.json", w.Header().Get("Content-Disposition"))
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+
+	// Each kind has its own array, including the kinds without any events
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"uid", "replCommands", "editorContents", "errors"} {
+		if _, ok := document[field]; !ok {
+			t.Errorf("the export has no %q field: %s", field, w.Body.String())
+		}
+	}
+	if got := string(document["editorContents"]); got != "[]" {
+		t.Errorf("editorContents = %s, want an empty array", got)
+	}
+
+	var export userExport
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.UID != "player" {
+		t.Errorf("uid = %q, want player", export.UID)
+	}
+	// Only the UID's events are included, in chronological order
+	var commandTimestamps []int64
+	for _, cmd := range export.REPLCommands {
+		commandTimestamps = append(commandTimestamps, cmd.Timestamp)
+	}
+	if want := []int64{2000, 4000}; !reflect.DeepEqual(commandTimestamps, want) {
+		t.Errorf("replCommands are at %v, want %v", commandTimestamps, want)
+	}
+	if len(export.Errors) != 1 || export.Errors[0].Description != "Too many arguments" {
+		t.Errorf("errors = %+v, want player's one error", export.Errors)
+	}
+}
+
+func TestUserExportRejected(t *testing.T) {
+	newFakeClock(t)
+	useFakeStore(t)
+	if w := postEvent(newErrorHandler, `{"uid":"player"}`); w.Code != http.StatusOK {
+		t.Fatalf("storing error: status = %v", w.Code)
+	}
+
+	if w := getUser(t, "/user/nobody/export"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown UID status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+	if w := getUser(t, "/user/nobody/export"); w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("unknown UID Content-Disposition = %q, want none", w.Header().Get("Content-Disposition"))
+	}
+
+	w := httptest.NewRecorder()
+	newUserHandler(w, httptest.NewRequest("DELETE", "/user/player/export", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+}